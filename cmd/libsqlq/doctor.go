@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	create := fs.Bool("create", false, "create the advised indexes")
+	repair := fs.Bool("repair", false, "repair the problems a crash left behind")
+	worker := fs.String("worker", "", "worker id of a crashed process whose claims to release")
+	claimTimeout := fs.Duration("claim-timeout", 30*time.Second, "claim timeout the workers use")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	q.WithClaimTimeout(*claimTimeout).WithWorkerID(*worker)
+	report, err := q.VerifyWarmStart(*repair)
+	if err != nil {
+		return err
+	}
+	if problems := report.Problems(); len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if report.Repaired {
+			fmt.Println("repaired everything but schema problems")
+		} else {
+			fmt.Println("\nrun again with -repair to repair them")
+		}
+	}
+	advice, err := q.AdviseIndexes()
+	if err != nil {
+		return err
+	}
+	if len(advice) == 0 {
+		fmt.Println("no missing indexes")
+		return nil
+	}
+	for _, index := range advice {
+		fmt.Printf("missing index %s on %s: %s\n  plan: %s\n  fix:  %s\n", index.Name, index.Table, index.Reason, index.Plan, index.Statement)
+	}
+	if !*create {
+		fmt.Println("\nrun again with -create to create them")
+		return nil
+	}
+	if err := q.CreateIndexes(advice); err != nil {
+		return err
+	}
+	fmt.Printf("created %d indexes\n", len(advice))
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+func runGrafana(args []string) error {
+	fs := flag.NewFlagSet("grafana", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	addr := fs.String("addr", ":3001", "address to serve the statistics on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving statistics for Grafana on %s\n", *addr)
+	return http.ListenAndServe(*addr, q.GrafanaHandler())
+}
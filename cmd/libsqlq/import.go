@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	file := fs.String("file", "-", "file to read events from, - for stdin")
+	format := fs.String("format", "ndjson", "format of the file: ndjson or csv")
+	batch := fs.Int("batch", 1000, "events inserted per transaction")
+	rate := fs.Int("rate", 0, "maximum events inserted per second, 0 for unlimited")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	input := os.Stdin
+	if *file != "-" {
+		input, err = os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = input.Close()
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+	imported, err := q.Import(ctx, input, queue.ImportOptions{
+		Format:        queue.ImportFormat(*format),
+		BatchSize:     *batch,
+		RatePerSecond: *rate,
+		Progress: func(n int) {
+			elapsed := time.Since(start).Seconds()
+			fmt.Fprintf(os.Stderr, "\rimported %d events (%.0f/s)", n, float64(n)/max(elapsed, 0.001))
+		},
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("stopped after %d events: %w", imported, err)
+	}
+	fmt.Printf("imported %d events in %s\n", imported, time.Since(start).Round(time.Millisecond))
+	return nil
+}
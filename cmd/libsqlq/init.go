@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// What `libsqlq init` can scaffold
+var scaffolds = map[string]func(args []string) error{
+	"worker": initWorker,
+}
+
+func runInit(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: libsqlq init worker [flags]")
+	}
+	scaffold, ok := scaffolds[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown scaffold %q, expected worker", args[0])
+	}
+	return scaffold(args[1:])
+}
+
+type workerScaffold struct {
+	Queue   string
+	Payload string
+	Addr    string
+}
+
+func initWorker(args []string) error {
+	fs := flag.NewFlagSet("init worker", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory to write main.go to")
+	queueName := fs.String("queue", "events", "name of the local queue the worker consumes")
+	payload := fs.String("payload", "Payload", "name of the generated payload type")
+	addr := fs.String("addr", ":8080", "default address of the metrics and health endpoints")
+	force := fs.Bool("force", false, "overwrite an existing main.go")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := filepath.Join(*dir, "main.go")
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists, pass -force to overwrite it", path)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := workerTemplate.Execute(&buf, workerScaffold{Queue: *queueName, Payload: *payload, Addr: *addr}); err != nil {
+		return err
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("problem formatting worker: %w", err)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, source, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s, fill in handle and run it with: cd %s && go run .\n", path, *dir)
+	return nil
+}
+
+var workerTemplate = template.Must(template.New("worker").Parse(`// A libsqlq worker consuming the {{.Queue}} queue, generated by libsqlq init worker.
+//
+// It processes events until interrupted, finishing the event in hand before exiting, and
+// serves Prometheus metrics on /metrics and a health check on /healthz.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"libsqlq/queue"
+)
+
+// The events on the {{.Queue}} queue
+type {{.Payload}} struct {
+	// TODO: the fields of your events
+}
+
+// Process a single event. Returning nil acks it, returning an error nacks it so it is
+// retried after the backoff
+func handle(ctx context.Context, event *queue.Event[{{.Payload}}]) error {
+	// TODO: do the work
+	slog.Info("processing event", "id", event.Id, "retries", event.Envelope.Retries)
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "{{.Addr}}", "address of the metrics and health endpoints")
+	queueName := flag.String("queue", "{{.Queue}}", "name of the local queue to consume")
+	flag.Parse()
+
+	if err := run(*addr, *queueName); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(addr string, queueName string) error {
+	// Labels end up in logs, metrics and every envelope, handy once there are many workers
+	q, err := queue.NewLocalQueue[{{.Payload}}](queueName, queue.WithLabels(map[string]string{"service": "{{.Queue}}-worker"}))
+	if err != nil {
+		return fmt.Errorf("problem opening queue %s: %w", queueName, err)
+	}
+	metrics := &transitionCounts{counts: map[queue.Transition]int{}}
+	q.WithOnTransition(metrics.record).WithHealthPausing(5, time.Minute)
+
+	server := &http.Server{Addr: addr, Handler: routes(q, metrics)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(fmt.Sprintf("problem serving metrics: %v", err))
+		}
+	}()
+
+	// The first interrupt stops claiming new events, the event in hand is still finished
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("worker started", "queue", queueName, "addr", addr)
+	for ctx.Err() == nil {
+		summary, err := q.ProcessFor(ctx, time.Minute, handle)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error(fmt.Sprintf("problem processing events: %v", err))
+			time.Sleep(time.Second)
+		}
+		slog.Debug("processed events", "processed", summary.Processed, "failed", summary.Failed)
+	}
+
+	slog.Info("shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdown)
+}
+
+func routes(q *queue.Queue[{{.Payload}}], metrics *transitionCounts) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		maintenance := q.MaintenanceStatus()
+		if q.Health() != queue.HEALTH_HEALTHY || !maintenance.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "health: %s, maintenance failures: %d\n", q.Health(), maintenance.ConsecutiveFailures)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := q.Stats(queue.StatsFilter{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		labels := promLabels(q.Labels())
+		fmt.Fprintln(w, "# TYPE libsqlq_events gauge")
+		fmt.Fprintf(w, "libsqlq_events{%sstate=\"pending\"} %d\n", labels, stats.Pending)
+		fmt.Fprintf(w, "libsqlq_events{%sstate=\"inflight\"} %d\n", labels, stats.Inflight)
+		fmt.Fprintf(w, "libsqlq_events{%sstate=\"dead\"} %d\n", labels, stats.Dead)
+		fmt.Fprintln(w, "# TYPE libsqlq_transitions_total counter")
+		for transition, count := range metrics.snapshot() {
+			fmt.Fprintf(w, "libsqlq_transitions_total{%stransition=%q} %d\n", labels, transition, count)
+		}
+	})
+	return mux
+}
+
+// The queue's labels as Prometheus label pairs, each followed by a comma
+func promLabels(labels map[string]string) string {
+	pairs := []string{}
+	for key, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q,", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "")
+}
+
+// Counts every event transition of the queue, exposed on /metrics
+type transitionCounts struct {
+	lock   sync.Mutex
+	counts map[queue.Transition]int
+}
+
+func (c *transitionCounts) record(transition queue.Transition, _ queue.Envelope) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.counts[transition]++
+}
+
+func (c *transitionCounts) snapshot() map[queue.Transition]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counts := make(map[queue.Transition]int, len(c.counts))
+	for transition, count := range c.counts {
+		counts[transition] = count
+	}
+	return counts
+}
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"os/signal"
+)
+
+// Flags selecting the events list and export operate on
+func addListFlags(fs *flag.FlagSet) *queue.ListFilter {
+	filter := &queue.ListFilter{}
+	fs.StringVar(&filter.State, "state", "", "only events in this state: pending, inflight, dead or completed")
+	fs.StringVar(&filter.Tag, "tag", "", "only events carrying this tag")
+	fs.IntVar(&filter.Limit, "limit", 0, "at most this many events, 0 for all of them")
+	return filter
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	filter := addListFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	listed, err := q.StreamList(ctx, os.Stdout, *filter)
+	if err != nil {
+		return fmt.Errorf("stopped after %d events: %w", listed, err)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	filter := addListFlags(fs)
+	file := fs.String("file", "-", "file to write payloads to, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	output := os.Stdout
+	if *file != "-" {
+		output, err = os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = output.Close()
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	exported, err := q.Export(ctx, output, *filter)
+	if err != nil {
+		return fmt.Errorf("stopped after %d events: %w", exported, err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d events\n", exported)
+	return nil
+}
@@ -0,0 +1,92 @@
+// Command libsqlq is an operational tool for libsqlq queues. Payloads are treated as raw
+// JSON so it works with queues of any payload type.
+//
+//	libsqlq <command> [flags]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"doctor":     {"check for problems left by crashes and missing indexes", runDoctor},
+	"export":     {"write event payloads as NDJSON, in the format import reads", runExport},
+	"grafana":    {"serve statistics for Grafana's JSON and Infinity datasources", runGrafana},
+	"import":     {"bulk load events from an NDJSON or CSV file", runImport},
+	"init":       {"generate a runnable project scaffold: init worker", runInit},
+	"list":       {"stream events and their metadata as NDJSON", runList},
+	"migrate":    {"copy or move all events from one queue to another", runMigrate},
+	"pause":      {"stop every worker from claiming events", runPause},
+	"quarantine": {"show undecodable events next to the fields the payload type expects", runQuarantine},
+	"resume":     {"let workers claim events again after pause", runResume},
+	"simulate":   {"predict depth and latency for proposed worker counts", runSimulate},
+	"takeover":   {"take an in-flight event away from a wedged worker", runTakeover},
+	"top":        {"live view of depth, throughput, redeliveries and event ages", runTop},
+	"truncate":   {"write archived events to a file, then delete them from the database", runTruncate},
+	"warehouse":  {"periodically write new completed and dead events to CSV files", runWarehouse},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "libsqlq %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: libsqlq <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+// Flags shared by every command that operates on a single queue
+type queueFlags struct {
+	name  *string
+	turso *bool
+}
+
+func addQueueFlags(fs *flag.FlagSet) queueFlags {
+	return queueFlags{
+		name:  fs.String("queue", "", "name of the local queue in ./.db"),
+		turso: fs.Bool("turso", false, "use the Turso queue configured by TURSO_URL and TURSO_AUTH_TOKEN"),
+	}
+}
+
+// Open the queue without background maintenance, which is left to the queue's workers so
+// that inspecting a queue never moves or deletes its events
+func (f queueFlags) open() (*queue.Queue[json.RawMessage], error) {
+	if *f.turso {
+		return queue.NewTursoQueue[json.RawMessage](queue.WithSynchronousMaintenance())
+	}
+	if *f.name == "" {
+		return nil, fmt.Errorf("one of -queue or -turso is required")
+	}
+	return queue.NewLocalQueue[json.RawMessage](*f.name, queue.WithSynchronousMaintenance())
+}
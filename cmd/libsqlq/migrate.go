@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"os/signal"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := queueFlags{
+		name:  fs.String("from", "", "name of the local source queue in ./.db"),
+		turso: fs.Bool("from-turso", false, "use the Turso queue configured by TURSO_URL as the source"),
+	}
+	to := queueFlags{
+		name:  fs.String("to", "", "name of the local destination queue in ./.db"),
+		turso: fs.Bool("to-turso", false, "use the Turso queue configured by TURSO_URL as the destination"),
+	}
+	move := fs.Bool("move", false, "delete events from the source once copied")
+	batch := fs.Int("batch", 500, "events copied per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from.turso && *to.turso {
+		return fmt.Errorf("-from-turso and -to-turso refer to the same database")
+	}
+
+	src, err := from.open()
+	if err != nil {
+		return fmt.Errorf("problem opening source: %w", err)
+	}
+	dst, err := to.open()
+	if err != nil {
+		return fmt.Errorf("problem opening destination: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	migrated, err := src.MigrateTo(ctx, dst, queue.MigrateOptions{
+		Move:      *move,
+		BatchSize: *batch,
+		Progress: func(n int) {
+			fmt.Fprintf(os.Stderr, "\rmigrated %d events", n)
+		},
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("stopped after %d events, run again to resume: %w", migrated, err)
+	}
+	fmt.Printf("migrated %d events\n", migrated)
+	return nil
+}
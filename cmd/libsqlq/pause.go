@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+func runPause(args []string) error {
+	fs := flag.NewFlagSet("pause", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	reason := fs.String("reason", "", "why the queue is paused, e.g. a maintenance ticket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	if err := q.Pause(*reason); err != nil {
+		return err
+	}
+	fmt.Println("paused, no events will be claimed until resumed")
+	return nil
+}
+
+func runResume(args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	if err := q.Resume(); err != nil {
+		return err
+	}
+	fmt.Println("resumed")
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"strings"
+)
+
+func runQuarantine(args []string) error {
+	fs := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	schemaPath := fs.String("schema", "", "schema snapshot of the payload type, as written by CheckPayloadCompatibility")
+	limit := fs.Int("limit", 20, "show at most this many events, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *schemaPath == "" {
+		return fmt.Errorf("-schema is required")
+	}
+	data, err := os.ReadFile(*schemaPath)
+	if err != nil {
+		return err
+	}
+	var expected queue.PayloadSchema
+	if err := json.Unmarshal(data, &expected); err != nil {
+		return fmt.Errorf("problem decoding schema snapshot %s: %w", *schemaPath, err)
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	quarantined, err := q.Quarantined(*limit)
+	if err != nil {
+		return err
+	}
+	if len(quarantined) == 0 {
+		fmt.Println("no quarantined events")
+		return nil
+	}
+	for _, envelope := range quarantined {
+		fmt.Printf("event %d, enqueued %s\n  payload: %s\n", envelope.Id, envelope.EnqueuedAt.Format("2006-01-02 15:04:05"), envelope.Payload)
+		diff, err := queue.DiffPayload(envelope.Payload, expected)
+		if err != nil {
+			fmt.Printf("  %v\n\n", err)
+			continue
+		}
+		for _, section := range []struct {
+			label string
+			paths []string
+		}{{"mismatched", diff.Mismatched}, {"missing", diff.Missing}, {"extra", diff.Extra}} {
+			if len(section.paths) > 0 {
+				fmt.Printf("  %s: %s\n", section.label, strings.Join(section.paths, ", "))
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
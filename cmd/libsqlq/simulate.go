@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	workers := fs.String("workers", "1,2,4,8,16", "comma-separated worker counts to compare")
+	rate := fs.Float64("rate", 0, "events enqueued per second, exponentially spaced, instead of replaying the queue's arrivals")
+	processing := fs.Duration("processing", 0, "mean processing time, exponentially distributed, instead of replaying the queue's attempts")
+	failureRate := fs.Float64("failure-rate", -1, "fraction of attempts that fail, defaults to the queue's or 0")
+	maxRetries := fs.Int("max-retries", 1000, "failures after which events are dead lettered, as the workers configure it")
+	retryBackoff := fs.Duration("retry-backoff", 5*time.Second, "how long failed events wait before they are retried")
+	duration := fs.Duration("duration", time.Hour, "how long events keep arriving")
+	seed := fs.Int64("seed", 1, "random seed, the same seed replays the same arrivals")
+	targetWait := fs.Duration("target-wait", 0, "report the fewest workers keeping the p95 wait under this")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	counts := []int{}
+	for _, field := range strings.Split(*workers, ",") {
+		count, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid worker count %q", field)
+		}
+		counts = append(counts, count)
+	}
+
+	config := queue.SimulationConfig{FailureRate: max(*failureRate, 0)}
+	if *queueFlags.name != "" || *queueFlags.turso {
+		q, err := queueFlags.open()
+		if err != nil {
+			return err
+		}
+		if config, err = q.SimulationFromHistory(0); err != nil && (*rate == 0 || *processing == 0) {
+			return err
+		}
+		if *failureRate >= 0 {
+			config.FailureRate = *failureRate
+		}
+	}
+	if *rate > 0 {
+		config.Arrivals = queue.ExponentialDurations(time.Duration(float64(time.Second) / *rate))
+	}
+	if *processing > 0 {
+		config.Processing = queue.ExponentialDurations(*processing)
+	}
+	if config.Arrivals == nil || config.Processing == nil {
+		return fmt.Errorf("-rate and -processing are required without -queue or -turso")
+	}
+	config.MaxRetries, config.RetryBackoff = *maxRetries, *retryBackoff
+	config.Duration, config.Seed = *duration, *seed
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "WORKERS\tEVENTS\tDEAD\tBACKLOG\tMAX DEPTH\tMEAN DEPTH\tWAIT P50/P95/P99\tLATENCY P50/P95/P99\tUTILIZATION\tDRAINED\t")
+	recommended := 0
+	for _, count := range counts {
+		config.Workers = count
+		result, err := queue.Simulate(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%.1f\t%s\t%s\t%.0f%%\t%s\t\n",
+			count, result.Events, result.Dead, result.Backlog, result.MaxDepth, result.MeanDepth,
+			formatSeconds(result.Wait), formatSeconds(result.Latency), result.Utilization*100, result.Drained.Round(time.Second))
+		if *targetWait > 0 && recommended == 0 && result.Wait.P95 <= targetWait.Seconds() {
+			recommended = count
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *targetWait > 0 {
+		if recommended == 0 {
+			fmt.Printf("\nnone of the worker counts keep the p95 wait under %s\n", *targetWait)
+		} else {
+			fmt.Printf("\n%d workers keep the p95 wait under %s\n", recommended, *targetWait)
+		}
+	}
+	return nil
+}
+
+func formatSeconds(p queue.Percentiles) string {
+	format := func(seconds float64) string {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+	}
+	return format(p.P50) + "/" + format(p.P95) + "/" + format(p.P99)
+}
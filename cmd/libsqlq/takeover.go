@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+func runTakeover(args []string) error {
+	fs := flag.NewFlagSet("takeover", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	id := fs.Int("id", 0, "id of the in-flight event to take over")
+	to := fs.String("to", "", "worker id that may claim the event next, any worker if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *id == 0 {
+		return fmt.Errorf("-id is required")
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	q.WithWorkerID("libsqlq-cli")
+	if *to == "" {
+		err = q.ForceRelease(*id)
+	} else {
+		err = q.ForceReassign(*id, *to)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("took over event %d\n", *id)
+	return nil
+}
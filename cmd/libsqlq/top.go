@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	interval := fs.Duration("interval", 2*time.Second, "how often to refresh")
+	once := fs.Bool("once", false, "print a single reading without rates and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names, queues, err := openTopQueues(queueFlags)
+	if err != nil {
+		return err
+	}
+	previous := make([]*queue.Pulse, len(queues))
+	for {
+		pulses := make([]queue.Pulse, len(queues))
+		for i, q := range queues {
+			if pulses[i], err = q.Analytics().Pulse(); err != nil {
+				return fmt.Errorf("problem reading queue %s: %w", names[i], err)
+			}
+		}
+		if !*once {
+			fmt.Print(clearScreen)
+			fmt.Printf("libsqlq top, every %s, %s\n\n", *interval, time.Now().Format(time.TimeOnly))
+		}
+		printTop(names, pulses, previous)
+		if *once {
+			return nil
+		}
+		for i := range pulses {
+			previous[i] = &pulses[i]
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// The queues named by -queue, which may list several separated by commas, or the Turso queue
+func openTopQueues(f queueFlags) ([]string, []*queue.Queue[json.RawMessage], error) {
+	if *f.turso {
+		q, err := f.open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"turso"}, []*queue.Queue[json.RawMessage]{q}, nil
+	}
+	if *f.name == "" {
+		return nil, nil, fmt.Errorf("one of -queue or -turso is required")
+	}
+	names := strings.Split(*f.name, ",")
+	queues := []*queue.Queue[json.RawMessage]{}
+	for _, name := range names {
+		// Only reads, so leave maintenance to the workers
+		q, err := queue.NewLocalQueue[json.RawMessage](name, queue.WithSynchronousMaintenance())
+		if err != nil {
+			return nil, nil, err
+		}
+		queues = append(queues, q)
+	}
+	return names, queues, nil
+}
+
+func printTop(names []string, pulses []queue.Pulse, previous []*queue.Pulse) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "QUEUE\tDEPTH\tINFLIGHT\tDEAD\tIN/S\tOUT/S\tREDELIVERED\tOLDEST\tAGE P50/P95/P99\tSIZE P50/P95/P99\t")
+	for i, pulse := range pulses {
+		in, out := "-", "-"
+		if previous[i] != nil {
+			inserted, resolved := pulse.Throughput(*previous[i])
+			in, out = fmt.Sprintf("%.1f", inserted), fmt.Sprintf("%.1f", resolved)
+		}
+		redelivered := "-"
+		if pulse.Inflight > 0 {
+			redelivered = fmt.Sprintf("%.0f%%", 100*float64(pulse.Redelivered)/float64(pulse.Inflight))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s/%s/%s\t%.0f/%.0f/%.0fB\t\n",
+			names[i], pulse.Pending, pulse.Inflight, pulse.Dead, in, out, redelivered,
+			pulse.OldestAge.Round(time.Second),
+			seconds(pulse.Age.P50), seconds(pulse.Age.P95), seconds(pulse.Age.P99),
+			pulse.Size.P50, pulse.Size.P95, pulse.Size.P99)
+	}
+	_ = w.Flush()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
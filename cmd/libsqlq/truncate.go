@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func runTruncate(args []string) error {
+	fs := flag.NewFlagSet("truncate", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	file := fs.String("file", "", "file to write the archived events to before they are deleted")
+	olderThan := fs.Duration("older-than", 0, "only truncate events archived at least this long ago, 0 for the whole archive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	output, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+	var before time.Time
+	if *olderThan > 0 {
+		before = time.Now().Add(-*olderThan)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	truncation, err := q.TruncateArchive(ctx, output, before)
+	if err != nil {
+		return fmt.Errorf("nothing was truncated: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d archived events to %s and truncated them\n", truncation.Events, *file)
+	if truncation.Head != "" {
+		fmt.Fprintf(os.Stderr, "hash chain checkpoint: %s\n", truncation.Head)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"libsqlq/queue"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func runWarehouse(args []string) error {
+	fs := flag.NewFlagSet("warehouse", flag.ExitOnError)
+	queueFlags := addQueueFlags(fs)
+	dir := fs.String("dir", "", "directory to write CSV files of completed and dead events to")
+	name := fs.String("name", "warehouse", "file prefix and bookmark name, to export one queue to several places")
+	interval := fs.Duration("interval", time.Minute, "how often to export new rows")
+	once := fs.Bool("once", false, "export the rows finished since the last run and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	q, err := queueFlags.open()
+	if err != nil {
+		return err
+	}
+	// Exports are made here rather than on the exporter's own schedule, so failures are shown
+	exporter, err := queue.NewWarehouseExporter(q, queue.WarehouseOptions{Name: *name, Dir: *dir, Interval: 24 * time.Hour})
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	for {
+		exported, err := exporter.Export()
+		if err != nil {
+			return fmt.Errorf("stopped after %d events: %w", exported, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s exported %d events\n", time.Now().Format(time.TimeOnly), exported)
+		if *once {
+			return nil
+		}
+		select {
+		case <-interrupted:
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
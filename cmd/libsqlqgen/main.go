@@ -0,0 +1,244 @@
+// Command libsqlqgen generates strongly typed queue wiring for applications with one queue
+// per payload type: queue name and kind constants, a struct opening every queue, typed
+// insert helpers and handler registration. Run it from go:generate in the package that
+// declares the payload types:
+//
+//	//go:generate go run libsqlq/cmd/libsqlqgen -type Email,Invoice -schemas testdata
+//
+// With -schemas a test is generated too, failing when a payload type changes in a way that
+// breaks decoding events already in its queue, see queue.CheckPayloadCompatibility
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+func main() {
+	types := flag.String("type", "", "comma separated payload types to generate queues for")
+	output := flag.String("output", "queues_gen.go", "file to write, relative to the package directory")
+	prefix := flag.String("prefix", "", "prefix of the generated queue names, e.g. the application's name")
+	dir := flag.String("dir", ".", "directory of the package declaring the payload types")
+	schemas := flag.String("schemas", "", "directory of the payload schema snapshots, relative to the package directory; generates a compatibility test when set")
+	flag.Parse()
+
+	if err := run(*dir, strings.Split(*types, ","), *output, *prefix, *schemas); err != nil {
+		fmt.Fprintf(os.Stderr, "libsqlqgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// A payload type and the names generated for it
+type payload struct {
+	// The Go type, e.g. SendEmail
+	Type string
+	// Suffix of the generated constants, e.g. SEND_EMAIL
+	Constant string
+	// Queue name and event kind, e.g. send_email
+	Name string
+}
+
+type file struct {
+	Package  string
+	Command  string
+	Payloads []payload
+	// Directory of the schema snapshots
+	Schemas string
+}
+
+func run(dir string, types []string, output string, prefix string, schemas string) error {
+	pkg, declared, err := parsePackage(dir)
+	if err != nil {
+		return err
+	}
+	f := file{Package: pkg, Command: strings.Join(os.Args[1:], " "), Schemas: filepath.ToSlash(schemas)}
+	for _, name := range types {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !declared[name] {
+			return fmt.Errorf("type %s is not declared in package %s", name, pkg)
+		}
+		snake := snakeCase(name)
+		f.Payloads = append(f.Payloads, payload{Type: name, Constant: strings.ToUpper(snake), Name: prefix + snake})
+	}
+	if len(f.Payloads) == 0 {
+		return fmt.Errorf("-type is required")
+	}
+
+	if err := generate(generated, f, filepath.Join(dir, output)); err != nil {
+		return err
+	}
+	if schemas == "" {
+		return nil
+	}
+	return generate(generatedSchemaTest, f, filepath.Join(dir, strings.TrimSuffix(output, ".go")+"_schema_test.go"))
+}
+
+func generate(tmpl *template.Template, f file, path string) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, f); err != nil {
+		return err
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("problem formatting generated code: %w", err)
+	}
+	return os.WriteFile(path, source, 0o644)
+}
+
+// The package name and the types declared in the non-test Go files of dir
+func parsePackage(dir string) (string, map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	fset := token.NewFileSet()
+	pkg := ""
+	declared := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", nil, err
+		}
+		pkg = parsed.Name.Name
+		for _, decl := range parsed.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				declared[spec.(*ast.TypeSpec).Name.Name] = true
+			}
+		}
+	}
+	if pkg == "" {
+		return "", nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return pkg, declared, nil
+}
+
+// SendEmail → send_email, HTTPRequest → http_request
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previousLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+var generated = template.Must(template.New("queues").Parse(`// Code generated by libsqlqgen {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"errors"
+	"fmt"
+
+	"libsqlq/queue"
+)
+
+// Queue names, one queue per payload type
+const (
+{{- range .Payloads}}
+	QUEUE_{{.Constant}} = "{{.Name}}"
+{{- end}}
+)
+
+// Event kinds, set on every event inserted through Queues
+const (
+{{- range .Payloads}}
+	KIND_{{.Constant}} = "{{.Name}}"
+{{- end}}
+)
+
+// One queue per payload type
+type Queues struct {
+{{- range .Payloads}}
+	{{.Type}} *queue.Queue[{{.Type}}]
+{{- end}}
+}
+
+// Open a local queue for every payload type with opts, sharing those already open in this
+// process, see queue.NewSharedLocalQueue
+func OpenQueues(opts ...queue.Option) (*Queues, error) {
+	queues := &Queues{}
+	var err error
+{{- range .Payloads}}
+	if queues.{{.Type}}, err = queue.NewSharedLocalQueue[{{.Type}}](QUEUE_{{.Constant}}, opts...); err != nil {
+		return nil, fmt.Errorf("problem opening queue %s: %w", QUEUE_{{.Constant}}, err)
+	}
+{{- end}}
+	return queues, nil
+}
+{{range .Payloads}}
+// Insert a {{.Type}} event of kind KIND_{{.Constant}}
+func (q *Queues) Insert{{.Type}}(payload {{.Type}}, opts ...queue.InsertOption) error {
+	return q.{{.Type}}.Insert(payload, append([]queue.InsertOption{queue.WithKind(KIND_{{.Constant}})}, opts...)...)
+}
+{{end}}
+// The handler of every payload type, queues whose handler is nil are left alone
+type Handlers struct {
+{{- range .Payloads}}
+	{{.Type}} queue.Handler[{{.Type}}]
+{{- end}}
+}
+
+// Process every event currently available in each queue with its handler, see
+// queue.Queue.DrainTo. Returns the summaries by queue name, and every queue's error
+func (q *Queues) DrainTo(handlers Handlers) (map[string]queue.DrainSummary, error) {
+	summaries := map[string]queue.DrainSummary{}
+	var errs []error
+{{- range .Payloads}}
+	if handlers.{{.Type}} != nil {
+		summary, err := q.{{.Type}}.DrainTo(handlers.{{.Type}})
+		summaries[QUEUE_{{.Constant}}] = summary
+		if err != nil {
+			errs = append(errs, fmt.Errorf("problem draining queue %s: %w", QUEUE_{{.Constant}}, err))
+		}
+	}
+{{- end}}
+	return summaries, errors.Join(errs...)
+}
+`))
+
+var generatedSchemaTest = template.Must(template.New("schemas").Parse(`// Code generated by libsqlqgen {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"testing"
+
+	"libsqlq/queue"
+)
+
+// Fails when a payload type can no longer decode the events already in its queue
+func TestPayloadCompatibility(t *testing.T) {
+{{- range .Payloads}}
+	queue.CheckPayloadCompatibility[{{.Type}}](t, "{{$.Schemas}}/{{.Name}}.schema.json")
+{{- end}}
+}
+`))
@@ -0,0 +1,315 @@
+// Package asynq offers the parts of github.com/hibiken/asynq's client and server API most
+// applications use, backed by libsqlq queues instead of Redis, so migrating is mostly a
+// matter of changing imports and how queues are opened. Each asynq queue name maps to a
+// libsqlq queue opened with Open, the task type is stored as the event's kind and the
+// payload as raw bytes.
+//
+// Retry limits and backoff are configured on the libsqlq queue rather than per task, and
+// tasks can't be scheduled for later: the options that would need that fail Enqueue with
+// ErrUnsupportedOption instead of being silently ignored.
+package asynq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"libsqlq/queue"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The queue tasks are enqueued to and processed from when none is given
+const DEFAULT_QUEUE_NAME = "default"
+
+// Returned by Enqueue for options libsqlq has no equivalent for
+var ErrUnsupportedOption = errors.New("option is not supported by libsqlq")
+
+// How long a worker waits before looking for tasks again once its queue was empty
+const pollInterval = time.Second
+
+// Stores payloads as they are instead of JSON encoding them, so a []byte payload doesn't
+// end up base64 encoded
+type BytesCodec struct{}
+
+func (BytesCodec) Marshal(v any) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("BytesCodec can't encode %T", v)
+	}
+	return data, nil
+}
+
+func (BytesCodec) Unmarshal(data []byte, v any) error {
+	target, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("BytesCodec can't decode into %T", v)
+	}
+	*target = append([]byte(nil), data...)
+	return nil
+}
+
+// Open the local libsqlq queue backing the asynq queue with name: name. Client and server
+// code in one process share the same queue, see queue.NewSharedLocalQueue, so each must
+// close it once it is done
+func Open(name string, opts ...queue.Option) (*queue.Queue[[]byte], error) {
+	q, err := queue.NewSharedLocalQueue[[]byte](name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return q.WithCodec(BytesCodec{}), nil
+}
+
+// A unit of work, its type selects the handler that processes it
+type Task struct {
+	typename string
+	payload  []byte
+	opts     []Option
+}
+
+// Create a task, opts are applied whenever it is enqueued
+func NewTask(typename string, payload []byte, opts ...Option) *Task {
+	return &Task{typename: typename, payload: payload, opts: opts}
+}
+
+func (t *Task) Type() string {
+	return t.typename
+}
+
+func (t *Task) Payload() []byte {
+	return t.payload
+}
+
+// Configures how a task is enqueued
+type Option func(*enqueueOptions)
+
+type enqueueOptions struct {
+	queue       string
+	insertOpts  []queue.InsertOption
+	unsupported []string
+}
+
+// Enqueue the task to the queue with name: name
+func Queue(name string) Option {
+	return func(o *enqueueOptions) {
+		o.queue = name
+	}
+}
+
+// Dead letter the task if it wasn't processed by deadline
+func Deadline(deadline time.Time) Option {
+	return func(o *enqueueOptions) {
+		o.insertOpts = append(o.insertOpts, queue.WithExpiresAt(deadline))
+	}
+}
+
+// Not supported, retries are configured on the queue with WithMaxRetires
+func MaxRetry(n int) Option {
+	return unsupported("MaxRetry")
+}
+
+// Not supported, tasks are available as soon as they are enqueued
+func ProcessIn(d time.Duration) Option {
+	return unsupported("ProcessIn")
+}
+
+// Not supported, tasks are available as soon as they are enqueued
+func ProcessAt(t time.Time) Option {
+	return unsupported("ProcessAt")
+}
+
+func unsupported(name string) Option {
+	return func(o *enqueueOptions) {
+		o.unsupported = append(o.unsupported, name)
+	}
+}
+
+// Describes an enqueued task
+type TaskInfo struct {
+	// The libsqlq event id
+	ID      string
+	Queue   string
+	Type    string
+	Payload []byte
+}
+
+// Enqueues tasks to libsqlq queues by asynq queue name
+type Client struct {
+	queues map[string]*queue.Queue[[]byte]
+}
+
+// Enqueue to queues by name, see Open. Tasks without a Queue option go to
+// DEFAULT_QUEUE_NAME
+func NewClient(queues map[string]*queue.Queue[[]byte]) *Client {
+	return &Client{queues: queues}
+}
+
+// Enqueue task with the options it was created with followed by opts
+func (c *Client) Enqueue(task *Task, opts ...Option) (*TaskInfo, error) {
+	return c.EnqueueContext(context.Background(), task, opts...)
+}
+
+// Enqueue, failing without enqueueing if ctx is done
+func (c *Client) EnqueueContext(ctx context.Context, task *Task, opts ...Option) (*TaskInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := enqueueOptions{queue: DEFAULT_QUEUE_NAME}
+	for _, opt := range append(append([]Option{}, task.opts...), opts...) {
+		opt(&o)
+	}
+	if len(o.unsupported) > 0 {
+		return nil, fmt.Errorf("%s: %w", strings.Join(o.unsupported, ", "), ErrUnsupportedOption)
+	}
+	q, ok := c.queues[o.queue]
+	if !ok {
+		return nil, fmt.Errorf("no queue named %s", o.queue)
+	}
+	insertOpts := append([]queue.InsertOption{queue.WithKind(task.typename)}, o.insertOpts...)
+	reservation, err := q.InsertReturningIDs([][]byte{task.payload}, insertOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskInfo{ID: strconv.Itoa(reservation.First), Queue: o.queue, Type: task.typename, Payload: task.payload}, nil
+}
+
+// Nothing to close, the queues are owned by the caller
+func (c *Client) Close() error {
+	return nil
+}
+
+// Processes tasks, returning an error nacks the task so it is retried after the queue's
+// backoff
+type Handler interface {
+	ProcessTask(ctx context.Context, task *Task) error
+}
+
+type HandlerFunc func(ctx context.Context, task *Task) error
+
+func (fn HandlerFunc) ProcessTask(ctx context.Context, task *Task) error {
+	return fn(ctx, task)
+}
+
+// Routes tasks to the handler registered for the longest prefix of their type
+type ServeMux struct {
+	lock     sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{handlers: map[string]Handler{}}
+}
+
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	mux.handlers[pattern] = handler
+}
+
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ctx context.Context, task *Task) error) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+func (mux *ServeMux) ProcessTask(ctx context.Context, task *Task) error {
+	mux.lock.RLock()
+	var match Handler
+	longest := -1
+	for pattern, handler := range mux.handlers {
+		if strings.HasPrefix(task.typename, pattern) && len(pattern) > longest {
+			match, longest = handler, len(pattern)
+		}
+	}
+	mux.lock.RUnlock()
+	if match == nil {
+		return fmt.Errorf("handler not found for task %q", task.typename)
+	}
+	return match.ProcessTask(ctx, task)
+}
+
+// How a Server processes tasks
+type Config struct {
+	// Workers per queue, 1 if unset
+	Concurrency int
+}
+
+// Processes tasks from libsqlq queues with a Handler
+type Server struct {
+	queues map[string]*queue.Queue[[]byte]
+	config Config
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// Process tasks from every queue in queues, see Open
+func NewServer(queues map[string]*queue.Queue[[]byte], config Config) *Server {
+	config.Concurrency = max(config.Concurrency, 1)
+	return &Server{queues: queues, config: config}
+}
+
+// Start processing tasks with handler in the background until Shutdown
+func (s *Server) Start(handler Handler) error {
+	if s.cancel != nil {
+		return errors.New("server already started")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for name, q := range s.queues {
+		for range s.config.Concurrency {
+			s.done.Add(1)
+			go func() {
+				defer s.done.Done()
+				work(ctx, name, q, handler)
+			}()
+		}
+	}
+	return nil
+}
+
+// Stop claiming tasks and wait for the tasks in hand to finish. Tasks whose handler fails
+// because of the shutdown are released for another worker
+func (s *Server) Shutdown() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.done.Wait()
+	s.cancel = nil
+}
+
+// Start, then Shutdown once the process receives SIGINT or SIGTERM
+func (s *Server) Run(handler Handler) error {
+	if err := s.Start(handler); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+	s.Shutdown()
+	return nil
+}
+
+// Process tasks from q until ctx is cancelled
+func work(ctx context.Context, name string, q *queue.Queue[[]byte], handler Handler) {
+	process := func(ctx context.Context, event *queue.Event[[]byte]) error {
+		return handler.ProcessTask(ctx, &Task{typename: event.Envelope.Kind, payload: *event.Content})
+	}
+	for ctx.Err() == nil {
+		summary, err := q.ProcessFor(ctx, time.Minute, process)
+		if err != nil && ctx.Err() == nil {
+			slog.Error(fmt.Sprintf("problem processing tasks from queue %s: %v", name, err))
+		}
+		if err != nil || summary.Processed+summary.Failed+summary.Released == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package asynq
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"libsqlq/queue"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestQueue(t *testing.T) *queue.Queue[[]byte] {
+	t.Helper()
+	q, err := Open(rand.Text()[:10])
+	if err != nil {
+		t.Fatalf("unable to create queue: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(strings.TrimPrefix(q.DSN(), "file:"))
+		_ = os.Remove(".db")
+	})
+	return q
+}
+
+func TestOpenSharesTheQueue(t *testing.T) {
+	client := openTestQueue(t)
+	name := strings.TrimSuffix(strings.TrimPrefix(client.DSN(), "file:.db/"), ".db")
+	server, err := Open(name)
+	if err != nil {
+		t.Fatalf("expected the queue to be opened again, got %v", err)
+	}
+	if server != client {
+		t.Fatal("expected client and server to share the queue")
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Insert([]byte(`{}`)); err != nil {
+		t.Fatalf("expected the queue to stay open for the client, got %v", err)
+	}
+}
+
+func TestEnqueueStoresTypeAndPayload(t *testing.T) {
+	q := openTestQueue(t)
+	client := NewClient(map[string]*queue.Queue[[]byte]{DEFAULT_QUEUE_NAME: q})
+
+	info, err := client.Enqueue(NewTask("email:welcome", []byte(`{"user":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Queue != DEFAULT_QUEUE_NAME || info.Type != "email:welcome" || info.ID == "" {
+		t.Fatalf("unexpected task info %+v", info)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected the task to be enqueued, got %v", err)
+	}
+	if event.Envelope.Kind != "email:welcome" || string(*event.Content) != `{"user":1}` {
+		t.Fatalf("expected the task's type and raw payload, got %q %q", event.Envelope.Kind, *event.Content)
+	}
+}
+
+func TestEnqueueRoutesByQueueOption(t *testing.T) {
+	critical := openTestQueue(t)
+	client := NewClient(map[string]*queue.Queue[[]byte]{"critical": critical})
+
+	if _, err := client.Enqueue(NewTask("report", nil)); err == nil {
+		t.Fatal("expected an error enqueueing to a queue that wasn't given")
+	}
+	if _, err := client.Enqueue(NewTask("report", nil), Queue("critical")); err != nil {
+		t.Fatal(err)
+	}
+	size, err := critical.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1 {
+		t.Fatalf("expected 1 task in the critical queue, got %d", size)
+	}
+}
+
+func TestEnqueueRejectsUnsupportedOptions(t *testing.T) {
+	q := openTestQueue(t)
+	client := NewClient(map[string]*queue.Queue[[]byte]{DEFAULT_QUEUE_NAME: q})
+
+	_, err := client.Enqueue(NewTask("report", nil, MaxRetry(3)), ProcessIn(time.Minute))
+	if !errors.Is(err, ErrUnsupportedOption) {
+		t.Fatalf("expected ErrUnsupportedOption, got %v", err)
+	}
+	size, err := q.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Fatalf("expected nothing to be enqueued, got %d", size)
+	}
+}
+
+func TestServeMuxMatchesLongestPrefix(t *testing.T) {
+	var matched string
+	mux := NewServeMux()
+	mux.HandleFunc("email:", func(ctx context.Context, task *Task) error {
+		matched = "email:"
+		return nil
+	})
+	mux.HandleFunc("email:welcome", func(ctx context.Context, task *Task) error {
+		matched = "email:welcome"
+		return nil
+	})
+
+	if err := mux.ProcessTask(context.Background(), NewTask("email:welcome", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if matched != "email:welcome" {
+		t.Fatalf("expected the longest pattern to match, got %s", matched)
+	}
+	if err := mux.ProcessTask(context.Background(), NewTask("image:resize", nil)); err == nil {
+		t.Fatal("expected an error for a task without a handler")
+	}
+}
+
+func TestServerProcessesTasks(t *testing.T) {
+	q := openTestQueue(t)
+	client := NewClient(map[string]*queue.Queue[[]byte]{DEFAULT_QUEUE_NAME: q})
+	for _, typename := range []string{"a", "b", "c"} {
+		if _, err := client.Enqueue(NewTask(typename, []byte(typename))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lock sync.Mutex
+	processed := map[string]string{}
+	done := make(chan struct{})
+	server := NewServer(map[string]*queue.Queue[[]byte]{DEFAULT_QUEUE_NAME: q}, Config{Concurrency: 2})
+	err := server.Start(HandlerFunc(func(ctx context.Context, task *Task) error {
+		lock.Lock()
+		defer lock.Unlock()
+		processed[task.Type()] = string(task.Payload())
+		if len(processed) == 3 {
+			close(done)
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the tasks to be processed")
+	}
+	server.Shutdown()
+
+	for _, typename := range []string{"a", "b", "c"} {
+		if processed[typename] != typename {
+			t.Fatalf("expected task %s to be processed with its payload, got %v", typename, processed)
+		}
+	}
+	size, err := q.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Fatalf("expected every task to be acked, %d left", size)
+	}
+}
@@ -0,0 +1,284 @@
+// Package river offers the parts of github.com/riverqueue/river's client and worker API
+// most applications use, backed by libsqlq queues instead of Postgres. Each river queue
+// name maps to a libsqlq queue of JSON documents, the job's kind is stored as the event's
+// kind and its args as the payload.
+//
+// Attempts and backoff are configured on the libsqlq queue rather than per job, and jobs
+// can't be scheduled for later or prioritised: the insert options that would need that
+// fail Insert with ErrUnsupportedOption instead of being silently ignored.
+package river
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"libsqlq/queue"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The queue jobs are inserted into when InsertOpts doesn't name one
+const DEFAULT_QUEUE_NAME = "default"
+
+// Returned by Insert for options libsqlq has no equivalent for
+var ErrUnsupportedOption = errors.New("option is not supported by libsqlq")
+
+// How long a worker waits before looking for jobs again once its queue was empty
+const pollInterval = time.Second
+
+// The arguments of a job, their kind selects the worker that works it
+type JobArgs interface {
+	Kind() string
+}
+
+// What is stored about a job besides its arguments
+type JobRow struct {
+	// The libsqlq event id
+	ID int64
+	// 1 on the first attempt, incremented every time the job failed
+	Attempt   int
+	Kind      string
+	Queue     string
+	Tags      []string
+	CreatedAt time.Time
+	// The arguments as JSON
+	EncodedArgs []byte
+}
+
+// A job being worked, with its decoded arguments
+type Job[T JobArgs] struct {
+	*JobRow
+	Args T
+}
+
+// Works jobs of one kind, returning an error nacks the job so it is retried after the
+// queue's backoff
+type Worker[T JobArgs] interface {
+	Work(ctx context.Context, job *Job[T]) error
+}
+
+// Embedded by workers for source compatibility with river, provides nothing
+type WorkerDefaults[T JobArgs] struct{}
+
+// Workers by the kind of job they work, see AddWorker
+type Workers struct {
+	workers map[string]func(ctx context.Context, row *JobRow) error
+}
+
+func NewWorkers() *Workers {
+	return &Workers{workers: map[string]func(ctx context.Context, row *JobRow) error{}}
+}
+
+// Register worker for the kind of T, panicking if one is registered already
+func AddWorker[T JobArgs](workers *Workers, worker Worker[T]) {
+	if err := AddWorkerSafely(workers, worker); err != nil {
+		panic(err)
+	}
+}
+
+// Register worker for the kind of T
+func AddWorkerSafely[T JobArgs](workers *Workers, worker Worker[T]) error {
+	var args T
+	kind := args.Kind()
+	if _, ok := workers.workers[kind]; ok {
+		return fmt.Errorf("worker for kind %q is already registered", kind)
+	}
+	workers.workers[kind] = func(ctx context.Context, row *JobRow) error {
+		job := &Job[T]{JobRow: row}
+		if err := json.Unmarshal(row.EncodedArgs, &job.Args); err != nil {
+			return fmt.Errorf("problem decoding args of job %d: %w", row.ID, err)
+		}
+		return worker.Work(ctx, job)
+	}
+	return nil
+}
+
+func (w *Workers) work(ctx context.Context, row *JobRow) error {
+	work, ok := w.workers[row.Kind]
+	if !ok {
+		return fmt.Errorf("no worker registered for kind %q", row.Kind)
+	}
+	return work(ctx, row)
+}
+
+type QueueConfig struct {
+	// Jobs worked concurrently from the queue, 1 if unset
+	MaxWorkers int
+}
+
+type Config struct {
+	// Queues to work, by name. Leave empty for a client that only inserts
+	Queues map[string]QueueConfig
+	// Required if Queues isn't empty
+	Workers *Workers
+}
+
+// How a job is inserted
+type InsertOpts struct {
+	// DEFAULT_QUEUE_NAME if empty
+	Queue string
+	Tags  []string
+	// Not supported, attempts are configured on the queue with WithMaxRetires
+	MaxAttempts int
+	// Not supported beyond the default of 1
+	Priority int
+	// Not supported, jobs are available as soon as they are inserted
+	ScheduledAt time.Time
+}
+
+type JobInsertResult struct {
+	Job *JobRow
+}
+
+// Inserts jobs into libsqlq queues by river queue name and works them
+type Client struct {
+	queues map[string]*queue.Queue[json.RawMessage]
+	config *Config
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// Insert into and work queues by name
+func NewClient(queues map[string]*queue.Queue[json.RawMessage], config *Config) (*Client, error) {
+	for name := range config.Queues {
+		if _, ok := queues[name]; !ok {
+			return nil, fmt.Errorf("no queue named %s", name)
+		}
+	}
+	if len(config.Queues) > 0 && config.Workers == nil {
+		return nil, errors.New("workers are required to work queues")
+	}
+	return &Client{queues: queues, config: config}, nil
+}
+
+// Insert a job with args, opts may be nil
+func (c *Client) Insert(ctx context.Context, args JobArgs, opts *InsertOpts) (*JobInsertResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &InsertOpts{}
+	}
+	if err := checkInsertOpts(opts); err != nil {
+		return nil, err
+	}
+	name := opts.Queue
+	if name == "" {
+		name = DEFAULT_QUEUE_NAME
+	}
+	q, ok := c.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("no queue named %s", name)
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding job args: %w", err)
+	}
+	// Reserved first so the job's id can be returned
+	reservation, err := q.ReserveIDs(1)
+	if err != nil {
+		return nil, err
+	}
+	err = q.InsertReserved(reservation, []json.RawMessage{encoded}, queue.WithKind(args.Kind()), queue.WithTags(opts.Tags...))
+	if err != nil {
+		return nil, err
+	}
+	row := &JobRow{
+		ID:          int64(reservation.First),
+		Attempt:     1,
+		Kind:        args.Kind(),
+		Queue:       name,
+		Tags:        opts.Tags,
+		CreatedAt:   time.Now(),
+		EncodedArgs: encoded,
+	}
+	return &JobInsertResult{Job: row}, nil
+}
+
+func checkInsertOpts(opts *InsertOpts) error {
+	switch {
+	case opts.MaxAttempts != 0:
+		return fmt.Errorf("MaxAttempts: %w", ErrUnsupportedOption)
+	case opts.Priority > 1:
+		return fmt.Errorf("Priority: %w", ErrUnsupportedOption)
+	case !opts.ScheduledAt.IsZero():
+		return fmt.Errorf("ScheduledAt: %w", ErrUnsupportedOption)
+	}
+	return nil
+}
+
+// Start working the configured queues in the background until Stop
+func (c *Client) Start(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cancel != nil {
+		return errors.New("client already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	for name, config := range c.config.Queues {
+		for range max(config.MaxWorkers, 1) {
+			c.done.Add(1)
+			go func() {
+				defer c.done.Done()
+				c.work(ctx, name, c.queues[name])
+			}()
+		}
+	}
+	return nil
+}
+
+// Stop claiming jobs and wait for the jobs in hand to finish, or for ctx to be done. Jobs
+// whose worker fails because of the stop are released for another client
+func (c *Client) Stop(ctx context.Context) error {
+	c.lock.Lock()
+	cancel := c.cancel
+	c.cancel = nil
+	c.lock.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		c.done.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Work jobs from q until ctx is cancelled
+func (c *Client) work(ctx context.Context, name string, q *queue.Queue[json.RawMessage]) {
+	process := func(ctx context.Context, event *queue.Event[json.RawMessage]) error {
+		return c.config.Workers.work(ctx, &JobRow{
+			ID:          int64(event.Id),
+			Attempt:     event.Envelope.Retries + 1,
+			Kind:        event.Envelope.Kind,
+			Queue:       name,
+			Tags:        event.Envelope.Tags,
+			CreatedAt:   event.Envelope.EnqueuedAt,
+			EncodedArgs: *event.Content,
+		})
+	}
+	for ctx.Err() == nil {
+		summary, err := q.ProcessFor(ctx, time.Minute, process)
+		if err != nil && ctx.Err() == nil {
+			slog.Error(fmt.Sprintf("problem working jobs from queue %s: %v", name, err))
+		}
+		if err != nil || summary.Processed+summary.Failed+summary.Released == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package river
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"libsqlq/queue"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type SortArgs struct {
+	Strings []string `json:"strings"`
+}
+
+func (SortArgs) Kind() string { return "sort" }
+
+type SortWorker struct {
+	WorkerDefaults[SortArgs]
+	jobs chan *Job[SortArgs]
+}
+
+func (w *SortWorker) Work(ctx context.Context, job *Job[SortArgs]) error {
+	w.jobs <- job
+	return nil
+}
+
+func openTestQueue(t *testing.T) *queue.Queue[json.RawMessage] {
+	t.Helper()
+	q, err := queue.NewLocalQueue[json.RawMessage](rand.Text()[:10])
+	if err != nil {
+		t.Fatalf("unable to create queue: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(strings.TrimPrefix(q.DSN(), "file:"))
+		_ = os.Remove(".db")
+	})
+	return q
+}
+
+func TestInsertStoresKindArgsAndTags(t *testing.T) {
+	q := openTestQueue(t)
+	client, err := NewClient(map[string]*queue.Queue[json.RawMessage]{DEFAULT_QUEUE_NAME: q}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.Insert(context.Background(), SortArgs{Strings: []string{"b", "a"}}, &InsertOpts{Tags: []string{"nightly"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Job.Kind != "sort" || result.Job.Queue != DEFAULT_QUEUE_NAME || result.Job.Attempt != 1 {
+		t.Fatalf("unexpected job %+v", result.Job)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected the job to be inserted, got %v", err)
+	}
+	if event.Id != int(result.Job.ID) || event.Envelope.Kind != "sort" {
+		t.Fatalf("expected event %d of kind sort, got %d of kind %q", result.Job.ID, event.Id, event.Envelope.Kind)
+	}
+	if len(event.Envelope.Tags) != 1 || event.Envelope.Tags[0] != "nightly" {
+		t.Fatalf("expected the job's tags, got %v", event.Envelope.Tags)
+	}
+	if string(*event.Content) != `{"strings":["b","a"]}` {
+		t.Fatalf("expected the args as JSON, got %s", *event.Content)
+	}
+}
+
+func TestInsertRejectsUnsupportedOptions(t *testing.T) {
+	q := openTestQueue(t)
+	client, err := NewClient(map[string]*queue.Queue[json.RawMessage]{DEFAULT_QUEUE_NAME: q}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []*InsertOpts{{MaxAttempts: 5}, {Priority: 2}, {ScheduledAt: time.Now().Add(time.Hour)}} {
+		if _, err := client.Insert(context.Background(), SortArgs{}, opts); !errors.Is(err, ErrUnsupportedOption) {
+			t.Fatalf("expected ErrUnsupportedOption for %+v, got %v", opts, err)
+		}
+	}
+	if _, err := client.Insert(context.Background(), SortArgs{}, &InsertOpts{Queue: "missing"}); err == nil {
+		t.Fatal("expected an error inserting into a queue that wasn't given")
+	}
+}
+
+func TestAddWorkerSafelyRejectsDuplicates(t *testing.T) {
+	workers := NewWorkers()
+	if err := AddWorkerSafely(workers, &SortWorker{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddWorkerSafely(workers, &SortWorker{}); err == nil {
+		t.Fatal("expected an error registering a second worker for the same kind")
+	}
+}
+
+func TestClientWorksJobs(t *testing.T) {
+	q := openTestQueue(t)
+	worker := &SortWorker{jobs: make(chan *Job[SortArgs], 1)}
+	workers := NewWorkers()
+	AddWorker(workers, worker)
+	client, err := NewClient(map[string]*queue.Queue[json.RawMessage]{DEFAULT_QUEUE_NAME: q}, &Config{
+		Queues:  map[string]QueueConfig{DEFAULT_QUEUE_NAME: {MaxWorkers: 2}},
+		Workers: workers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Stop(context.Background())
+	}()
+
+	if _, err := client.Insert(context.Background(), SortArgs{Strings: []string{"b", "a"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case job := <-worker.jobs:
+		if job.Attempt != 1 || len(job.Args.Strings) != 2 || job.Args.Strings[0] != "b" {
+			t.Fatalf("unexpected job %+v with args %+v", job.JobRow, job.Args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to be worked")
+	}
+}
@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+const PEEK_QUERY_TEMPLATE = `SELECT ` + ENVELOPE_COLUMNS + `, %s FROM %s WHERE id = ?`
+
+// Look up the event with id: id in any state without claiming it. Returns nil if there is
+// no such event, e.g. because it was acked and its grace period has passed. Served from the
+// read snapshot while the database is unreachable, see WithReadSnapshot
+func (q *Queue[T]) Peek(id int) (*Envelope, error) {
+	q.lock.RLock()
+	envelope, err := peekEvent(q.db, id)
+	q.lock.RUnlock()
+	if err == nil {
+		if envelope != nil {
+			envelope.QueueLabels = q.labels
+		}
+		return envelope, nil
+	}
+	envelope, snapshotAt, snapshotErr := fromSnapshot(q, func(db *sql.DB) (*Envelope, error) {
+		return peekEvent(db, id)
+	})
+	if snapshotErr != nil {
+		return nil, err
+	}
+	if envelope != nil {
+		envelope.StaleAt = snapshotAt
+		envelope.QueueLabels = q.labels
+	}
+	return envelope, nil
+}
+
+func peekEvent(db *sql.DB, id int) (*Envelope, error) {
+	for _, table := range slices.Concat(EVENT_TABLES, []string{COMPLETED_TABLE}) {
+		reasonColumn := "NULL"
+		if table == DEAD_TABLE {
+			reasonColumn = "reason"
+		}
+		var reason sql.NullString
+		row := db.QueryRow(fmt.Sprintf(PEEK_QUERY_TEMPLATE, reasonColumn, table), id)
+		envelope, err := scanEnvelope(row, TABLE_STATES[table], &reason)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("problem looking up event %d: %w", id, err)
+		}
+		envelope.DeadReason = reason.String
+		return &envelope, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An index the queue's hot queries would benefit from, see AdviseIndexes
+type IndexAdvice struct {
+	Name  string
+	Table string
+	// Which query scans the table without it
+	Reason string
+	// The query plan that triggered the advice, as reported by EXPLAIN QUERY PLAN
+	Plan string
+	// Creates the index, see CreateIndexes
+	Statement string
+}
+
+// A query the queue runs often and the index that keeps it from scanning its table
+type indexCandidate struct {
+	name      string
+	table     string
+	condition string
+	args      []any
+	reason    string
+	statement string
+}
+
+// The hot queries for the queue's current configuration and the features it was seen using
+func (q *Queue[T]) indexCandidates() []indexCandidate {
+	candidates := []indexCandidate{
+		{
+			name:      "idx_inflight_claim_expires",
+			table:     INFLIGHT_TABLE,
+			condition: fmt.Sprintf(CLAIM_TIMEOUT_CLEANUP_CONDITION_TEMPLATE, q.clock.now),
+			reason:    "maintenance looks up expired claims on every run",
+			statement: `CREATE INDEX IF NOT EXISTS idx_inflight_claim_expires ON queue_inflight (claim_expires) WHERE claim_expires IS NOT NULL`,
+		},
+		{
+			name:      "idx_queue_expires_at",
+			table:     PENDING_TABLE,
+			condition: EXPIRED_CONDITION,
+			reason:    "maintenance looks up events past their deadline on every run, see WithExpiresAt",
+			statement: `CREATE INDEX IF NOT EXISTS idx_queue_expires_at ON queue (expires_at) WHERE expires_at IS NOT NULL`,
+		},
+	}
+	if q.ackGracePeriod > 0 {
+		candidates = append(candidates, indexCandidate{
+			name:      "idx_completed_purge_at",
+			table:     COMPLETED_TABLE,
+			condition: PURGE_CONDITION,
+			reason:    "maintenance purges completed events past their grace period, see WithAckGracePeriod",
+			statement: `CREATE INDEX IF NOT EXISTS idx_completed_purge_at ON queue_completed (purge_at) WHERE purge_at IS NOT NULL`,
+		})
+	}
+	if q.kindFiltered.Load() {
+		candidates = append(candidates, indexCandidate{
+			name:      "idx_queue_kind",
+			table:     PENDING_TABLE,
+			condition: "kind = ? ORDER BY id",
+			args:      []any{""},
+			reason:    "sub-queues filtering by kind claim with it, see LightweightSubQueue",
+			statement: `CREATE INDEX IF NOT EXISTS idx_queue_kind ON queue (kind, id) WHERE kind IS NOT NULL`,
+		})
+	}
+	return candidates
+}
+
+// Run EXPLAIN QUERY PLAN on the queue's hot queries for its current configuration and the
+// features it was seen using, and report the indexes that would stop them from scanning
+// whole tables. Create them with CreateIndexes
+func (q *Queue[T]) AdviseIndexes() ([]IndexAdvice, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	advice := []IndexAdvice{}
+	for _, candidate := range q.indexCandidates() {
+		plan, err := q.queryPlan(fmt.Sprintf("SELECT id FROM %s WHERE %s", candidate.table, candidate.condition), candidate.args...)
+		if err != nil {
+			return nil, fmt.Errorf("problem explaining query for %s: %w", candidate.name, err)
+		}
+		if !scansTable(plan, candidate.table) {
+			continue
+		}
+		advice = append(advice, IndexAdvice{
+			Name:      candidate.name,
+			Table:     candidate.table,
+			Reason:    candidate.reason,
+			Plan:      strings.Join(plan, "\n"),
+			Statement: candidate.statement,
+		})
+	}
+	return advice, nil
+}
+
+// Create the indexes AdviseIndexes recommended
+func (q *Queue[T]) CreateIndexes(advice []IndexAdvice) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, index := range advice {
+		if _, err := q.db.Exec(index.Statement); err != nil {
+			return fmt.Errorf("problem creating index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// The detail column of every step of query's plan
+func (q *Queue[T]) queryPlan(query string, args ...any) ([]string, error) {
+	rows, err := q.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	plan := []string{}
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// Whether a step of plan reads every row of table
+func scansTable(plan []string, table string) bool {
+	for _, step := range plan {
+		if (step == "SCAN "+table || strings.HasPrefix(step, "SCAN "+table+" ")) && !strings.Contains(step, "INDEX") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdviseIndexes(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithAckGracePeriod(time.Minute)
+	q.LightweightSubQueue(SubQueueFilter{Kind: "email"})
+
+	advice, err := q.AdviseIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, index := range advice {
+		names[index.Name] = true
+		if index.Plan == "" || index.Statement == "" {
+			t.Fatalf("expected a plan and a statement, got %+v", index)
+		}
+	}
+	for _, name := range []string{"idx_inflight_claim_expires", "idx_queue_expires_at", "idx_completed_purge_at", "idx_queue_kind"} {
+		if !names[name] {
+			t.Fatalf("expected %s to be advised, got %+v", name, advice)
+		}
+	}
+
+	if err := q.CreateIndexes(advice); err != nil {
+		t.Fatal(err)
+	}
+	advice, err = q.AdviseIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advice) != 0 {
+		t.Fatalf("expected no advice once the indexes exist, got %+v", advice)
+	}
+}
@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Read-only reports over the history of a queue, see Queue.Analytics. Completed events
+// only count for queues configured WithArchive, without it only events that are still
+// pending, in flight or dead are known.
+type Analytics struct {
+	db *sql.DB
+}
+
+// Reports over this queue's history
+func (q *Queue[T]) Analytics() Analytics {
+	return Analytics{q.db}
+}
+
+// Every event the queue knows about, in any state or acked, with columns as the given
+// expression for each table
+func allEvents(columns func(table string) string) string {
+	selects := []string{}
+	for _, table := range slices.Concat(EVENT_TABLES, ACKED_TABLES) {
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s", columns(table), table))
+	}
+	return "(" + strings.Join(selects, " UNION ALL ") + ")"
+}
+
+// How many events were enqueued during an hour of the day
+type HourCount struct {
+	// Hour of the day in UTC, 0-23
+	Hour     int
+	Enqueued int
+}
+
+// The hours of the day at which the most events were enqueued, busiest first
+func (a Analytics) BusiestHours(limit int) ([]HourCount, error) {
+	query := fmt.Sprintf(`SELECT CAST(strftime('%%H', enqueued_at) AS INTEGER) AS hour, COUNT(*) AS enqueued
+FROM %s WHERE enqueued_at IS NOT NULL
+GROUP BY hour ORDER BY enqueued DESC, hour ASC LIMIT ?`, allEvents(func(string) string { return "enqueued_at" }))
+	rows, err := a.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("problem finding busiest hours: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	hours := []HourCount{}
+	for rows.Next() {
+		var hour HourCount
+		if err := rows.Scan(&hour.Hour, &hour.Enqueued); err != nil {
+			return nil, fmt.Errorf("problem finding busiest hours: %w", err)
+		}
+		hours = append(hours, hour)
+	}
+	return hours, rows.Err()
+}
+
+// How often events of a kind failed
+type KindFailures struct {
+	// Empty for events inserted without WithKind
+	Kind string
+	// Total number of nacks across events of this kind
+	Failures int
+	// Events of this kind in the dead letter table
+	DeadLettered int
+}
+
+// The kinds of events that failed most often, most failures first. Kinds that never
+// failed are left out
+func (a Analytics) TopFailingKinds(limit int) ([]KindFailures, error) {
+	events := allEvents(func(table string) string {
+		dead := 0
+		if table == DEAD_TABLE {
+			dead = 1
+		}
+		return fmt.Sprintf("IFNULL(kind, '') AS kind, IFNULL(retries, 0) AS retries, %d AS dead", dead)
+	})
+	query := fmt.Sprintf(`SELECT kind, SUM(retries) AS failures, SUM(dead)
+FROM %s GROUP BY kind HAVING failures > 0
+ORDER BY failures DESC, kind ASC LIMIT ?`, events)
+	rows, err := a.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("problem finding failing kinds: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	kinds := []KindFailures{}
+	for rows.Next() {
+		var kind KindFailures
+		if err := rows.Scan(&kind.Kind, &kind.Failures, &kind.DeadLettered); err != nil {
+			return nil, fmt.Errorf("problem finding failing kinds: %w", err)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, rows.Err()
+}
+
+const AVERAGE_RETRIES_BEFORE_SUCCESS_QUERY = `SELECT IFNULL(AVG(IFNULL(retries, 0)), 0) FROM (
+    SELECT retries FROM queue_archive UNION ALL SELECT retries FROM queue_completed
+)`
+
+// The average number of times acked events that are still known failed before they were acked
+func (a Analytics) AverageRetriesBeforeSuccess() (float64, error) {
+	var average float64
+	if err := a.db.QueryRow(AVERAGE_RETRIES_BEFORE_SUCCESS_QUERY).Scan(&average); err != nil {
+		return 0, fmt.Errorf("problem averaging retries: %w", err)
+	}
+	return average, nil
+}
+
+// The backlog at the end of an hour
+type BacklogPoint struct {
+	// Start of the hour in UTC
+	Hour time.Time `json:"hour"`
+	// Events enqueued during the hour
+	Enqueued int `json:"enqueued"`
+	// Events acked or dead lettered during the hour
+	Resolved int `json:"resolved"`
+	// Events enqueued but not yet resolved at the end of the hour
+	Backlog int `json:"backlog"`
+}
+
+// How the backlog developed hour by hour since since, oldest first. Hours in which nothing
+// happened are left out
+func (a Analytics) BacklogBurnDown(since time.Time) ([]BacklogPoint, error) {
+	since = since.UTC().Truncate(time.Hour)
+	events := allEvents(func(table string) string {
+		resolvedAt := "NULL"
+		switch table {
+		case DEAD_TABLE:
+			resolvedAt = "dead_at"
+		case ARCHIVE_TABLE:
+			resolvedAt = "acked_at"
+		case COMPLETED_TABLE:
+			resolvedAt = "completed_at"
+		}
+		return fmt.Sprintf("CAST(strftime('%%s', enqueued_at) AS INTEGER) AS enqueued, CAST(strftime('%%s', %s) AS INTEGER) AS resolved", resolvedAt)
+	})
+	// One row per hour with the number of events enqueued and resolved in it, hours
+	// before since are folded into a single starting row
+	query := fmt.Sprintf(`SELECT MAX(hour, ?) AS bucket, SUM(enqueued), SUM(resolved) FROM (
+    SELECT enqueued / 3600 * 3600 AS hour, 1 AS enqueued, 0 AS resolved FROM %[1]s WHERE enqueued IS NOT NULL
+    UNION ALL
+    SELECT resolved / 3600 * 3600, 0, 1 FROM %[1]s WHERE resolved IS NOT NULL
+) GROUP BY bucket ORDER BY bucket ASC`, events)
+	start := since.Unix() - 1
+	rows, err := a.db.Query(query, start)
+	if err != nil {
+		return nil, fmt.Errorf("problem computing backlog burn-down: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	points := []BacklogPoint{}
+	backlog := 0
+	for rows.Next() {
+		var bucket int64
+		var point BacklogPoint
+		if err := rows.Scan(&bucket, &point.Enqueued, &point.Resolved); err != nil {
+			return nil, fmt.Errorf("problem computing backlog burn-down: %w", err)
+		}
+		backlog += point.Enqueued - point.Resolved
+		if bucket == start {
+			continue
+		}
+		point.Hour = time.Unix(bucket, 0).UTC()
+		point.Backlog = backlog
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalytics(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithArchive().WithMaxRetires(1)
+
+	for _, kind := range []string{"a", "b", "b"} {
+		if err := q.Insert(Test{A: kind}, WithKind(kind)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a succeeds straight away, the first b after one failure and the second b dies
+	ids := []int{}
+	for range 3 {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		ids = append(ids, event.Id)
+	}
+	steps := []struct {
+		id  int
+		ack bool
+	}{{ids[0], true}, {ids[1], false}, {ids[1], true}, {ids[2], false}, {ids[2], false}}
+	for _, step := range steps {
+		var err error
+		if step.ack {
+			err = q.Ack(step.id)
+		} else {
+			err = q.Nack(step.id)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	analytics := q.Analytics()
+	hours, err := analytics.BusiestHours(24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 1 || hours[0].Hour != time.Now().UTC().Hour() || hours[0].Enqueued != 3 {
+		t.Fatalf("unexpected busiest hours %+v", hours)
+	}
+
+	kinds, err := analytics.TopFailingKinds(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kinds) != 1 || kinds[0] != (KindFailures{Kind: "b", Failures: 3, DeadLettered: 1}) {
+		t.Fatalf("unexpected failing kinds %+v", kinds)
+	}
+
+	average, err := analytics.AverageRetriesBeforeSuccess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if average != 0.5 {
+		t.Fatalf("expected 0.5 retries on average, got %f", average)
+	}
+
+	points, err := analytics.BacklogBurnDown(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Enqueued != 3 || points[0].Resolved != 3 || points[0].Backlog != 0 {
+		t.Fatalf("unexpected burn-down %+v", points)
+	}
+	if points[0].Hour != time.Now().UTC().Truncate(time.Hour) {
+		t.Fatalf("unexpected hour %v", points[0].Hour)
+	}
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// How an attempt at processing an event ended
+const (
+	// Nacked by the handler
+	ATTEMPT_FAILED = "failed"
+	// Given back without counting as a failure, e.g. with Release or ForceRelease
+	ATTEMPT_RELEASED = "released"
+	// The claim expired before the event was acked or nacked
+	ATTEMPT_TIMED_OUT = "timed_out"
+)
+
+// Appends a new attempt to the attempts column as an event is claimed
+const START_ATTEMPT_EXPRESSION = `json_insert(IFNULL(attempts, '[]'), '$[#]', json_object('claimed_at', unixepoch('subsec')))`
+
+// Ends the latest attempt in the attempts column with the outcome bound to its placeholder
+const END_ATTEMPT_EXPRESSION = `json_set(attempts, '$[#-1].ended_at', unixepoch('subsec'), '$[#-1].outcome', ?)`
+
+// One claim of an event, kept with the event so dead letters show whether an event failed
+// instantly every time or timed out after its full claim timeout
+type Attempt struct {
+	ClaimedAt time.Time `json:"claimed_at"`
+	// Zero while the attempt is in progress, and for acked events
+	EndedAt time.Time `json:"ended_at"`
+	// One of the ATTEMPT_ constants, empty while in progress
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// How long the attempt took, 0 while it is in progress
+func (a Attempt) Duration() time.Duration {
+	if a.EndedAt.IsZero() {
+		return 0
+	}
+	return a.EndedAt.Sub(a.ClaimedAt)
+}
+
+// An attempt as stored in the attempts column, with times as fractional unix seconds
+type storedAttempt struct {
+	ClaimedAt float64 `json:"claimed_at"`
+	EndedAt   float64 `json:"ended_at"`
+	Outcome   string  `json:"outcome"`
+}
+
+func unixSeconds(seconds float64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(seconds * 1000)).UTC()
+}
+
+func decodeAttempts(attempts sql.NullString) ([]Attempt, error) {
+	if !attempts.Valid || attempts.String == "" {
+		return nil, nil
+	}
+	var stored []storedAttempt
+	if err := json.Unmarshal([]byte(attempts.String), &stored); err != nil {
+		return nil, err
+	}
+	decoded := make([]Attempt, len(stored))
+	for i, attempt := range stored {
+		decoded[i] = Attempt{ClaimedAt: unixSeconds(attempt.ClaimedAt), EndedAt: unixSeconds(attempt.EndedAt), Outcome: attempt.Outcome}
+	}
+	return decoded, nil
+}
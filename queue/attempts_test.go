@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithEpochClaims(), WithSynchronousMaintenance()).WithClaimTimeout(100 * time.Millisecond).WithMaxRetires(0)
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	claim := func() *Event[Test] {
+		t.Helper()
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		return event
+	}
+
+	event := claim()
+	if len(event.Envelope.Attempts) != 1 || event.Envelope.Attempts[0].ClaimedAt.IsZero() || event.Envelope.Attempts[0].Outcome != "" {
+		t.Fatalf("expected an attempt in progress, got %+v", event.Envelope.Attempts)
+	}
+	if err := q.Release(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	// Left to time out
+	claim()
+	time.Sleep(150 * time.Millisecond)
+	event = claim()
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, err := q.Peek(event.Id)
+	if err != nil || dead == nil || dead.State != EVENT_STATE_DEAD {
+		t.Fatalf("expected a dead event, got %+v: %v", dead, err)
+	}
+	outcomes := []string{ATTEMPT_RELEASED, ATTEMPT_TIMED_OUT, ATTEMPT_FAILED}
+	if len(dead.Attempts) != len(outcomes) {
+		t.Fatalf("expected %d attempts, got %+v", len(outcomes), dead.Attempts)
+	}
+	for i, attempt := range dead.Attempts {
+		if attempt.Outcome != outcomes[i] {
+			t.Fatalf("expected attempt %d to be %s, got %s", i, outcomes[i], attempt.Outcome)
+		}
+		if attempt.EndedAt.Before(attempt.ClaimedAt) {
+			t.Fatalf("expected attempt %d to end after it started, got %+v", i, attempt)
+		}
+	}
+	if d := dead.Attempts[1].Duration(); d < 100*time.Millisecond {
+		t.Fatalf("expected the timed out attempt to last the claim timeout, got %v", d)
+	}
+}
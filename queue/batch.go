@@ -0,0 +1,297 @@
+package queue
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const CREATE_BATCHES_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_batches (
+    id TEXT PRIMARY KEY,
+    total INTEGER NOT NULL,              -- events inserted with the batch
+    parent_id INTEGER,                   -- event that spawned the batch, see SpawnChildren
+    continuation TEXT,                   -- payload inserted once the batch completes
+    created_at TEXT DEFAULT (datetime('now', 'utc')),
+    completed_at TEXT                    -- when the last event was acked or dead lettered
+);
+`
+
+// Lets batch progress be counted without scanning the event tables
+var CREATE_BATCH_INDEX_STATEMENTS = []string{
+	`CREATE INDEX IF NOT EXISTS idx_batches_parent_id ON queue_batches (parent_id) WHERE parent_id IS NOT NULL;`,
+	`CREATE INDEX IF NOT EXISTS idx_queue_batch_id ON queue (batch_id) WHERE batch_id IS NOT NULL;`,
+	`CREATE INDEX IF NOT EXISTS idx_inflight_batch_id ON queue_inflight (batch_id) WHERE batch_id IS NOT NULL;`,
+	`CREATE INDEX IF NOT EXISTS idx_dead_batch_id ON queue_dead (batch_id) WHERE batch_id IS NOT NULL;`,
+}
+
+const INSERT_BATCH_QUERY = `INSERT INTO queue_batches (id, total, parent_id, continuation) VALUES (?, ?, ?, ?)`
+
+const BATCH_STATUS_QUERY = `
+SELECT total, completed_at IS NOT NULL, IFNULL(parent_id, 0),
+    (SELECT COUNT(*) FROM queue WHERE batch_id = ?) + (SELECT COUNT(*) FROM queue_inflight WHERE batch_id = ?),
+    (SELECT COUNT(*) FROM queue_dead WHERE batch_id = ?)
+FROM queue_batches WHERE id = ?
+`
+
+const COMPLETE_BATCH_QUERY = `
+UPDATE queue_batches SET completed_at = datetime('now', 'utc')
+WHERE id = ? AND completed_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM queue WHERE batch_id = ?)
+AND NOT EXISTS (SELECT 1 FROM queue_inflight WHERE batch_id = ?)
+RETURNING IFNULL(parent_id, 0), continuation
+`
+
+// Returned by BatchStatus for batch ids that InsertBatch never returned
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Progress of a batch of events inserted with InsertBatch
+type BatchStatus struct {
+	BatchID string
+	// The event that spawned the batch with SpawnChildren, 0 for batches from InsertBatch
+	ParentID int
+	// Events inserted with the batch, not counting any skipped as duplicates
+	Total int
+	// Events that are pending or in flight
+	Pending int
+	// Events in the dead letter table
+	Failed int
+	// Events that were acked
+	Done int
+	// Whether every event was either acked or dead lettered
+	Complete bool
+}
+
+// Insert payloads as one batch in a single transaction, returning the id of the batch. The
+// batch's progress can be followed with BatchStatus, and WithOnBatchComplete is told once
+// every event was acked or dead lettered, e.g. to aggregate the results of a fan-out.
+// Options apply to every payload
+func (q *Queue[T]) InsertBatch(payloads []T, opts ...InsertOption) (string, error) {
+	return q.insertBatchOf(payloads, 0, nil, opts...)
+}
+
+// Insert payloads as a batch, as children of the event with id: parentID if it isn't 0.
+// continuation is inserted once the batch completes if it isn't nil
+func (q *Queue[T]) insertBatchOf(payloads []T, parentID int, continuation *T, opts ...InsertOption) (string, error) {
+	if len(payloads) == 0 {
+		return "", errors.New("can't insert an empty batch")
+	}
+	pausedOpts, err := q.pausedInsertOptions()
+	if err != nil {
+		return "", err
+	}
+	opts = append(opts, pausedOpts...)
+	batchID, err := newBatchID()
+	if err != nil {
+		return "", err
+	}
+	envelopes := make([]Envelope, len(payloads))
+	for i, payload := range payloads {
+		data, err := q.codec.Marshal(payload)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal data of type %T: %w", payload, err)
+		}
+		envelopes[i] = q.newEnvelope(data, opts...)
+		envelopes[i].Batch = batchID
+		envelopes[i].Parent = parentID
+	}
+	var encodedContinuation any
+	if continuation != nil {
+		data, err := q.codec.Marshal(*continuation)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal data of type %T: %w", *continuation, err)
+		}
+		encodedContinuation = string(data)
+	}
+	if q.insertLimiter != nil {
+		if err := q.insertLimiter.take(len(payloads)); err != nil {
+			return "", err
+		}
+	}
+	var ts transitions
+	err = q.retryTransient(func(opID string) error {
+		ts.reset()
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.insertBatchEnvelopes(batchID, parentID, encodedContinuation, envelopes, opID, &ts)
+	})
+	if err != nil {
+		return "", fmt.Errorf("problem inserting batch of %d events: %w", len(payloads), err)
+	}
+	q.notifyTransitions(ts)
+	if len(ts) == 0 {
+		// Every payload was a duplicate, so there is nothing to wait for. Also checked when
+		// an earlier attempt was committed, which only completes the batch if it's done
+		q.completeBatches([]string{batchID})
+	}
+	q.checkEmpty()
+	return batchID, nil
+}
+
+func (q *Queue[T]) insertBatchEnvelopes(batchID string, parentID int, continuation any, envelopes []Envelope, opID string, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return err
+	}
+	if parentID != 0 {
+		if err := checkParent(tx, parentID); err != nil {
+			return err
+		}
+	}
+	for _, envelope := range envelopes {
+		if err := q.insertEncoded(tx, envelope, ts); err != nil {
+			return err
+		}
+	}
+	var parent any
+	if parentID != 0 {
+		parent = parentID
+	}
+	if _, err := tx.Exec(INSERT_BATCH_QUERY, batchID, len(*ts), parent, continuation); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+// Random id for a new batch
+func newBatchID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("problem generating batch id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// How far along the batch with id: batchID is
+func (q *Queue[T]) BatchStatus(batchID string) (BatchStatus, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	status, err := batchStatus(q.db, batchID)
+	if err == sql.ErrNoRows {
+		return BatchStatus{}, fmt.Errorf("%w: %s", ErrBatchNotFound, batchID)
+	} else if err != nil {
+		return BatchStatus{}, fmt.Errorf("problem reading status of batch %s: %w", batchID, err)
+	}
+	return status, nil
+}
+
+func batchStatus(db execer, batchID string) (BatchStatus, error) {
+	status := BatchStatus{BatchID: batchID}
+	err := db.QueryRow(BATCH_STATUS_QUERY, batchID, batchID, batchID, batchID).Scan(&status.Total, &status.Complete, &status.ParentID, &status.Pending, &status.Failed)
+	if err != nil {
+		return status, err
+	}
+	status.Done = max(status.Total-status.Pending-status.Failed, 0)
+	return status, nil
+}
+
+// Register a function to be called once for each batch inserted with InsertBatch, after the
+// last of its events was acked or dead lettered. Like transition hooks, fn runs on the
+// goroutine that made the final transition and must not block for long. Events returned
+// to the queue with Unack after their batch completed don't reopen it.
+func (q *Queue[T]) WithOnBatchComplete(fn func(BatchStatus)) *Queue[T] {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	q.onBatchComplete = fn
+	return q
+}
+
+// Complete the batches of events that were acked or dead lettered in ts if they have
+// nothing left to process. Must not be called with q.lock held
+func (q *Queue[T]) checkBatches(ts transitions) {
+	batchIDs := []string{}
+	seen := map[string]bool{}
+	for _, t := range ts {
+		if t.envelope.Batch == "" || seen[t.envelope.Batch] {
+			continue
+		}
+		if t.transition == TRANSITION_ACKED || t.transition == TRANSITION_DEAD_LETTERED {
+			seen[t.envelope.Batch] = true
+			batchIDs = append(batchIDs, t.envelope.Batch)
+		}
+	}
+	q.completeBatches(batchIDs)
+}
+
+// Mark the batches that have no events left to process as complete and call the batch
+// completion hook for each. The update makes sure every batch is only completed once, even
+// if several processes share the queue. Must not be called with q.lock held
+func (q *Queue[T]) completeBatches(batchIDs []string) {
+	if len(batchIDs) == 0 {
+		return
+	}
+	var ts transitions
+	completed := []BatchStatus{}
+	q.lock.Lock()
+	for _, batchID := range batchIDs {
+		status, err := q.completeBatch(batchID, &ts)
+		if err != nil {
+			q.logger().Error(fmt.Sprintf("problem checking whether batch %s is complete: %s", batchID, err))
+			continue
+		}
+		if status != nil {
+			completed = append(completed, *status)
+		}
+	}
+	q.lock.Unlock()
+	q.hookLock.Lock()
+	onBatchComplete := q.onBatchComplete
+	q.hookLock.Unlock()
+	if onBatchComplete != nil {
+		for _, status := range completed {
+			onBatchComplete(status)
+		}
+	}
+	// Continuations of the completed batches
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+}
+
+// The batch's final status if this call completed it, nil otherwise. Completing a batch of
+// children releases their parent and inserts the batch's continuation, see SpawnChildren
+func (q *Queue[T]) completeBatch(batchID string, ts *transitions) (*BatchStatus, error) {
+	recorded := len(*ts)
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	var parentID int
+	var continuation sql.NullString
+	err = tx.QueryRow(COMPLETE_BATCH_QUERY, batchID, batchID, batchID).Scan(&parentID, &continuation)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	status, err := batchStatus(tx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if parentID != 0 {
+		if err := q.releaseParent(tx, parentID); err != nil {
+			return nil, err
+		}
+	}
+	if continuation.Valid {
+		envelope := newEnvelope([]byte(continuation.String))
+		envelope.Parent = parentID
+		if err := q.insertEncoded(tx, envelope, ts); err != nil {
+			return nil, fmt.Errorf("problem inserting continuation: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		*ts = (*ts)[:recorded]
+		return nil, err
+	}
+	return &status, nil
+}
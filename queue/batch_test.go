@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithMaxRetires(0)
+	completed := []BatchStatus{}
+	q = q.WithOnBatchComplete(func(status BatchStatus) {
+		completed = append(completed, status)
+	})
+
+	batchID, err := q.InsertBatch([]Test{{A: "one"}, {A: "two"}, {A: "three"}}, WithKind("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := q.BatchStatus(batchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (BatchStatus{BatchID: batchID, Total: 3, Pending: 3}) {
+		t.Fatalf("unexpected status of a new batch: %+v", status)
+	}
+
+	for i := range 3 {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		if event.Envelope.Batch != batchID {
+			t.Fatalf("expected event to belong to batch %s, got %q", batchID, event.Envelope.Batch)
+		}
+		if len(completed) != 0 {
+			t.Fatalf("expected the batch to be incomplete, got %+v", completed)
+		}
+		if i == 0 {
+			if err := q.Nack(event.Id); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := BatchStatus{BatchID: batchID, Total: 3, Failed: 1, Done: 2, Complete: true}
+	if len(completed) != 1 || completed[0] != want {
+		t.Fatalf("expected the batch to complete once with %+v, got %+v", want, completed)
+	}
+	if status, err := q.BatchStatus(batchID); err != nil || status != want {
+		t.Fatalf("expected %+v, got %+v: %v", want, status, err)
+	}
+	if _, err := q.BatchStatus("unknown"); !errors.Is(err, ErrBatchNotFound) {
+		t.Fatalf("expected ErrBatchNotFound, got %v", err)
+	}
+}
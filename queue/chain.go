@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Every archived event gets a link in the chain, in the order it was archived. A link's
+// hash covers the archived row and the previous link's hash, so modifying or deleting
+// archived events, or links, breaks every later hash.
+const CREATE_ARCHIVE_CHAIN_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_archive_chain (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id INTEGER NOT NULL,           -- id of the event in the archive table
+    hash TEXT NOT NULL                   -- hex sha256 of the previous hash and the archived row
+);
+`
+
+const CHAIN_HEAD_QUERY = `SELECT hash FROM queue_archive_chain ORDER BY seq DESC LIMIT 1`
+
+const INSERT_CHAIN_LINK_QUERY = `INSERT INTO queue_archive_chain (event_id, hash) VALUES (?, ?)`
+
+const CHAIN_LINKS_QUERY = `SELECT seq, event_id, hash FROM queue_archive_chain ORDER BY seq ASC`
+
+// The fields of an archived event covered by its link's hash, timestamps as unix seconds
+// so they hash the same however the driver hands them back
+const CHAINED_ROW_QUERY = `
+SELECT id, IFNULL(payload, ''), IFNULL(kind, ''), IFNULL(headers, ''),
+    IFNULL(CAST(strftime('%s', enqueued_at) AS INTEGER), 0), IFNULL(retries, 0),
+    IFNULL(CAST(strftime('%s', acked_at) AS INTEGER), 0)
+FROM queue_archive WHERE id = ?
+`
+
+// Returned by Verify when the archive doesn't match its hash chain
+var ErrArchiveTampered = errors.New("archive does not match its hash chain")
+
+// Keep acked events in the archive table, like WithArchive, and chain-hash every archived
+// event so deleting or modifying the processing history can be detected with Verify. Only
+// events archived after this is enabled are covered
+func (q *Queue[T]) WithArchiveHashChain() *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.archive = true
+	q.hashChain = true
+	q.storeSetting(ARCHIVE_SETTING, true)
+	q.storeSetting(ARCHIVE_HASH_CHAIN_SETTING, true)
+	return q
+}
+
+// The result of a successful Verify
+type ChainVerification struct {
+	// Number of archived events covered by the chain, since the last TruncateArchive
+	Events int
+	// Hash of the last link, empty if nothing was archived yet. Record it somewhere outside
+	// the database to also detect events being removed from the end of the chain
+	Head string
+}
+
+// Check the archive against the hash chain kept by WithArchiveHashChain, failing with
+// ErrArchiveTampered at the first archived event that was deleted or modified. After
+// TruncateArchive the chain is checked from the last truncated link on
+func (q *Queue[T]) Verify() (ChainVerification, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	tx, err := q.db.Begin()
+	if err != nil {
+		return ChainVerification{}, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	rows, err := tx.Query(CHAIN_LINKS_QUERY)
+	if err != nil {
+		return ChainVerification{}, fmt.Errorf("problem reading hash chain: %w", err)
+	}
+	type link struct {
+		seq, eventID int
+		hash         string
+	}
+	links := []link{}
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.seq, &l.eventID, &l.hash); err != nil {
+			_ = rows.Close()
+			return ChainVerification{}, fmt.Errorf("problem reading hash chain: %w", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Close(); err != nil {
+		return ChainVerification{}, fmt.Errorf("problem reading hash chain: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return ChainVerification{}, fmt.Errorf("problem reading hash chain: %w", err)
+	}
+	checkpoint, head, err := latestArchiveCheckpoint(tx)
+	if err != nil {
+		return ChainVerification{}, err
+	}
+	verification := ChainVerification{Head: head}
+	for i, l := range links {
+		if l.seq != checkpoint+i+1 {
+			return verification, fmt.Errorf("%w: link %d is missing", ErrArchiveTampered, checkpoint+i+1)
+		}
+		hash, err := chainHash(tx, verification.Head, l.eventID)
+		if err == sql.ErrNoRows {
+			return verification, fmt.Errorf("%w: event %d was deleted", ErrArchiveTampered, l.eventID)
+		} else if err != nil {
+			return verification, fmt.Errorf("problem hashing archived event %d: %w", l.eventID, err)
+		}
+		if hash != l.hash {
+			return verification, fmt.Errorf("%w: event %d was modified", ErrArchiveTampered, l.eventID)
+		}
+		verification.Events++
+		verification.Head = hash
+	}
+	return verification, nil
+}
+
+// Append links for events that were just archived to the hash chain. The archive insert
+// already holds the database's write lock, so links from different processes can't fork
+func chainArchived(tx *sql.Tx, ids []int) error {
+	var head string
+	if err := tx.QueryRow(CHAIN_HEAD_QUERY).Scan(&head); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("problem reading hash chain head: %w", err)
+	}
+	for _, id := range ids {
+		hash, err := chainHash(tx, head, id)
+		if err != nil {
+			return fmt.Errorf("problem hashing archived event %d: %w", id, err)
+		}
+		if _, err := tx.Exec(INSERT_CHAIN_LINK_QUERY, id, hash); err != nil {
+			return fmt.Errorf("problem appending event %d to hash chain: %w", id, err)
+		}
+		head = hash
+	}
+	return nil
+}
+
+// The hash of the archived event with id: id linked after previous
+func chainHash(tx *sql.Tx, previous string, id int) (string, error) {
+	var (
+		eventID                    int
+		payload, kind, headers     string
+		enqueuedAt, retries, acked int64
+	)
+	err := tx.QueryRow(CHAINED_ROW_QUERY, id).Scan(&eventID, &payload, &kind, &headers, &enqueuedAt, &retries, &acked)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	// Length prefixes keep field boundaries from being shifted between fields
+	for _, field := range []string{previous, fmt.Sprint(eventID), payload, kind, headers, fmt.Sprint(enqueuedAt), fmt.Sprint(retries), fmt.Sprint(acked)} {
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestArchiveHashChain(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithArchiveHashChain()
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verification, err := q.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Events != 3 || verification.Head == "" {
+		t.Fatalf("expected a chain of 3 events, got %+v", verification)
+	}
+
+	if _, err := q.db.Exec(`UPDATE queue_archive SET payload = '{"A":"forged"}' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Verify(); !errors.Is(err, ErrArchiveTampered) {
+		t.Fatalf("expected a modified event to be detected, got %v", err)
+	}
+	if _, err := q.db.Exec(`DELETE FROM queue_archive WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Verify(); !errors.Is(err, ErrArchiveTampered) {
+		t.Fatalf("expected a deleted event to be detected, got %v", err)
+	}
+}
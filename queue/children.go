@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Returned by SpawnChildren when the parent is no longer pending or in flight
+var ErrParentNotFound = errors.New("parent event not found")
+
+const PARENT_EXISTS_QUERY = `
+SELECT EXISTS (SELECT 1 FROM queue_inflight WHERE id = ?) OR EXISTS (SELECT 1 FROM queue WHERE id = ?)
+`
+
+const AWAITING_CHILDREN_QUERY = `SELECT EXISTS (SELECT 1 FROM queue_batches WHERE parent_id = ? AND completed_at IS NULL)`
+
+const RELEASE_PARENT_QUERY = `UPDATE queue_completed SET purge_at = IFNULL(?, datetime('now', 'utc')) WHERE id = ? AND purge_at IS NULL`
+
+// Insert children as a batch linked to the event with id: parentID, typically from within
+// the parent's handler. The parent is acked as usual, but it is only fully complete once
+// every child was acked or dead lettered: until then it is kept as a completed event
+// without a purge time, see Peek. Once the children are done the parent is purged like any
+// other acked event and continuation, unless it is nil, is inserted with its Parent set to
+// parentID, e.g. to aggregate the children's results. Returns the id of the children's
+// batch, see BatchStatus. Options apply to every child
+func (q *Queue[T]) SpawnChildren(parentID int, children []T, continuation *T, opts ...InsertOption) (string, error) {
+	batchID, err := q.insertBatchOf(children, parentID, continuation, opts...)
+	if err != nil {
+		return "", fmt.Errorf("problem spawning children of event %d: %w", parentID, err)
+	}
+	return batchID, nil
+}
+
+// Fail with ErrParentNotFound unless the event with id: parentID can still be acked
+func checkParent(tx *sql.Tx, parentID int) error {
+	var exists bool
+	if err := tx.QueryRow(PARENT_EXISTS_QUERY, parentID, parentID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrParentNotFound, parentID)
+	}
+	return nil
+}
+
+// Whether the event with id: id has spawned children that are not done yet
+func awaitingChildren(tx *sql.Tx, id int) (bool, error) {
+	var awaiting bool
+	if err := tx.QueryRow(AWAITING_CHILDREN_QUERY, id).Scan(&awaiting); err != nil {
+		return false, fmt.Errorf("problem looking up children of event %d: %w", id, err)
+	}
+	return awaiting, nil
+}
+
+// Let an acked parent be purged once none of its batches of children are left. Parents
+// that weren't acked yet are left alone, they are acked normally later
+func (q *Queue[T]) releaseParent(tx *sql.Tx, parentID int) error {
+	awaiting, err := awaitingChildren(tx, parentID)
+	if err != nil || awaiting {
+		return err
+	}
+	// Without a grace period the parent is purged right away
+	var purgeAt any
+	if purge := q.purgeTime(); !purge.IsZero() {
+		purgeAt = formatSqliteTime(purge)
+	}
+	if _, err := tx.Exec(RELEASE_PARENT_QUERY, purgeAt, parentID); err != nil {
+		return fmt.Errorf("problem releasing parent %d: %w", parentID, err)
+	}
+	return nil
+}
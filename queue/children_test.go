@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSpawnChildren(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithClaimTimeoutSeconds(0)
+
+	if err := q.Insert(Test{A: "parent"}); err != nil {
+		t.Fatal(err)
+	}
+	parent, err := q.Next()
+	if err != nil || parent == nil {
+		t.Fatalf("expected the parent, got %v: %v", parent, err)
+	}
+	batchID, err := q.SpawnChildren(parent.Id, []Test{{A: "child one"}, {A: "child two"}}, &Test{A: "aggregate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(parent.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.SpawnChildren(parent.Id, []Test{{A: "late"}}, nil); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound for an acked parent, got %v", err)
+	}
+
+	for range 2 {
+		child, err := q.Next()
+		if err != nil || child == nil {
+			t.Fatalf("expected a child, got %v: %v", child, err)
+		}
+		if child.Envelope.Parent != parent.Id || child.Envelope.Batch != batchID {
+			t.Fatalf("expected a child of %d in batch %s, got %+v", parent.Id, batchID, child.Envelope)
+		}
+		// The parent is kept until its children are done
+		if held, err := q.Peek(parent.Id); err != nil || held == nil || held.State != EVENT_STATE_COMPLETED {
+			t.Fatalf("expected the parent to be held, got %v: %v", held, err)
+		}
+		if err := q.Ack(child.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	continuation, err := q.Next()
+	if err != nil || continuation == nil {
+		t.Fatalf("expected the continuation, got %v: %v", continuation, err)
+	}
+	if continuation.Content.A != "aggregate" || continuation.Envelope.Parent != parent.Id {
+		t.Fatalf("unexpected continuation %+v", continuation)
+	}
+	if status, err := q.BatchStatus(batchID); err != nil || !status.Complete || status.Done != 2 || status.ParentID != parent.Id {
+		t.Fatalf("expected the children's batch to be complete, got %+v: %v", status, err)
+	}
+	if err := q.Ack(continuation.Id); err != nil {
+		t.Fatal(err)
+	}
+	// Maintenance purges the released parent
+	if _, err := q.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := q.Peek(parent.Id); err != nil || purged != nil {
+		t.Fatalf("expected the parent to be purged, got %v: %v", purged, err)
+	}
+}
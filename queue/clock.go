@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// How claim and backoff expiry times are stored in claim_expires and compared against the
+// current time. Either way the time comes from the database, never from the processes
+// sharing the queue, so their clocks can't disagree about when a claim expires.
+type claimClock struct {
+	// SQL expression for the current time
+	now string
+	// SQL expression for the current time plus the duration bound to its ? argument
+	after string
+	// The argument for after
+	offset func(time.Duration) any
+}
+
+// Expiry as datetime strings, which only have second precision. Sub-second durations are
+// rounded up to a whole second
+var WALL_CLOCK = claimClock{
+	now:   "datetime('now', 'utc')",
+	after: "datetime('now', printf('+%d seconds', ?), 'utc')",
+	offset: func(d time.Duration) any {
+		return int(math.Ceil(d.Seconds()))
+	},
+}
+
+// Expiry as fractional unix epoch seconds, see WithEpochClaims
+var EPOCH_CLOCK = claimClock{
+	now:   "unixepoch('subsec')",
+	after: "(unixepoch('subsec') + ?)",
+	offset: func(d time.Duration) any {
+		return d.Seconds()
+	},
+}
+
+// Store claim and backoff expiry as unix epoch seconds with sub-second precision instead of
+// datetime strings, enabling claim timeouts well below a second, see WithClaimTimeout.
+// Every process sharing the queue must use the same setting: expiry times left over in the
+// other format are converted when the queue is opened, but a process still using the other
+// format would compare them incorrectly.
+func WithEpochClaims() Option {
+	return func(o *options) {
+		o.epochClaims = true
+	}
+}
+
+// Matches expiry times stored by WALL_CLOCK. claim_expires has TEXT affinity, so epoch
+// seconds are stored as text too and can only be told apart by their format
+const DATETIME_EXPIRY_CONDITION = `claim_expires GLOB '[0-9][0-9][0-9][0-9]-*'`
+
+// Rewrite expiry times stored by the other clock. Mixed formats compare as text, so they
+// would never expire or expire immediately
+var CONVERT_CLAIM_EXPIRY_STATEMENTS = map[bool]string{
+	true:  `UPDATE %s SET claim_expires = unixepoch(claim_expires, 'subsec') WHERE ` + DATETIME_EXPIRY_CONDITION,
+	false: `UPDATE %s SET claim_expires = datetime(CAST(claim_expires AS REAL), 'unixepoch') WHERE claim_expires IS NOT NULL AND NOT ` + DATETIME_EXPIRY_CONDITION,
+}
+
+// Bring stored expiry times in line with the clock the queue is opened with
+func convertClaimExpiry(db *sql.DB, epoch bool) error {
+	for _, table := range []string{PENDING_TABLE, INFLIGHT_TABLE} {
+		if _, err := db.Exec(fmt.Sprintf(CONVERT_CLAIM_EXPIRY_STATEMENTS[epoch], table)); err != nil {
+			return fmt.Errorf("problem converting claim expiry in %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Configure how long a process has to process an event before it is made available to be
+// consumed by other processes. Queues opened WithEpochClaims support sub-second timeouts,
+// e.g. 200ms, otherwise timeouts are rounded up to whole seconds. Expired claims are
+// reclaimed by maintenance, which runs once per claim timeout
+func (q *Queue[T]) WithClaimTimeout(timeout time.Duration) *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.claimTimeout = timeout
+	return q
+}
@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEpochClaims(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithEpochClaims(), WithSynchronousMaintenance()).WithClaimTimeout(200 * time.Millisecond)
+
+	if err := q.Insert(Test{A: "fast"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if again, err := q.Next(); err != nil || again != nil {
+		t.Fatalf("expected the event to stay claimed, got %v: %v", again, err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	again, err := q.Next()
+	if err != nil || again == nil || again.Id != event.Id {
+		t.Fatalf("expected the claim to expire after 200ms, got %v: %v", again, err)
+	}
+
+	// Reopening without epoch claims converts the stored expiry back to a datetime
+	var kind string
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	reopened, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err == nil {
+		err = reopened.db.QueryRow("SELECT typeof(claim_expires) FROM queue_inflight").Scan(&kind)
+	}
+	if err != nil || kind != "text" {
+		t.Fatalf("expected expiry to be converted to text, got %q: %v", kind, err)
+	}
+}
+
+func TestEpochClaimsSurviveReopening(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithEpochClaims(), WithSynchronousMaintenance()).WithClaimTimeout(time.Hour)
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	for _, a := range []string{"claimed", "backing off"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	backingOff, err := q.WithRetryBackoffSeconds(3600).Next()
+	if err != nil || backingOff == nil {
+		t.Fatalf("expected an event, got %v: %v", backingOff, err)
+	}
+	if err := q.Nack(backingOff.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened with the same clock, then the other one and back again, both expiry times
+	// stay an hour away
+	for _, opts := range [][]Option{{WithEpochClaims()}, {}, {WithEpochClaims()}} {
+		reopened, err := NewLocalQueue[Test](name, append(opts, WithSynchronousMaintenance())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var remaining float64
+		for _, table := range []string{INFLIGHT_TABLE, PENDING_TABLE} {
+			query := fmt.Sprintf("SELECT unixepoch(claim_expires, 'subsec') - unixepoch('subsec') FROM %s", table)
+			if len(opts) > 0 {
+				query = fmt.Sprintf("SELECT claim_expires - unixepoch('subsec') FROM %s", table)
+			}
+			if err := reopened.db.QueryRow(query).Scan(&remaining); err != nil || remaining < 3500 {
+				t.Fatalf("expected the expiry in %s to be kept, got %v seconds left: %v", table, remaining, err)
+			}
+		}
+		if next, err := reopened.Next(); err != nil || next != nil {
+			t.Fatalf("expected both events to stay unavailable, got %v: %v", next, err)
+		}
+		if err := reopened.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// A Codec turns payloads into the text stored in the database and back again
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// The default codec, plain encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// A JSON codec that always produces the same bytes for equal values: object keys are
+// sorted (including struct fields) and insignificant whitespace is removed. Use it when
+// the stored payload is hashed or diffed, e.g. for deduplication or change detection.
+type CanonicalJSONCodec struct{}
+
+func (CanonicalJSONCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Round trip through a generic value, encoding/json writes map keys in sorted order.
+	// UseNumber keeps numbers exactly as they were written instead of going via float64
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+func (CanonicalJSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
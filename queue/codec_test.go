@@ -0,0 +1,73 @@
+package queue
+
+import "testing"
+
+func TestCanonicalJSONCodec(t *testing.T) {
+	type Test struct {
+		B int
+		A map[string]int
+	}
+	codec := CanonicalJSONCodec{}
+	data, err := codec.Marshal(Test{B: 12345678901234567, A: map[string]int{"z": 1, "a": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"A":{"a":2,"z":1},"B":12345678901234567}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+
+	var decoded Test
+	if err := codec.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.B != 12345678901234567 || decoded.A["z"] != 1 {
+		t.Fatal("payload did not survive a round trip")
+	}
+}
+
+func TestInsertWithQuotes(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithCodec(CanonicalJSONCodec{})
+
+	data := Test{A: "it's quoted"}
+	if err := q.Insert(data); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if event.Content.A != data.A {
+		t.Fatal()
+	}
+}
+
+func TestContentDedup(t *testing.T) {
+	type Test struct{ A map[string]int }
+	q := newTestQueue[Test](t).WithCodec(CanonicalJSONCodec{}).WithContentDedup()
+
+	for range 3 {
+		if err := q.Insert(Test{A: map[string]int{"x": 1, "y": 2}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("expected duplicates to be skipped, got size %d", size)
+	}
+
+	// Once acked the same payload can be enqueued again
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: map[string]int{"x": 1, "y": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("expected payload to be enqueued again, got size %d", size)
+	}
+}
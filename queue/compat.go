@@ -0,0 +1,199 @@
+package queue
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Set to regenerate the snapshots CheckPayloadCompatibility compares against, e.g. after
+// draining the queues whose payloads changed incompatibly
+const UPDATE_SCHEMAS_ENV = "LIBSQLQ_UPDATE_SCHEMAS"
+
+// The JSON shape of a payload type: the kind of value at every field path, e.g.
+// "Items[].Price" → "number". Paths through maps end in {}
+type PayloadSchema map[string]string
+
+const (
+	SCHEMA_STRING  = "string"
+	SCHEMA_INTEGER = "integer"
+	SCHEMA_NUMBER  = "number"
+	SCHEMA_BOOLEAN = "boolean"
+	SCHEMA_OBJECT  = "object"
+	SCHEMA_ARRAY   = "array"
+	// Types with custom JSON decoding, anything may be valid
+	SCHEMA_ANY = "any"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// Derive the JSON schema of T as encoding/json sees it
+func SchemaOf[T any]() PayloadSchema {
+	schema := PayloadSchema{}
+	describe(schema, "", reflect.TypeFor[T](), map[reflect.Type]bool{})
+	return schema
+}
+
+func describe(schema PayloadSchema, path string, t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	root := path
+	if root == "" {
+		root = "."
+	}
+	pointer := reflect.PointerTo(t)
+	switch {
+	case pointer.Implements(textUnmarshalerType):
+		// e.g. time.Time, which implements json.Unmarshaler too
+		schema[root] = SCHEMA_STRING
+		return
+	case pointer.Implements(jsonUnmarshalerType):
+		schema[root] = SCHEMA_ANY
+		return
+	}
+	switch t.Kind() {
+	case reflect.String:
+		schema[root] = SCHEMA_STRING
+	case reflect.Bool:
+		schema[root] = SCHEMA_BOOLEAN
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		schema[root] = SCHEMA_INTEGER
+	case reflect.Float32, reflect.Float64:
+		schema[root] = SCHEMA_NUMBER
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Encoded as base64
+			schema[root] = SCHEMA_STRING
+			return
+		}
+		schema[root] = SCHEMA_ARRAY
+		describe(schema, path+"[]", t.Elem(), seen)
+	case reflect.Map:
+		schema[root] = SCHEMA_OBJECT
+		describe(schema, path+"{}", t.Elem(), seen)
+	case reflect.Struct:
+		schema[root] = SCHEMA_OBJECT
+		if seen[t] {
+			// Recursive types are only described once
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		describeFields(schema, path, t, seen)
+	default:
+		schema[root] = SCHEMA_ANY
+	}
+}
+
+func describeFields(schema PayloadSchema, path string, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, flags, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			// Fields of embedded structs are promoted
+			describeFields(schema, path, fieldType, seen)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		if slices.Contains(strings.Split(flags, ","), "string") {
+			schema[fieldPath] = SCHEMA_STRING
+			continue
+		}
+		describe(schema, fieldPath, field.Type, seen)
+	}
+}
+
+// The changes from s to next that would break or silently lose data when events encoded
+// with s are decoded with next: removed fields and fields whose kind changed. Added fields
+// are fine, they decode to their zero value
+func (s PayloadSchema) BreakingChanges(next PayloadSchema) []string {
+	changes := []string{}
+	for _, path := range slices.Sorted(maps.Keys(s)) {
+		before := s[path]
+		after, ok := next[path]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s was removed", path))
+		case after == before, after == SCHEMA_ANY:
+		case before == SCHEMA_INTEGER && after == SCHEMA_NUMBER:
+			// Every integer decodes as a float
+		default:
+			changes = append(changes, fmt.Sprintf("%s changed from %s to %s", path, before, after))
+		}
+	}
+	return changes
+}
+
+// The subset of testing.TB CheckPayloadCompatibility needs
+type TB interface {
+	Helper()
+	Logf(format string, args ...any)
+	Fatalf(format string, args ...any)
+}
+
+// Fail t when T can no longer decode events encoded with the schema snapshotted at path, so
+// a deploy doesn't poison the backlog with events its consumers can't decode. The snapshot
+// is written when it doesn't exist yet, or when UPDATE_SCHEMAS_ENV is set. Call it from a
+// test for every payload type, e.g. CheckPayloadCompatibility[Email](t, "testdata/email.schema.json")
+func CheckPayloadCompatibility[T any](t TB, path string) {
+	t.Helper()
+	current := SchemaOf[T]()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || os.Getenv(UPDATE_SCHEMAS_ENV) != "" {
+		if err := writeSchema(path, current); err != nil {
+			t.Fatalf("problem writing schema snapshot: %v", err)
+		}
+		t.Logf("wrote schema snapshot of %s to %s", reflect.TypeFor[T](), path)
+		return
+	} else if err != nil {
+		t.Fatalf("problem reading schema snapshot: %v", err)
+	}
+	var snapshot PayloadSchema
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("problem decoding schema snapshot %s: %v", path, err)
+	}
+	if changes := snapshot.BreakingChanges(current); len(changes) > 0 {
+		t.Fatalf("%s can't decode events already in the queue:\n  %s\ndrain the queue and rerun with %s=1 to accept the new schema",
+			reflect.TypeFor[T](), strings.Join(changes, "\n  "), UPDATE_SCHEMAS_ENV)
+	}
+}
+
+func writeSchema(path string, schema PayloadSchema) error {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records failures instead of stopping the test
+type recordingTB struct {
+	*testing.T
+	failure string
+}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func TestSchemaOf(t *testing.T) {
+	type Base struct{ Tenant string }
+	type Item struct {
+		Price float64 `json:"price"`
+	}
+	type Order struct {
+		Base
+		Id       int64             `json:"id"`
+		Count    int               `json:"count,string"`
+		Items    []Item            `json:"items"`
+		Meta     map[string]string `json:"meta"`
+		At       time.Time         `json:"at"`
+		Internal string            `json:"-"`
+	}
+	schema := SchemaOf[Order]()
+	expected := PayloadSchema{
+		".":             SCHEMA_OBJECT,
+		"Tenant":        SCHEMA_STRING,
+		"id":            SCHEMA_INTEGER,
+		"count":         SCHEMA_STRING,
+		"items":         SCHEMA_ARRAY,
+		"items[]":       SCHEMA_OBJECT,
+		"items[].price": SCHEMA_NUMBER,
+		"meta":          SCHEMA_OBJECT,
+		"meta{}":        SCHEMA_STRING,
+		"at":            SCHEMA_STRING,
+	}
+	if len(schema) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, schema)
+	}
+	for path, kind := range expected {
+		if schema[path] != kind {
+			t.Fatalf("expected %s to be %s, got %s", path, kind, schema[path])
+		}
+	}
+}
+
+func TestBreakingChanges(t *testing.T) {
+	before := PayloadSchema{".": SCHEMA_OBJECT, "a": SCHEMA_INTEGER, "b": SCHEMA_STRING, "c": SCHEMA_NUMBER}
+	after := PayloadSchema{".": SCHEMA_OBJECT, "a": SCHEMA_NUMBER, "c": SCHEMA_STRING, "d": SCHEMA_BOOLEAN}
+	changes := before.BreakingChanges(after)
+	if len(changes) != 2 || changes[0] != "b was removed" || changes[1] != "c changed from number to string" {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+}
+
+func TestCheckPayloadCompatibility(t *testing.T) {
+	type V1 struct {
+		A string
+		B int
+	}
+	type V2 struct {
+		A string
+		B int
+		C bool
+	}
+	type V3 struct {
+		A string
+		B string
+	}
+	path := filepath.Join(t.TempDir(), "payload.schema.json")
+
+	tb := &recordingTB{T: t}
+	CheckPayloadCompatibility[V1](tb, path)
+	if tb.failure != "" {
+		t.Fatalf("expected the snapshot to be written, got %s", tb.failure)
+	}
+	CheckPayloadCompatibility[V2](tb, path)
+	if tb.failure != "" {
+		t.Fatalf("expected an added field to be compatible, got %s", tb.failure)
+	}
+	CheckPayloadCompatibility[V3](tb, path)
+	if !strings.Contains(tb.failure, "B changed from integer to string") {
+		t.Fatalf("expected the changed field to be reported, got %q", tb.failure)
+	}
+
+	t.Setenv(UPDATE_SCHEMAS_ENV, "1")
+	tb.failure = ""
+	CheckPayloadCompatibility[V3](tb, path)
+	if tb.failure != "" {
+		t.Fatalf("expected the snapshot to be updated, got %s", tb.failure)
+	}
+}
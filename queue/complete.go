@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Keep acked events for period before removing them, so a compensating action can still
+// return them to the queue with Unack. While kept, events are not delivered and don't
+// count towards Size. Purging happens as part of the queue's maintenance, so events may be
+// kept up to a claim timeout longer than period.
+func (q *Queue[T]) WithAckGracePeriod(period time.Duration) *Queue[T] {
+	q.ackGracePeriod = period
+	return q
+}
+
+// When an event acked now should be purged, zero without a grace period
+func (q *Queue[T]) purgeTime() time.Time {
+	if q.ackGracePeriod <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(q.ackGracePeriod)
+}
+
+// Ack the event with id: id but keep it until purgeAt, overriding the grace period
+// configured with WithAckGracePeriod for this event
+func (q *Queue[T]) CompleteAt(id int, purgeAt time.Time) error {
+	var ts transitions
+	q.lock.Lock()
+	err := q.completeAt(id, purgeAt, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to complete event: %d: %w", id, err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) completeAt(id int, purgeAt time.Time, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := q.ack(tx, id, purgeAt, ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+const COMPLETE_QUERY_TEMPLATE = `UPDATE queue_completed SET claimed = 0, claim_expires = NULL, completed_at = datetime('now', 'utc'), purge_at = ? WHERE id = ? RETURNING ` + ENVELOPE_COLUMNS
+
+// Move the event with id: id from table to the completed table to be kept until purgeAt, or
+// until it is released by releaseParent if purgeAt is zero. Reports whether the event was
+// in table
+func complete(tx *sql.Tx, table string, id int, purgeAt time.Time, ts *transitions) (bool, error) {
+	moved, err := moveEvents(tx, table, COMPLETED_TABLE, "id = ?", id)
+	if err != nil || len(moved) == 0 {
+		return false, err
+	}
+	var purge any
+	if !purgeAt.IsZero() {
+		purge = formatSqliteTime(purgeAt)
+	}
+	envelope, err := scanEnvelope(tx.QueryRow(COMPLETE_QUERY_TEMPLATE, purge, id), EVENT_STATE_COMPLETED)
+	if err != nil {
+		return false, err
+	}
+	ts.add(TRANSITION_ACKED, envelope)
+	return true, nil
+}
+
+const PURGE_CONDITION = `purge_at IS NOT NULL AND purge_at <= datetime('now', 'utc')`
+
+// Remove completed events whose grace period has passed, archiving them for queues
+// configured WithArchive. Callers must hold q.lock
+func (q *Queue[T]) purgeCompleted() error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if q.archive {
+		archived, err := moveEvents(tx, COMPLETED_TABLE, ARCHIVE_TABLE, PURGE_CONDITION)
+		if err != nil {
+			return fmt.Errorf("problem archiving completed events: %w", err)
+		}
+		if q.hashChain {
+			if err := chainArchived(tx, archived); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.Exec("DELETE FROM queue_tags WHERE event_id IN (SELECT id FROM queue_completed WHERE " + PURGE_CONDITION + ")"); err != nil {
+		return fmt.Errorf("problem purging tags of completed events: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM queue_completed WHERE " + PURGE_CONDITION)
+	if err != nil {
+		return fmt.Errorf("problem purging completed events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if purged, err := result.RowsAffected(); err == nil && purged > 0 {
+		q.logger().Info(fmt.Sprintf("Purged %d completed events after their grace period", purged))
+	}
+	return nil
+}
+
+const UNACK_QUERY = `UPDATE queue SET unacked = 1, claimed = 0, claim_expires = NULL WHERE id = ? RETURNING ` + ENVELOPE_COLUMNS
+
+// Return an acked event that is still within its grace period to the queue, e.g. because
+// a mistake was discovered after it was processed, without any manual row surgery. The
+// event keeps its id and retry count, is available to be de-queued straight away and is
+// flagged with Envelope.Unacked. Fails once the grace period has passed
+func (q *Queue[T]) Unack(id int) error {
+	var ts transitions
+	q.lock.Lock()
+	err := q.unack(id, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to unack event: %d: %w", id, err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) unack(id int, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	// Events past their purge time are only kept until maintenance next runs
+	moved, err := moveEvents(tx, COMPLETED_TABLE, PENDING_TABLE, "id = ? AND NOT ("+PURGE_CONDITION+")", id)
+	if err != nil {
+		return err
+	}
+	if len(moved) == 0 {
+		return fmt.Errorf("event is not within an ack grace period")
+	}
+	envelope, err := scanEnvelope(tx.QueryRow(UNACK_QUERY, id), EVENT_STATE_PENDING)
+	if err != nil {
+		return err
+	}
+	ts.add(TRANSITION_UNACKED, envelope)
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAckGracePeriod(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithAckGracePeriod(time.Hour)
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := q.Size(); err != nil || size != 0 {
+		t.Fatalf("expected an empty queue, got %d: %v", size, err)
+	}
+	peeked, err := q.Peek(event.Id)
+	if err != nil || peeked == nil || peeked.State != EVENT_STATE_COMPLETED {
+		t.Fatalf("expected a completed event, got %v: %v", peeked, err)
+	}
+
+	if err := q.Unack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	again, err := q.Next()
+	if err != nil || again == nil || again.Id != event.Id {
+		t.Fatalf("expected event %d again, got %v: %v", event.Id, again, err)
+	}
+	if !again.Envelope.Unacked || event.Envelope.Unacked {
+		t.Fatalf("expected only the reprocessing to be flagged as unacked")
+	}
+
+	// Purged by the next maintenance run once its grace period has passed
+	if err := q.CompleteAt(again.Id, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	q.lock.Lock()
+	err = q.purgeCompleted()
+	q.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peeked, err := q.Peek(event.Id); err != nil || peeked != nil {
+		t.Fatalf("expected the event to be purged, got %v: %v", peeked, err)
+	}
+	if err := q.Unack(event.Id); err == nil {
+		t.Fatal("expected unacking a purged event to fail")
+	}
+}
+
+func TestUnackFailsAfterGracePeriodBeforePurge(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithAckGracePeriod(time.Hour)
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.CompleteAt(event.Id, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// Maintenance hasn't purged the event yet
+	if peeked, err := q.Peek(event.Id); err != nil || peeked == nil {
+		t.Fatalf("expected the event to be kept until maintenance, got %v: %v", peeked, err)
+	}
+	if err := q.Unack(event.Id); err == nil {
+		t.Fatal("expected unacking an event past its grace period to fail")
+	}
+	if size, err := q.Size(); err != nil || size != 0 {
+		t.Fatalf("expected an empty queue, got %d: %v", size, err)
+	}
+}
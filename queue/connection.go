@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Where a queue's database lives
+type Backend string
+
+const (
+	// A database file on the local filesystem, see NewLocalQueue
+	BACKEND_LOCAL Backend = "local"
+	// A remote Turso or libsql server database, see NewTursoQueue
+	BACKEND_TURSO Backend = "turso"
+)
+
+// Where a queue's database lives, without any credentials, so it is safe to log
+type ConnectionInfo struct {
+	Backend Backend
+	// Host of a remote database, empty for local databases
+	Host string
+	// Path of a local database file
+	Path string
+	// Name of the database, the file name without extension for local databases and the
+	// first label of the host for remote ones
+	Database string
+}
+
+// The connection as a URL without credentials
+func (c ConnectionInfo) String() string {
+	if c.Backend == BACKEND_LOCAL {
+		return "file:" + c.Path
+	}
+	return "libsql://" + c.Host + c.Path
+}
+
+// Where the queue's database lives, with credentials such as the auth token left out
+func (q *Queue[T]) ConnectionInfo() ConnectionInfo {
+	return connectionInfo(q.location)
+}
+
+func connectionInfo(dsn string) ConnectionInfo {
+	if path, ok := strings.CutPrefix(dsn, "file:"); ok {
+		path, _, _ = strings.Cut(path, "?")
+		return ConnectionInfo{
+			Backend:  BACKEND_LOCAL,
+			Path:     path,
+			Database: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		}
+	}
+	info := ConnectionInfo{Backend: BACKEND_TURSO}
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		// Don't risk echoing anything that might be a credential
+		return info
+	}
+	info.Host = parsed.Hostname()
+	if parsed.Port() != "" {
+		info.Host += ":" + parsed.Port()
+	}
+	info.Path = parsed.Path
+	info.Database, _, _ = strings.Cut(parsed.Hostname(), ".")
+	return info
+}
+
+// The full connection string the queue was opened with, including credentials such as
+// the Turso auth token. Never log it, use ConnectionInfo instead
+func (q *Queue[T]) DSN() string {
+	return q.location
+}
@@ -0,0 +1,23 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectionInfo(t *testing.T) {
+	remote := connectionInfo("libsql://orders-acme.turso.io?authToken=secret&remoteEncryptionKey=key")
+	if remote != (ConnectionInfo{Backend: BACKEND_TURSO, Host: "orders-acme.turso.io", Database: "orders-acme"}) {
+		t.Fatalf("unexpected remote connection info %+v", remote)
+	}
+	if strings.Contains(remote.String(), "secret") || strings.Contains(remote.String(), "key") {
+		t.Fatalf("expected credentials to be scrubbed, got %s", remote)
+	}
+
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	local := q.ConnectionInfo()
+	if local.Backend != BACKEND_LOCAL || local.String() != q.DSN() || !strings.HasSuffix(local.Path, local.Database+".db") {
+		t.Fatalf("unexpected local connection info %+v for %s", local, q.DSN())
+	}
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How long ProcessFor waits before asking for more work when no event is currently available
+const processPollInterval = 500 * time.Millisecond
+
+// A Handler processes a single event. Returning nil acks the event, returning an
+// error nacks it so it will be retried after the configured backoff
+type Handler[T any] func(ctx context.Context, event *Event[T]) error
+
+// Middleware wraps a Handler, e.g. to log or time every event using event.Envelope
+type Middleware[T any] func(next Handler[T]) Handler[T]
+
+// Wrap every handler passed to DrainTo and ProcessFor with middleware. The first middleware
+// registered is the outermost one
+func (q *Queue[T]) WithMiddleware(middleware ...Middleware[T]) *Queue[T] {
+	q.middleware = append(q.middleware, middleware...)
+	return q
+}
+
+// The outcome of a call to DrainTo or ProcessFor
+type DrainSummary struct {
+	// Events the handler processed successfully, these have been acked
+	Processed int
+	// Events the handler returned an error for, these have been nacked
+	Failed int
+	// The subset of Failed events that have now exhausted their retries
+	DeadLettered int
+	// Events whose handler failed because the consumer was stopping, these have been
+	// released so another worker picks them up straight away without using a retry
+	Released int
+}
+
+// Synchronously process every event that is currently available in the queue with handler,
+// returning once Next() no longer yields events. Events that fail are nacked and will not
+// be seen again by this call unless their backoff elapses before the queue is drained.
+func (q *Queue[T]) DrainTo(handler Handler[T]) (DrainSummary, error) {
+	var summary DrainSummary
+	ctx := context.Background()
+	for {
+		event, err := q.Next()
+		if err != nil {
+			return summary, err
+		}
+		if event == nil {
+			return summary, nil
+		}
+		if err := q.handle(ctx, event, handler, &summary); err != nil {
+			return summary, fmt.Errorf("problem draining queue: %w", err)
+		}
+	}
+}
+
+// Consume events with handler for at most budget, intended for workers that are invoked
+// on a schedule (cron jobs, lambdas) rather than running forever. Returns early once the
+// queue holds no events at all. If the budget runs out after an event was claimed but
+// before the handler was started, the claim is released so another worker can pick it up
+// straight away. Running out of budget is not an error, cancellation of ctx is.
+func (q *Queue[T]) ProcessFor(ctx context.Context, budget time.Duration, handler Handler[T]) (DrainSummary, error) {
+	var summary DrainSummary
+	budgetCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	for {
+		if budgetCtx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		event, err := q.Next()
+		if err == ErrQueueDegraded {
+			// Wait for the queue to recover rather than giving up on the budget
+			select {
+			case <-budgetCtx.Done():
+			case <-time.After(processPollInterval):
+			}
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		if event == nil {
+			size, err := q.Size()
+			if err != nil {
+				return summary, err
+			}
+			if size == 0 {
+				return summary, nil
+			}
+			select {
+			case <-budgetCtx.Done():
+			case <-time.After(processPollInterval):
+			}
+			continue
+		}
+		if budgetCtx.Err() != nil {
+			if err := q.Release(event.Id); err != nil {
+				return summary, err
+			}
+			return summary, ctx.Err()
+		}
+		if err := q.handle(budgetCtx, event, handler, &summary); err != nil {
+			return summary, fmt.Errorf("problem processing queue: %w", err)
+		}
+	}
+}
+
+// Run handler for event, then ack or nack it depending on the outcome and record it in summary.
+// Failures after ctx was cancelled, e.g. because the worker is shutting down, are most likely
+// caused by the cancellation rather than the event, so the event is released instead
+func (q *Queue[T]) handle(ctx context.Context, event *Event[T], handler Handler[T], summary *DrainSummary) error {
+	ctx, endTask := q.startEventTask(ctx)
+	defer endTask()
+	for i := len(q.middleware) - 1; i >= 0; i-- {
+		handler = q.middleware[i](handler)
+	}
+	endRegion := q.startRegion(ctx, TRACE_REGION_HANDLER)
+	err := handler(ctx, event)
+	endRegion()
+	if err != nil && ctx.Err() != nil {
+		if err := q.Release(event.Id); err != nil {
+			return err
+		}
+		summary.Released++
+		return nil
+	}
+	if err != nil {
+		dead, err := q.nack(event.Id)
+		if err != nil {
+			return err
+		}
+		summary.Failed++
+		if dead {
+			summary.DeadLettered++
+		}
+		return nil
+	}
+	if err := q.Ack(event.Id); err != nil {
+		return err
+	}
+	summary.Processed++
+	return nil
+}
@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainTo(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithMaxRetires(0)
+
+	for _, a := range []string{"ok", "fail", "ok"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := q.DrainTo(func(ctx context.Context, event *Event[Test]) error {
+		if event.Content.A == "fail" {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Processed != 2 || summary.Failed != 1 || summary.DeadLettered != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if size, _ := q.Size(); size != 0 {
+		t.Fatalf("expected empty queue, got size %d", size)
+	}
+}
+
+func TestProcessFor(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	for _, a := range []string{"one", "two"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	summary, err := q.ProcessFor(context.Background(), 5*time.Second, func(ctx context.Context, event *Event[Test]) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Processed != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	// The queue is empty so ProcessFor should not have used its whole budget
+	if time.Since(start) >= 5*time.Second {
+		t.Fatal("ProcessFor did not return once the queue was empty")
+	}
+}
+
+func TestProcessForReleasesOnCancel(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker shuts down while the handler is running
+	ctx, cancel := context.WithCancel(context.Background())
+	summary, err := q.ProcessFor(ctx, 5*time.Second, func(ctx context.Context, event *Event[Test]) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if summary.Released != 1 || summary.Failed != 0 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	// Available straight away, without a retry used
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected the event to be available again, got %v: %v", event, err)
+	}
+	if event.Envelope.Retries != 0 {
+		t.Fatalf("expected no retry to be used, got %d", event.Envelope.Retries)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if err := q.Release(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	released, err := q.Next()
+	if err != nil || released == nil {
+		t.Fatal("released event was not available again")
+	}
+	if released.Id != event.Id {
+		t.Fatal()
+	}
+}
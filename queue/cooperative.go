@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How long ProcessCooperatively backs off when its gate is closed or nothing is available
+const cooperativeYieldInterval = time.Second
+
+// Decides whether a cooperative consumer may claim another event, given the number of events
+// pending or in flight. See ProcessCooperatively
+type YieldGate func(ctx context.Context, depth int) bool
+
+// Open while more than threshold events are pending or in flight, so opportunistic workers
+// only help out once the regular ones fall behind
+func GateDepthAbove(threshold int) YieldGate {
+	return func(_ context.Context, depth int) bool {
+		return depth > threshold
+	}
+}
+
+// Open while load reports less than max, e.g. the host's load average or CPU usage.
+// Measurement failures keep the gate closed
+func GateLoadBelow(max float64, load func() (float64, error)) YieldGate {
+	return func(context.Context, int) bool {
+		current, err := load()
+		return err == nil && current < max
+	}
+}
+
+// Open while any of gates is open
+func GateAny(gates ...YieldGate) YieldGate {
+	return func(ctx context.Context, depth int) bool {
+		for _, gate := range gates {
+			if gate(ctx, depth) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Consume events with handler until ctx is cancelled, but only claim an event while gate is
+// open, backing off otherwise. Intended for low-priority background workers sharing hosts
+// with latency-sensitive services: they yield to the regular consumers and to the host's
+// other work, e.g. with GateAny(GateDepthAbove(1000), GateLoadBelow(0.5, loadAverage)).
+// Returns ctx.Err() once ctx is cancelled
+func (q *Queue[T]) ProcessCooperatively(ctx context.Context, gate YieldGate, handler Handler[T]) (DrainSummary, error) {
+	var summary DrainSummary
+	yield := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(cooperativeYieldInterval):
+		}
+	}
+	for {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		depth, err := q.Size()
+		if err != nil {
+			return summary, err
+		}
+		if depth == 0 || !gate(ctx, depth) {
+			yield()
+			continue
+		}
+		event, err := q.Next()
+		if err == ErrQueueDegraded {
+			yield()
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		if event == nil {
+			yield()
+			continue
+		}
+		if err := q.handle(ctx, event, handler, &summary); err != nil {
+			return summary, fmt.Errorf("problem processing queue cooperatively: %w", err)
+		}
+	}
+}
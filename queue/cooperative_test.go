@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessCooperatively(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only helps out while more than one event is waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	summary, err := q.ProcessCooperatively(ctx, GateDepthAbove(1), func(context.Context, *Event[Test]) error {
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to run until the context is done, got %v", err)
+	}
+	if summary.Processed != 2 {
+		t.Fatalf("expected 2 events processed before yielding to other consumers, got %+v", summary)
+	}
+
+	busy := GateLoadBelow(0.5, func() (float64, error) { return 0.9, nil })
+	if busy(context.Background(), 100) {
+		t.Fatal("expected the load gate to be closed on a busy host")
+	}
+	if !GateAny(busy, GateDepthAbove(10))(context.Background(), 100) {
+		t.Fatal("expected GateAny to be open while one of its gates is")
+	}
+}
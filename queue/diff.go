@@ -0,0 +1,245 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// A point in a queue's history that Diff can compare against. The zero mark is the
+// beginning, so the first diff is a full copy
+type DiffMark struct {
+	// Highest id the queue had handed out
+	LastID int
+	// Database time the mark was taken, with second precision
+	At time.Time
+}
+
+// An inclusive range of event ids
+type IDRange struct {
+	First int
+	Last  int
+}
+
+// An event that was dead lettered
+type DeadEvent struct {
+	Id     int
+	Reason string
+}
+
+// The changes to a queue between two marks, see Diff and ApplyDiff. Events are identified by
+// id, so a diff can only be applied to a copy of the queue it was taken from
+type QueueDiff struct {
+	Since DiffMark
+	// Pass as since to the next call to Diff
+	Mark DiffMark
+	// Events inserted since Since that are still pending, in flight or dead
+	New []Envelope
+	// The ids up to Since.LastID that are still pending, in flight or dead. The rest were
+	// acked since, or before. Ranges keep this small as queues are consumed roughly in order
+	Live []IDRange
+	// Events up to Since.LastID that were dead lettered since Since
+	Dead []DeadEvent
+}
+
+const DIFF_MARK_QUERY = `SELECT IFNULL((SELECT MAX(seq) FROM sqlite_sequence WHERE name = 'queue'), 0), CAST(strftime('%s', 'now') AS INTEGER)`
+
+const DIFF_LIVE_IDS_QUERY = `
+SELECT id FROM queue WHERE id <= ?1
+UNION ALL SELECT id FROM queue_inflight WHERE id <= ?1
+UNION ALL SELECT id FROM queue_dead WHERE id <= ?1
+ORDER BY id`
+
+const DIFF_DEAD_QUERY = `SELECT id, IFNULL(reason, '') FROM queue_dead WHERE id <= ? AND CAST(strftime('%s', dead_at) AS INTEGER) >= ? ORDER BY id`
+
+const APPLY_DIFF_EVENT_QUERY = `UPDATE queue SET retries = ?, enqueued_at = IFNULL(?, enqueued_at) WHERE id = ?`
+
+// Describe how the queue changed since since, compactly enough to replicate the queue to a
+// warm standby periodically instead of copying it, see ApplyDiff. Completed and archived
+// events count as acked
+func (q *Queue[T]) Diff(since DiffMark) (*QueueDiff, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	// A read transaction sees one consistent state of the database
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	diff := &QueueDiff{Since: since}
+	var at int64
+	if err := tx.QueryRow(DIFF_MARK_QUERY).Scan(&diff.Mark.LastID, &at); err != nil {
+		return nil, fmt.Errorf("problem marking queue state: %w", err)
+	}
+	diff.Mark.At = time.Unix(at, 0).UTC()
+	for _, table := range EVENT_TABLES {
+		reasonColumn := "NULL"
+		if table == DEAD_TABLE {
+			reasonColumn = "reason"
+		}
+		listed, err := listTable(tx, fmt.Sprintf(LIST_QUERY_TEMPLATE, reasonColumn, table, "WHERE id > ? AND id <= ?"), TABLE_STATES[table], since.LastID, diff.Mark.LastID)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading new %s events: %w", TABLE_STATES[table], err)
+		}
+		diff.New = append(diff.New, listed...)
+	}
+	slices.SortFunc(diff.New, func(a, b Envelope) int { return a.Id - b.Id })
+	if diff.Live, err = liveRanges(tx, since.LastID); err != nil {
+		return nil, fmt.Errorf("problem reading live events: %w", err)
+	}
+	if diff.Dead, err = deadSince(tx, since); err != nil {
+		return nil, fmt.Errorf("problem reading dead events: %w", err)
+	}
+	return diff, nil
+}
+
+func liveRanges(tx *sql.Tx, lastID int) ([]IDRange, error) {
+	rows, err := tx.Query(DIFF_LIVE_IDS_QUERY, lastID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	ranges := []IDRange{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].Last+1 == id {
+			ranges[n-1].Last = id
+		} else {
+			ranges = append(ranges, IDRange{id, id})
+		}
+	}
+	return ranges, rows.Err()
+}
+
+func deadSince(tx *sql.Tx, since DiffMark) ([]DeadEvent, error) {
+	if since.LastID == 0 {
+		return []DeadEvent{}, nil
+	}
+	rows, err := tx.Query(DIFF_DEAD_QUERY, since.LastID, since.At.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	dead := []DeadEvent{}
+	for rows.Next() {
+		var event DeadEvent
+		if err := rows.Scan(&event.Id, &event.Reason); err != nil {
+			return nil, err
+		}
+		dead = append(dead, event)
+	}
+	return dead, rows.Err()
+}
+
+// Bring a standby copy of a queue up to date with a diff taken from the primary. Diffs must
+// be applied in order, starting with the one taken from the zero mark. Events in flight on
+// the primary are pending on the standby, so nothing is lost if the standby is promoted
+// while the primary's workers were processing them
+func (q *Queue[T]) ApplyDiff(diff *QueueDiff) error {
+	var ts transitions
+	q.lock.Lock()
+	err := q.applyDiff(diff, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("problem applying diff: %w", err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) applyDiff(diff *QueueDiff, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+
+	// Acked since the last diff: whatever is no longer live on the primary
+	acked, err := ackedIDs(tx, diff.Since.LastID, diff.Live)
+	if err != nil {
+		return err
+	}
+	if len(acked) > 0 {
+		placeholders, args := inClause(acked)
+		for _, table := range EVENT_TABLES {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", table, placeholders), args...); err != nil {
+				return fmt.Errorf("problem removing acked events: %w", err)
+			}
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM queue_tags WHERE event_id IN (%s)", placeholders), args...); err != nil {
+			return fmt.Errorf("problem removing tags of acked events: %w", err)
+		}
+	}
+
+	dead := slices.Clone(diff.Dead)
+	for _, envelope := range diff.New {
+		if err := q.insertEncoded(tx, envelope, ts); err != nil {
+			return fmt.Errorf("problem inserting event %d: %w", envelope.Id, err)
+		}
+		var enqueuedAt any
+		if !envelope.EnqueuedAt.IsZero() {
+			enqueuedAt = formatSqliteTime(envelope.EnqueuedAt)
+		}
+		if _, err := tx.Exec(APPLY_DIFF_EVENT_QUERY, envelope.Retries, enqueuedAt, envelope.Id); err != nil {
+			return fmt.Errorf("problem inserting event %d: %w", envelope.Id, err)
+		}
+		if envelope.State == EVENT_STATE_DEAD {
+			dead = append(dead, DeadEvent{envelope.Id, envelope.DeadReason})
+		}
+	}
+	for _, event := range dead {
+		moved, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, "id = ?", event.Id)
+		if err != nil {
+			return fmt.Errorf("problem dead lettering event %d: %w", event.Id, err)
+		}
+		if err := markDead(tx, moved, event.Reason, ts); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+// The ids up to lastID the standby has that aren't in live
+func ackedIDs(tx *sql.Tx, lastID int, live []IDRange) ([]int, error) {
+	rows, err := tx.Query(DIFF_LIVE_IDS_QUERY, lastID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	acked := []int{}
+	next := 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		// Both are ordered by id
+		for next < len(live) && live[next].Last < id {
+			next++
+		}
+		if next == len(live) || id < live[next].First {
+			acked = append(acked, id)
+		}
+	}
+	return acked, rows.Err()
+}
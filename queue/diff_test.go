@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	type Test struct{ A string }
+	primary := newTestQueue[Test](t).WithMaxRetires(0)
+	standby := newTestQueue[Test](t)
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := primary.Insert(Test{A: a}, WithTags("sync")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err := primary.Diff(DiffMark{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.New) != 3 || diff.Mark.LastID != 3 {
+		t.Fatalf("expected a full copy up to id 3, got %d events up to %d", len(diff.New), diff.Mark.LastID)
+	}
+	if err := standby.ApplyDiff(diff); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := standby.Size(); size != 3 {
+		t.Fatalf("expected 3 events on the standby, got %d", size)
+	}
+
+	// Ack one, dead letter one and insert one, leaving one in flight
+	for i := range 3 {
+		event, err := primary.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch i {
+		case 0:
+			err = primary.Ack(event.Id)
+		case 1:
+			err = primary.Nack(event.Id)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	window := DeliveryWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.FixedZone("", -5*60*60)}
+	if err := primary.Insert(Test{A: "four"}, WithEventDeliveryWindow(window)); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err = primary.Diff(diff.Mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.New) != 1 || len(diff.Dead) != 1 || len(diff.Live) != 1 || diff.Live[0] != (IDRange{2, 3}) {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	// Diffs are meant to be shipped elsewhere
+	data, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shipped QueueDiff
+	if err := json.Unmarshal(data, &shipped); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.ApplyDiff(&shipped); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked, err := standby.Peek(1); err != nil || acked != nil {
+		t.Fatal("expected the acked event to be removed from the standby")
+	}
+	dead, err := standby.Peek(2)
+	if err != nil || dead.State != EVENT_STATE_DEAD || dead.DeadReason != DEAD_REASON_MAX_RETRIES {
+		t.Fatalf("expected event 2 to be dead on the standby, got %+v: %v", dead, err)
+	}
+	// In flight on the primary, pending on the standby
+	for _, id := range []int{3, 4} {
+		envelope, err := standby.Peek(id)
+		if err != nil || envelope.State != EVENT_STATE_PENDING {
+			t.Fatalf("expected event %d to be pending on the standby, got %+v: %v", id, envelope, err)
+		}
+	}
+	envelope, _ := standby.Peek(3)
+	if len(envelope.Tags) != 1 || envelope.Tags[0] != "sync" {
+		t.Fatalf("expected tags to be replicated, got %v", envelope.Tags)
+	}
+	var replicated [3]any
+	err = standby.db.QueryRow("SELECT window_start, window_end, window_offset FROM queue WHERE id = 4").Scan(&replicated[0], &replicated[1], &replicated[2])
+	if expected := window.columns(time.Now()); err != nil || fmt.Sprint(replicated) != fmt.Sprint(expected) {
+		t.Fatalf("expected the delivery window %v to be replicated, got %v: %v", expected, replicated, err)
+	}
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock drift beyond which a warning is logged, unless configured with WithOnClockDrift
+const defaultClockDriftThreshold = 2 * time.Second
+
+// How often maintenance measures clock drift
+const clockDriftCheckInterval = time.Minute
+
+const DATABASE_TIME_QUERY = `SELECT CAST(unixepoch('subsec') * 1000 AS INTEGER)`
+
+// Register fn to be called whenever the clock of this process and the database's clock are
+// found to be more than threshold apart. Drift is measured when the queue is opened and then
+// about once a minute during maintenance. Claim expiry and backoff are computed by the
+// database, but anything comparing them with time.Now(), like consumers timing their work
+// against the claim timeout, is off by the drift
+func (q *Queue[T]) WithOnClockDrift(threshold time.Duration, fn func(drift time.Duration)) *Queue[T] {
+	q.drift.lock.Lock()
+	defer q.drift.lock.Unlock()
+	q.drift.threshold = threshold
+	q.drift.onDrift = fn
+	return q
+}
+
+// Measure how far the database's clock is ahead of this process's clock, negative if it is
+// behind. Precise to about half the round trip to the database
+func (q *Queue[T]) ClockDrift() (time.Duration, error) {
+	drift, err := measureClockDrift(q.db)
+	if err != nil {
+		return 0, fmt.Errorf("problem measuring clock drift: %w", err)
+	}
+	return drift, nil
+}
+
+func measureClockDrift(db *sql.DB) (time.Duration, error) {
+	before := time.Now()
+	var millis int64
+	if err := db.QueryRow(DATABASE_TIME_QUERY).Scan(&millis); err != nil {
+		return 0, err
+	}
+	after := time.Now()
+	local := before.Add(after.Sub(before) / 2)
+	return time.UnixMilli(millis).Sub(local).Round(time.Millisecond), nil
+}
+
+type clockDrift struct {
+	lock      sync.Mutex
+	threshold time.Duration
+	onDrift   func(drift time.Duration)
+	lastCheck time.Time
+}
+
+// Measure clock drift if it hasn't been measured for clockDriftCheckInterval, then log and
+// report it if it is over the threshold. Must not be called with q.lock held
+func (q *Queue[T]) checkClockDrift() {
+	d := &q.drift
+	d.lock.Lock()
+	if time.Since(d.lastCheck) < clockDriftCheckInterval {
+		d.lock.Unlock()
+		return
+	}
+	d.lastCheck = time.Now()
+	threshold, hook := d.threshold, d.onDrift
+	d.lock.Unlock()
+
+	drift, err := measureClockDrift(q.db)
+	if err != nil {
+		q.logger().Error(fmt.Sprintf("problem measuring clock drift: %s", err))
+		return
+	}
+	if drift.Abs() <= threshold {
+		return
+	}
+	q.logger().Warn(fmt.Sprintf("Database clock is %s ahead of this process's clock, claim timeouts and backoff will appear off by as much", drift))
+	if hook != nil {
+		hook(drift)
+	}
+}
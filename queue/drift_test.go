@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockDrift(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	drift, err := q.ClockDrift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift.Abs() > time.Second {
+		t.Fatalf("expected a local database to share our clock, got a drift of %s", drift)
+	}
+
+	reported := []time.Duration{}
+	q = q.WithOnClockDrift(-time.Nanosecond, func(drift time.Duration) {
+		reported = append(reported, drift)
+	})
+	// Already measured when the queue was opened
+	q.checkClockDrift()
+	if len(reported) != 0 {
+		t.Fatalf("expected drift to be measured at most once a minute, got %v", reported)
+	}
+	q.drift.lastCheck = time.Time{}
+	q.checkClockDrift()
+	if len(reported) != 1 {
+		t.Fatalf("expected drift over the threshold to be reported, got %v", reported)
+	}
+}
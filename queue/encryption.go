@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Encrypt the local database file with key, so the queue's contents are protected at rest on
+// laptops and edge devices. The same key must be passed every time the queue is opened, a
+// wrong key fails with "file is not a database". Existing unencrypted queues are not
+// encrypted in place, use MigrateTo to move their events into an encrypted queue. Only
+// supported for local queues, Turso queues are encrypted with TURSO_REMOTE_ENCRYPTION_KEY
+func WithEncryptionKey(key string) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// Sets the encryption key on every connection it opens, the key is not persisted by SQLite
+type keyedConnector struct {
+	driver.Connector
+	key string
+}
+
+func (c keyedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		_ = conn.Close()
+		return nil, fmt.Errorf("libsql connection does not support queries")
+	}
+	// PRAGMA key returns a row, which rules out ExecContext
+	rows, err := queryer.QueryContext(ctx, fmt.Sprintf("PRAGMA key = '%s'", strings.ReplaceAll(c.key, "'", "''")), nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("problem setting encryption key: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("problem setting encryption key: %w", err)
+	}
+	return conn, nil
+}
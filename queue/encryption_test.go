@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionKey(t *testing.T) {
+	type Test struct{ A string }
+	// Without background maintenance, which would hold the database while it is reopened
+	q := newTestQueue[Test](t, WithEncryptionKey("it's a secret"), WithSynchronousMaintenance())
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := strings.TrimPrefix(q.DSN(), "file:")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(data, []byte("SQLite format 3")) || bytes.Contains(data, []byte("hello")) {
+		t.Fatal("expected the database file to be encrypted")
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(path, ".db/"), ".db")
+	if _, err := NewLocalQueue[Test](name, WithEncryptionKey("wrong")); err == nil {
+		t.Fatal("expected opening with the wrong key to fail")
+	}
+	if _, err := NewLocalQueue[Test](name); err == nil {
+		t.Fatal("expected opening without a key to fail")
+	}
+	reopened, err := NewLocalQueue[Test](name, WithEncryptionKey("it's a secret"), WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = reopened.db.Close()
+	})
+	event, err := reopened.Next()
+	if err != nil || event == nil || event.Content.A != "hello" {
+		t.Fatalf("expected the event to be readable with the right key, got %v: %v", event, err)
+	}
+}
@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// The stored form of an event, independent of its payload type. Envelopes are what
+// transition hooks, middleware and the admin API (Peek, List) work with, so extensions can
+// be written once and used with queues of any payload type.
+type Envelope struct {
+	Id int
+	// Application defined type of the event, set with WithKind
+	Kind string
+	// Free form metadata, set with WithHeaders
+	Headers map[string]string
+	// Id of the batch the event was inserted with by InsertBatch
+	Batch string
+	// Id of the event that spawned this one with SpawnChildren, 0 if there is none
+	Parent int
+	// When delivering the event becomes pointless, set with WithExpiresAt. Zero if it never expires
+	ExpiresAt time.Time
+	// When the event may be delivered, set with WithEventDeliveryWindow. Read back in the
+	// fixed time zone offset it was stored with
+	DeliveryWindow *DeliveryWindow
+	// Indexed labels, set with WithTags, sorted
+	Tags []string
+	// Resource tokens processing the event needs, set with WithResourceTokens
+	Resources []string
+	// Retries allowed before the event is dead lettered, set with WithEventMaxRetries. nil
+	// if the queue's limit applies
+	MaxRetries *int
+	// How long claims made with Next last, set with WithEventClaimTimeout. 0 if the queue's
+	// claim timeout applies
+	ClaimTimeout time.Duration
+	// How long the event is kept once acked, set with WithEventRetention. 0 if the queue's
+	// grace period applies
+	Retention time.Duration
+	// Version of the payload's schema, set with WithSchemaVersion
+	SchemaVersion int
+	// The payload as encoded by the queue's codec
+	Payload    []byte
+	EnqueuedAt time.Time
+	// How many times the event has been nacked
+	Retries int
+	// One of EVENT_STATE_PENDING, EVENT_STATE_INFLIGHT, EVENT_STATE_DEAD or EVENT_STATE_COMPLETED
+	State string
+	// Why the event will not be delivered again, only set for dead events
+	DeadReason string
+	// Whether the event was acked before and returned to the queue by Unack, so handlers
+	// can tell a reprocessing apart from a retry
+	Unacked bool
+	// Every time the event was claimed, oldest first
+	Attempts []Attempt
+	// Worker id of the process holding the event's claim, see WithWorkerID
+	ClaimedBy string
+	// Static labels of the queue the event was read from, see WithLabels. Shared between
+	// envelopes, don't modify
+	QueueLabels map[string]string
+	// When the read snapshot the envelope was served from was taken, zero unless the
+	// database was unreachable, see WithReadSnapshot
+	StaleAt time.Time
+}
+
+const (
+	EVENT_STATE_PENDING  = "pending"
+	EVENT_STATE_INFLIGHT = "inflight"
+	EVENT_STATE_DEAD     = "dead"
+	// Acked, but kept until its grace period has passed, see WithAckGracePeriod
+	EVENT_STATE_COMPLETED = "completed"
+)
+
+// The state of events stored in each event table
+var TABLE_STATES = map[string]string{
+	PENDING_TABLE:   EVENT_STATE_PENDING,
+	INFLIGHT_TABLE:  EVENT_STATE_INFLIGHT,
+	DEAD_TABLE:      EVENT_STATE_DEAD,
+	COMPLETED_TABLE: EVENT_STATE_COMPLETED,
+}
+
+// The columns scanned by scanEnvelope, in order
+const ENVELOPE_COLUMNS = "id, kind, headers, schema_version, payload, enqueued_at, retries, unacked, batch_id, parent_id, expires_at, claimed_by, attempts, resources, window_start, window_end, window_offset, max_retries, claim_timeout, retention, " + TAGS_COLUMN
+
+// Sets envelope fields of an event as it is inserted
+type InsertOption func(*Envelope)
+
+// Tag the event with an application defined kind, e.g. "send_email"
+func WithKind(kind string) InsertOption {
+	return func(e *Envelope) {
+		e.Kind = kind
+	}
+}
+
+// Attach headers to the event, merged with any set by earlier options
+func WithHeaders(headers map[string]string) InsertOption {
+	return func(e *Envelope) {
+		if e.Headers == nil {
+			e.Headers = map[string]string{}
+		}
+		maps.Copy(e.Headers, headers)
+	}
+}
+
+// Record the version of the payload's schema the event was produced with
+func WithSchemaVersion(version int) InsertOption {
+	return func(e *Envelope) {
+		e.SchemaVersion = version
+	}
+}
+
+// Satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan the ENVELOPE_COLUMNS of a row of one of the event tables, followed by extra columns
+func scanEnvelope(row scanner, state string, extra ...any) (Envelope, error) {
+	var (
+		envelope   Envelope
+		kind       sql.NullString
+		headers    sql.NullString
+		version    sql.NullInt64
+		payload    sql.NullString
+		enqueuedAt sql.NullTime
+		retries    sql.NullInt64
+		unacked    sql.NullBool
+		batch      sql.NullString
+		parent     sql.NullInt64
+		expiresAt  sql.NullFloat64
+		claimedBy  sql.NullString
+		attempts   sql.NullString
+		resources  sql.NullString
+		window     [3]sql.NullInt64
+		maxRetries sql.NullInt64
+		claim      sql.NullFloat64
+		retention  sql.NullFloat64
+		tags       sql.NullString
+	)
+	// Everything but the id may have been left NULL by other tools writing to the tables
+	dest := append([]any{&envelope.Id, &kind, &headers, &version, &payload, &enqueuedAt, &retries, &unacked, &batch, &parent, &expiresAt, &claimedBy, &attempts, &resources, &window[0], &window[1], &window[2], &maxRetries, &claim, &retention, &tags}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return envelope, err
+	}
+	envelope.Kind = kind.String
+	envelope.SchemaVersion = int(version.Int64)
+	envelope.Payload = []byte(payload.String)
+	envelope.EnqueuedAt = enqueuedAt.Time
+	envelope.Retries = int(retries.Int64)
+	envelope.Unacked = unacked.Bool
+	envelope.Batch = batch.String
+	envelope.Parent = int(parent.Int64)
+	envelope.ExpiresAt = unixSeconds(expiresAt.Float64)
+	envelope.ClaimedBy = claimedBy.String
+	envelope.DeliveryWindow = storedDeliveryWindow(window[0], window[1], window[2])
+	if maxRetries.Valid {
+		limit := int(maxRetries.Int64)
+		envelope.MaxRetries = &limit
+	}
+	envelope.ClaimTimeout = storedSeconds(claim)
+	envelope.Retention = storedSeconds(retention)
+	if envelope.Attempts, err = decodeAttempts(attempts); err != nil {
+		return envelope, fmt.Errorf("problem decoding attempts of event %d: %w", envelope.Id, err)
+	}
+	if envelope.Resources, err = decodeResources(resources); err != nil {
+		return envelope, fmt.Errorf("problem decoding resource tokens of event %d: %w", envelope.Id, err)
+	}
+	envelope.State = state
+	if envelope.Tags, err = decodeTags(tags); err != nil {
+		return envelope, fmt.Errorf("problem decoding tags of event %d: %w", envelope.Id, err)
+	}
+	if headers.Valid && headers.String != "" {
+		if err := json.Unmarshal([]byte(headers.String), &envelope.Headers); err != nil {
+			return envelope, fmt.Errorf("problem decoding headers of event %d: %w", envelope.Id, err)
+		}
+	}
+	return envelope, nil
+}
+
+// Headers as they are stored in the database
+func encodeHeaders(headers map[string]string) (any, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding headers: %w", err)
+	}
+	return string(data), nil
+}
+
+// An event's envelope as it will be inserted
+func newEnvelope(payload []byte, opts ...InsertOption) Envelope {
+	envelope := Envelope{Payload: payload, State: EVENT_STATE_PENDING}
+	for _, opt := range opts {
+		opt(&envelope)
+	}
+	return envelope
+}
+
+// Store empty strings as NULL so optional columns stay unset
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Store zeros as NULL so optional columns stay unset
+func nullInt(i int) any {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// Run a query returning ENVELOPE_COLUMNS and scan every row into an envelope
+func queryEnvelopes(tx *sql.Tx, query string, state string, args ...any) ([]Envelope, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	envelopes := []Envelope{}
+	for rows.Next() {
+		envelope, err := scanEnvelope(rows, state)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, rows.Err()
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithMaxRetires(0)
+	var seen []Transition
+	q.WithOnTransition(func(transition Transition, envelope Envelope) {
+		if envelope.Kind != "send_email" {
+			t.Fatalf("expected kind send_email in %s transition, got %q", transition, envelope.Kind)
+		}
+		seen = append(seen, transition)
+	})
+	headers := map[string]string{"trace_id": "abc"}
+	if err := q.Insert(Test{A: "hi"}, WithKind("send_email"), WithHeaders(headers), WithSchemaVersion(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	envelope := event.Envelope
+	if envelope.Id != event.Id || envelope.Kind != "send_email" || envelope.SchemaVersion != 2 || envelope.State != EVENT_STATE_INFLIGHT {
+		t.Fatalf("unexpected envelope %+v", envelope)
+	}
+	if !reflect.DeepEqual(envelope.Headers, headers) {
+		t.Fatalf("expected headers %v, got %v", headers, envelope.Headers)
+	}
+
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	peeked, err := q.Peek(event.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peeked == nil || peeked.State != EVENT_STATE_DEAD || peeked.DeadReason != DEAD_REASON_MAX_RETRIES || peeked.Retries != 1 {
+		t.Fatalf("expected dead event, got %+v", peeked)
+	}
+
+	expected := []Transition{TRANSITION_INSERTED, TRANSITION_CLAIMED, TRANSITION_DEAD_LETTERED}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, seen)
+	}
+	if missing, err := q.Peek(event.Id + 1); err != nil || missing != nil {
+		t.Fatalf("expected no event, got %v: %v", missing, err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	var order []string
+	record := func(name string) Middleware[Test] {
+		return func(next Handler[Test]) Handler[Test] {
+			return func(ctx context.Context, event *Event[Test]) error {
+				order = append(order, name+":"+event.Envelope.Kind)
+				return next(ctx, event)
+			}
+		}
+	}
+	q.WithMiddleware(record("outer"), record("inner"))
+	if err := q.Insert(Test{A: "hi"}, WithKind("greeting")); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := q.DrainTo(func(ctx context.Context, event *Event[Test]) error {
+		order = append(order, "handler")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Processed != 1 {
+		t.Fatalf("expected 1 processed event, got %+v", summary)
+	}
+	expected := []string{"outer:greeting", "inner:greeting", "handler"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+}
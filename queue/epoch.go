@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+)
+
+// Claims held under a worker id, bound to the first argument, by an instance other than the
+// one bound to the second
+const PREVIOUS_EPOCH_CONDITION = `claimed_by = ? AND IFNULL(claimed_epoch, '') != ?`
+
+// Random id for the instance of the queue being opened
+func newEpoch() string {
+	return rand.Text()
+}
+
+// Identifies this instance of the queue, i.e. this process's handle on it. Every claim
+// records the epoch it was made in, so when a process configured WithWorkerID starts
+// consuming, the claims a previous, crashed process with the same worker id left behind are
+// released straight away instead of when they time out. Worker ids must therefore be
+// unique among the processes running at the same time
+func (q *Queue[T]) Epoch() string {
+	return q.epoch
+}
+
+// Release the claims held under q.workerID by previous epochs. Callers must hold q.lock
+func (q *Queue[T]) releasePreviousEpochs(tx *sql.Tx, ts *transitions) error {
+	released, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, PREVIOUS_EPOCH_CONDITION, q.workerID, q.epoch)
+	if err != nil {
+		return fmt.Errorf("problem releasing claims of previous epochs: %w", err)
+	}
+	if err := releaseClaims(tx, released, ATTEMPT_RELEASED, ts); err != nil {
+		return fmt.Errorf("problem releasing claims of previous epochs: %w", err)
+	}
+	for _, id := range released {
+		q.logger().Info(fmt.Sprintf("Released event claimed by a previous epoch of worker %s: %d", q.workerID, id))
+	}
+	return nil
+}
+
+// Release the claims of previous epochs in a transaction of their own the first time q
+// claims under its worker id, so they stay released when nothing is claimed. Callers must
+// hold q.lock
+func (q *Queue[T]) releasePreviousEpochsOnce(ts *transitions) error {
+	if q.workerID == "" || q.epochsReleasedFor == q.workerID {
+		return nil
+	}
+	recorded := len(*ts)
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := q.releasePreviousEpochs(tx, ts); err != nil {
+		*ts = (*ts)[:recorded]
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		*ts = (*ts)[:recorded]
+		return fmt.Errorf("problem releasing claims of previous epochs: %w", err)
+	}
+	q.epochsReleasedFor = q.workerID
+	return nil
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviousEpochClaimsAreReleased(t *testing.T) {
+	type Test struct{ A string }
+	// Without background maintenance, which would hold the database while it is reopened
+	crashed := newTestQueue[Test](t, WithSynchronousMaintenance()).WithWorkerID("worker-1")
+	if err := crashed.Insert(Test{A: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := crashed.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(crashed.DSN(), "file:.db/"), ".db")
+	other, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Epoch() == crashed.Epoch() {
+		t.Fatal("expected every instance to get its own epoch")
+	}
+	// Another worker id leaves the claim alone
+	if next, err := other.WithWorkerID("worker-2").Next(); err != nil || next != nil {
+		t.Fatalf("expected nothing to be available, got %v: %v", next, err)
+	}
+
+	restarted, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := restarted.WithWorkerID("worker-1").Next()
+	if err != nil || next == nil || next.Id != event.Id {
+		t.Fatalf("expected the crashed epoch's event to be released, got %v: %v", next, err)
+	}
+	last := next.Envelope.Attempts[len(next.Envelope.Attempts)-1]
+	if len(next.Envelope.Attempts) != 2 || next.Envelope.Attempts[0].Outcome != ATTEMPT_RELEASED || !last.EndedAt.IsZero() {
+		t.Fatalf("expected the first attempt to be released, got %+v", next.Envelope.Attempts)
+	}
+}
+
+func TestPreviousEpochClaimsAreReleasedOutsideTheDeliveryWindow(t *testing.T) {
+	type Test struct{ A string }
+	crashed := newTestQueue[Test](t, WithSynchronousMaintenance()).WithWorkerID("worker-1")
+	if err := crashed.Insert(Test{A: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := crashed.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(crashed.DSN(), "file:.db/"), ".db")
+	restarted, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.WithWorkerID("worker-1").WithDeliveryWindow(windowAroundNow(time.UTC, 2*time.Hour, 3*time.Hour))
+	if next, err := restarted.Next(); err != nil || next != nil {
+		t.Fatalf("expected nothing to be delivered outside the window, got %v: %v", next, err)
+	}
+	peeked, err := restarted.Peek(event.Id)
+	if err != nil || peeked == nil || peeked.State != EVENT_STATE_PENDING {
+		t.Fatalf("expected the crashed epoch's claim to be released, got %+v: %v", peeked, err)
+	}
+}
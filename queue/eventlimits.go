@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The retries a pending event has left, with the limit bound to ? applying unless the event
+// has its own, see WithEventMaxRetries
+const RETRY_LIMIT_EXPRESSION = `IFNULL(max_retries, ?)`
+
+// The event's own retention if it has one, see WithEventRetention
+const EVENT_RETENTION_QUERY = `
+SELECT retention FROM queue_inflight WHERE id = ?1 AND retention IS NOT NULL
+UNION ALL SELECT retention FROM queue WHERE id = ?1 AND retention IS NOT NULL`
+
+// Dead letter the event after it failed more than retries times instead of after the
+// queue's limit, see WithMaxRetires. Negative retries are clamped to 0
+func WithEventMaxRetries(retries int) InsertOption {
+	return func(e *Envelope) {
+		retries := max(retries, 0)
+		e.MaxRetries = &retries
+	}
+}
+
+// Claim the event for timeout instead of the queue's claim timeout when it is claimed with
+// Next or by a consumer, e.g. for kinds that take much longer to process than the rest.
+// Claims made with an explicit ttl, see Lease, are left alone. Expired claims are still
+// only reclaimed when maintenance runs, once per queue claim timeout
+func WithEventClaimTimeout(timeout time.Duration) InsertOption {
+	return func(e *Envelope) {
+		e.ClaimTimeout = max(timeout, 0)
+	}
+}
+
+// Keep the event for period once it was acked instead of the queue's grace period, see
+// WithAckGracePeriod, so it can still be inspected or returned with Unack
+func WithEventRetention(period time.Duration) InsertOption {
+	return func(e *Envelope) {
+		e.Retention = max(period, 0)
+	}
+}
+
+// The retries the event is allowed, queueLimit unless it has its own
+func (e Envelope) retryLimit(queueLimit int) int {
+	if e.MaxRetries == nil {
+		return queueLimit
+	}
+	return *e.MaxRetries
+}
+
+// Store a zero duration as NULL, anything else as fractional seconds
+func nullSeconds(d time.Duration) any {
+	if d <= 0 {
+		return nil
+	}
+	return d.Seconds()
+}
+
+// Store a nil limit as NULL
+func nullLimit(limit *int) any {
+	if limit == nil {
+		return nil
+	}
+	return *limit
+}
+
+// A duration stored by nullSeconds
+func storedSeconds(seconds sql.NullFloat64) time.Duration {
+	return time.Duration(seconds.Float64 * float64(time.Second))
+}
+
+// The retention of the pending or in-flight event with id, 0 if it has none of its own
+func eventRetention(tx *sql.Tx, id int) (time.Duration, error) {
+	var retention sql.NullFloat64
+	err := tx.QueryRow(EVENT_RETENTION_QUERY, id).Scan(&retention)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return storedSeconds(retention), err
+}
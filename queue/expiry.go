@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// The event's deadline passed before it could be delivered, see WithExpiresAt
+	DEAD_REASON_EXPIRED = "expired"
+)
+
+// Events without a deadline, or whose deadline hasn't passed yet
+const NOT_EXPIRED_CONDITION = `(expires_at IS NULL OR expires_at > unixepoch('subsec'))`
+
+const EXPIRED_CONDITION = `expires_at <= unixepoch('subsec')`
+
+// Give the event a deadline after which delivering it is pointless, e.g. a one time password.
+// Events past their deadline are never delivered and are moved to the dead letter table
+// with reason DEAD_REASON_EXPIRED. Events already in flight when the deadline passes are
+// left to their consumer
+func WithExpiresAt(deadline time.Time) InsertOption {
+	return func(e *Envelope) {
+		e.ExpiresAt = deadline
+	}
+}
+
+// WithExpiresAt, with the deadline ttl from now
+func WithExpiresIn(ttl time.Duration) InsertOption {
+	return WithExpiresAt(time.Now().Add(ttl))
+}
+
+// Store the zero time as NULL, anything else as fractional unix time
+func nullUnixTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return float64(t.UnixMilli()) / 1000
+}
+
+// Move pending events whose deadline passed to the dead letter table. Callers must hold q.lock
+func (q *Queue[T]) deadLetterExpired(ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	ids, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, EXPIRED_CONDITION)
+	if err != nil {
+		return fmt.Errorf("problem moving expired events to the dead letter table: %w", err)
+	}
+	var dead transitions
+	if err := markDead(tx, ids, DEAD_REASON_EXPIRED, &dead); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*ts = append(*ts, dead...)
+	return nil
+}
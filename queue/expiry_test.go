@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiresAt(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithEpochClaims(), WithSynchronousMaintenance()).WithClaimTimeout(100 * time.Millisecond)
+
+	if err := q.Insert(Test{A: "otp"}, WithExpiresIn(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "report"}); err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := q.Peek(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.ExpiresAt.IsZero() || time.Until(envelope.ExpiresAt) > 50*time.Millisecond {
+		t.Fatalf("unexpected deadline %v", envelope.ExpiresAt)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("expected expired events not to count towards the size, got %d", size)
+	}
+	event, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil || event.Content.A != "report" {
+		t.Fatalf("expected the event without a deadline, got %+v", event)
+	}
+
+	// Maintenance ran as part of Next and dead lettered the expired event
+	envelope, err = q.Peek(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.State != EVENT_STATE_DEAD || envelope.DeadReason != DEAD_REASON_EXPIRED {
+		t.Fatalf("expected the expired event to be dead with reason %q, got %s %q", DEAD_REASON_EXPIRED, envelope.State, envelope.DeadReason)
+	}
+}
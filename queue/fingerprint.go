@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Scope of the queue's own settings in the KV table, out of reach of Queue.KV
+const SETTINGS_KV_SCOPE = "settings"
+
+// Key of the payload type fingerprint in SETTINGS_KV_SCOPE
+const PAYLOAD_FINGERPRINT_KEY = "payload_type"
+
+// Returned when a queue is opened with a payload type that can't decode the events of the
+// payload type it was created with
+var ErrPayloadTypeMismatch = errors.New("payload type mismatch")
+
+// The payload type a queue holds, stored when it is first opened
+type PayloadFingerprint struct {
+	// Package path and name of the type, e.g. "example.com/app/emails.Email"
+	Type   string        `json:"type"`
+	Schema PayloadSchema `json:"schema"`
+}
+
+// Record T as the queue's payload type even if it was created with another one, after the
+// events of the old type were drained
+func WithPayloadTypeOverride() Option {
+	return func(o *options) {
+		o.payloadTypeOverride = true
+	}
+}
+
+// The fingerprint of T
+func fingerprintOf[T any]() PayloadFingerprint {
+	t := reflect.TypeFor[T]()
+	name := t.String()
+	if t.Name() != "" && t.PkgPath() != "" {
+		name = t.PkgPath() + "." + t.Name()
+	}
+	return PayloadFingerprint{Type: name, Schema: SchemaOf[T]()}
+}
+
+// Payload types that hold any payload, e.g. for operational tools, which neither record nor
+// check a fingerprint
+func untypedPayload(t reflect.Type) bool {
+	return t == reflect.TypeFor[json.RawMessage]() || t == reflect.TypeFor[[]byte]() || t.Kind() == reflect.Interface
+}
+
+// Store T's fingerprint on first use and check it on later opens. T is only accepted if it
+// can decode the stored type's events, whether it is another type, e.g. after a rename or a
+// move to another package, or a changed version of the same one, and then replaces the
+// stored fingerprint. Fails with ErrPayloadTypeMismatch otherwise, unless override is set
+func checkPayloadFingerprint[T any](db *sql.DB, override bool) error {
+	if untypedPayload(reflect.TypeFor[T]()) {
+		return nil
+	}
+	current := fingerprintOf[T]()
+	var stored []byte
+	err := db.QueryRow(KV_GET_QUERY, SETTINGS_KV_SCOPE, PAYLOAD_FINGERPRINT_KEY).Scan(&stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("problem reading payload type fingerprint: %w", err)
+	}
+	if err == nil && !override {
+		var previous PayloadFingerprint
+		if err := json.Unmarshal(stored, &previous); err != nil {
+			return fmt.Errorf("problem decoding payload type fingerprint: %w", err)
+		}
+		if changes := previous.Schema.BreakingChanges(current.Schema); len(changes) > 0 {
+			if previous.Type == current.Type {
+				return fmt.Errorf("%w: the queue holds %s events of an earlier version of the type that it can't decode (%s); drain it and open it WithPayloadTypeOverride to switch versions",
+					ErrPayloadTypeMismatch, current.Type, strings.Join(changes, ", "))
+			}
+			return fmt.Errorf("%w: the queue holds %s, which %s can't decode (%s); drain it and open it WithPayloadTypeOverride to switch types",
+				ErrPayloadTypeMismatch, previous.Type, current.Type, strings.Join(changes, ", "))
+		}
+		if previous.Type == current.Type && reflect.DeepEqual(previous.Schema, current.Schema) {
+			return nil
+		}
+	}
+	value, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(KV_SET_QUERY, SETTINGS_KV_SCOPE, PAYLOAD_FINGERPRINT_KEY, value); err != nil {
+		return fmt.Errorf("problem storing payload type fingerprint: %w", err)
+	}
+	return nil
+}
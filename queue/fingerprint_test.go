@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPayloadFingerprintGuardsReopening(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance())
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type Incompatible struct{ B int }
+	if _, err := NewLocalQueue[Incompatible](name, WithSynchronousMaintenance()); !errors.Is(err, ErrPayloadTypeMismatch) {
+		t.Fatalf("expected a mismatched payload type to be rejected, got %v", err)
+	}
+	// Operational tools read queues of any payload type
+	raw, err := NewLocalQueue[json.RawMessage](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatalf("expected raw payloads to open any queue, got %v", err)
+	}
+	if err := raw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Can decode the events of Test, e.g. after a rename, and becomes the recorded type
+	type Renamed struct {
+		A     string
+		Added int
+	}
+	renamed, err := NewLocalQueue[Renamed](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatalf("expected a compatible payload type to be accepted, got %v", err)
+	}
+	if err := renamed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLocalQueue[Test](name, WithSynchronousMaintenance()); !errors.Is(err, ErrPayloadTypeMismatch) {
+		t.Fatalf("expected the old type to be rejected once the new one was recorded, got %v", err)
+	}
+}
+
+func TestPayloadFingerprintGuardsSchemaChangesOfTheSameType(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance())
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	// Test was deployed with A as a number before
+	type Earlier struct{ A int }
+	stored, err := json.Marshal(PayloadFingerprint{Type: fingerprintOf[Test]().Type, Schema: SchemaOf[Earlier]()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec(KV_SET_QUERY, SETTINGS_KV_SCOPE, PAYLOAD_FINGERPRINT_KEY, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewLocalQueue[Test](name, WithSynchronousMaintenance()); !errors.Is(err, ErrPayloadTypeMismatch) {
+		t.Fatalf("expected a breaking change of the same type to be rejected, got %v", err)
+	}
+	overridden, err := NewLocalQueue[Test](name, WithSynchronousMaintenance(), WithPayloadTypeOverride())
+	if err != nil {
+		t.Fatalf("expected the override to accept the new version, got %v", err)
+	}
+	if err := overridden.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatalf("expected the new version to be recorded, got %v", err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,26 @@
+package queue
+
+// Events that were never claimed
+const FRESH_CONDITION = `attempts IS NULL`
+
+// Bound the time to first claim of new events while a large backlog of retries is waiting:
+// every every-th claim made through this Queue takes the oldest event that was never claimed,
+// falling back to the usual order when there is none. The other claims keep strict id order,
+// so retried events keep the place they were enqueued at and age towards the front rather
+// than being starved. With every set to 2 fresh events get at least half of the claims.
+// Counted per Queue, so every consumer process interleaves on its own. 0 restores strict id
+// order
+func (q *Queue[T]) WithFreshEventShare(every int) *Queue[T] {
+	q.freshEvery = max(every, 0)
+	q.claims = 0
+	return q
+}
+
+// Whether this claim is reserved for a fresh event, counting it. Callers must hold q.lock
+func (q *Queue[T]) freshTurn() bool {
+	if q.freshEvery == 0 {
+		return false
+	}
+	q.claims++
+	return q.claims%q.freshEvery == 0
+}
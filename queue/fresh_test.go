@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestFreshEventShareInterleavesNewEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	// A backlog of events that were delivered before
+	for i := range 4 {
+		if err := q.Insert(Test{A: fmt.Sprintf("retry-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := q.Lease(4, q.claimTimeout)
+	if err != nil || len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %v", len(events), err)
+	}
+	for _, event := range events {
+		if err := q.Release(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 2 {
+		if err := q.Insert(Test{A: fmt.Sprintf("fresh-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q.WithFreshEventShare(2)
+	claimed := []string{}
+	for {
+		event, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			break
+		}
+		claimed = append(claimed, event.Content.A)
+	}
+	expected := []string{"retry-0", "fresh-0", "retry-1", "fresh-1", "retry-2", "retry-3"}
+	if !slices.Equal(claimed, expected) {
+		t.Fatalf("expected fresh events on every second claim, got %v", claimed)
+	}
+}
@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Number of events per state, one row of Stats.States
+type StateCount struct {
+	State string `json:"state"`
+	Count int    `json:"count"`
+	// The queue's labels when served by GrafanaHandler, see WithLabels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// The counts as rows, in the order events move through the states
+func (s Stats) States() []StateCount {
+	return []StateCount{
+		{State: EVENT_STATE_PENDING, Count: s.Pending},
+		{State: EVENT_STATE_INFLIGHT, Count: s.Inflight},
+		{State: EVENT_STATE_DEAD, Count: s.Dead},
+		{State: EVENT_STATE_COMPLETED, Count: s.Completed},
+	}
+}
+
+// Number of events whose value falls in a bucket, see Analytics.PendingAgeHistogram
+type HistogramBucket struct {
+	// Describes the range of the bucket, e.g. "<=10s"
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+// Upper bounds of a histogram's buckets and a label for each, plus one for values above the
+// last bound
+type histogramSpec struct {
+	bounds []float64
+	labels []string
+}
+
+var pendingAgeHistogram = histogramSpec{
+	bounds: []float64{1, 10, 60, 600, 3600, 6 * 3600, 24 * 3600},
+	labels: []string{"<=1s", "<=10s", "<=1m", "<=10m", "<=1h", "<=6h", "<=1d", ">1d"},
+}
+
+var retriesHistogram = histogramSpec{
+	bounds: []float64{0, 1, 2, 5, 10},
+	labels: []string{"0", "1", "2", "3-5", "6-10", ">10"},
+}
+
+const PENDING_AGE_QUERY = `SELECT unixepoch('now') - unixepoch(enqueued_at) AS value FROM queue WHERE payload IS NOT NULL AND enqueued_at IS NOT NULL`
+
+const LIVE_RETRIES_QUERY = `SELECT IFNULL(retries, 0) AS value FROM queue WHERE payload IS NOT NULL
+UNION ALL SELECT IFNULL(retries, 0) FROM queue_inflight
+UNION ALL SELECT IFNULL(retries, 0) FROM queue_dead`
+
+// How long pending events have been waiting since they were enqueued
+func (a Analytics) PendingAgeHistogram() ([]HistogramBucket, error) {
+	return a.histogram(PENDING_AGE_QUERY, pendingAgeHistogram)
+}
+
+// How many times pending, in-flight and dead events have failed
+func (a Analytics) RetriesHistogram() ([]HistogramBucket, error) {
+	return a.histogram(LIVE_RETRIES_QUERY, retriesHistogram)
+}
+
+// Count the values selected by values, a query with a single column called value, into the
+// buckets of spec. Every bucket is returned, empty or not
+func (a Analytics) histogram(values string, spec histogramSpec) ([]HistogramBucket, error) {
+	cases := []string{}
+	for i, bound := range spec.bounds {
+		cases = append(cases, fmt.Sprintf("WHEN value <= %v THEN %d", bound, i))
+	}
+	query := fmt.Sprintf("SELECT CASE %s ELSE %d END AS bucket, COUNT(*) FROM (%s) GROUP BY bucket",
+		strings.Join(cases, " "), len(spec.bounds), values)
+	rows, err := a.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("problem computing histogram: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	buckets := make([]HistogramBucket, len(spec.labels))
+	for i, label := range spec.labels {
+		buckets[i].Bucket = label
+	}
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("problem computing histogram: %w", err)
+		}
+		buckets[bucket].Count = count
+	}
+	return buckets, rows.Err()
+}
+
+// A table in the response of the Grafana JSON datasource's /query endpoint
+type GrafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []GrafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	// One of "string", "number" or "time"
+	Type string `json:"type"`
+}
+
+// A time series in the response of the Grafana JSON datasource's /query endpoint
+type GrafanaSeries struct {
+	Target string `json:"target"`
+	// Pairs of value and unix time in milliseconds
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// Targets the Grafana JSON datasource can query
+const (
+	GRAFANA_TARGET_STATS   = "stats"
+	GRAFANA_TARGET_BACKLOG = "backlog"
+	GRAFANA_TARGET_AGE     = "pending_age"
+	GRAFANA_TARGET_RETRIES = "retries"
+)
+
+// Serve the queue's statistics in shapes Grafana charts without a Prometheus in between.
+// The Infinity datasource reads flat JSON arrays from
+//
+//	GET /stats                  events per state
+//	GET /backlog?since=24h      hourly backlog burn-down, see Analytics.BacklogBurnDown
+//	GET /histograms/pending_age how long pending events have been waiting
+//	GET /histograms/retries     how often live events have failed
+//
+// and the JSON datasource uses / to test the connection, POST /metrics to list the targets
+// above and POST /query to fetch them for the dashboard's time range
+func (q *Queue[T]) GrafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := q.Stats(StatsFilter{Tag: r.URL.Query().Get("tag")})
+		states := stats.States()
+		for i := range states {
+			states[i].Labels = q.labels
+		}
+		writeGrafanaJSON(w, states, err)
+	})
+	mux.HandleFunc("GET /backlog", func(w http.ResponseWriter, r *http.Request) {
+		since := 24 * time.Hour
+		if raw := r.URL.Query().Get("since"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+				return
+			}
+			since = parsed
+		}
+		points, err := q.Analytics().BacklogBurnDown(time.Now().Add(-since))
+		writeGrafanaJSON(w, points, err)
+	})
+	mux.HandleFunc("GET /histograms/pending_age", func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := q.Analytics().PendingAgeHistogram()
+		writeGrafanaJSON(w, buckets, err)
+	})
+	mux.HandleFunc("GET /histograms/retries", func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := q.Analytics().RetriesHistogram()
+		writeGrafanaJSON(w, buckets, err)
+	})
+	mux.HandleFunc("POST /metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics := []map[string]string{}
+		for _, target := range []string{GRAFANA_TARGET_STATS, GRAFANA_TARGET_BACKLOG, GRAFANA_TARGET_AGE, GRAFANA_TARGET_RETRIES} {
+			metrics = append(metrics, map[string]string{"label": target, "value": target})
+		}
+		writeGrafanaJSON(w, metrics, nil)
+	})
+	mux.HandleFunc("POST /query", func(w http.ResponseWriter, r *http.Request) {
+		var request grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		results := []any{}
+		for _, target := range request.Targets {
+			result, err := q.grafanaTarget(target.Target, request.Range.From)
+			if err != nil {
+				writeGrafanaJSON(w, nil, err)
+				return
+			}
+			results = append(results, result...)
+		}
+		writeGrafanaJSON(w, results, nil)
+	})
+	return mux
+}
+
+// The part of the JSON datasource's query request the handler uses
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// The tables or series for target, with the backlog starting at from
+func (q *Queue[T]) grafanaTarget(target string, from time.Time) ([]any, error) {
+	switch target {
+	case GRAFANA_TARGET_STATS:
+		stats, err := q.Stats(StatsFilter{})
+		if err != nil {
+			return nil, err
+		}
+		// One column per label so panels can be split by ownership
+		keys := slices.Sorted(maps.Keys(q.labels))
+		table := GrafanaTable{Type: "table", Columns: []GrafanaColumn{{"state", "string"}, {"count", "number"}}, Rows: [][]any{}}
+		for _, key := range keys {
+			table.Columns = append(table.Columns, GrafanaColumn{key, "string"})
+		}
+		for _, state := range stats.States() {
+			row := []any{state.State, state.Count}
+			for _, key := range keys {
+				row = append(row, q.labels[key])
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		return []any{table}, nil
+	case GRAFANA_TARGET_BACKLOG:
+		points, err := q.Analytics().BacklogBurnDown(from)
+		if err != nil {
+			return nil, err
+		}
+		enqueued := GrafanaSeries{Target: "enqueued", Datapoints: [][2]float64{}}
+		resolved := GrafanaSeries{Target: "resolved", Datapoints: [][2]float64{}}
+		backlog := GrafanaSeries{Target: "backlog", Datapoints: [][2]float64{}}
+		for _, point := range points {
+			at := float64(point.Hour.UnixMilli())
+			enqueued.Datapoints = append(enqueued.Datapoints, [2]float64{float64(point.Enqueued), at})
+			resolved.Datapoints = append(resolved.Datapoints, [2]float64{float64(point.Resolved), at})
+			backlog.Datapoints = append(backlog.Datapoints, [2]float64{float64(point.Backlog), at})
+		}
+		return []any{enqueued, resolved, backlog}, nil
+	case GRAFANA_TARGET_AGE, GRAFANA_TARGET_RETRIES:
+		histogram := q.Analytics().PendingAgeHistogram
+		if target == GRAFANA_TARGET_RETRIES {
+			histogram = q.Analytics().RetriesHistogram
+		}
+		buckets, err := histogram()
+		if err != nil {
+			return nil, err
+		}
+		table := GrafanaTable{Type: "table", Columns: []GrafanaColumn{{"bucket", "string"}, {"count", "number"}}, Rows: [][]any{}}
+		for _, bucket := range buckets {
+			table.Rows = append(table.Rows, []any{bucket.Bucket, bucket.Count})
+		}
+		return []any{table}, nil
+	}
+	return nil, fmt.Errorf("unknown target %q", target)
+}
+
+func writeGrafanaJSON(w http.ResponseWriter, body any, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error(fmt.Sprintf("problem writing grafana response: %v", err))
+	}
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGrafanaHandler(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithRetryBackoffSeconds(0)
+	for range 3 {
+		if err := q.Insert(Test{A: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(q.GrafanaHandler())
+	defer server.Close()
+
+	var states []StateCount
+	getJSON(t, server.URL+"/stats", &states)
+	if len(states) != 4 || states[0].State != EVENT_STATE_PENDING || states[0].Count != 3 {
+		t.Fatalf("expected 3 pending events first, got %v", states)
+	}
+	var retries []HistogramBucket
+	getJSON(t, server.URL+"/histograms/retries", &retries)
+	if len(retries) != 6 || retries[0] != (HistogramBucket{"0", 2}) || retries[1] != (HistogramBucket{"1", 1}) {
+		t.Fatalf("expected 2 events without retries and 1 with one, got %v", retries)
+	}
+	var ages []HistogramBucket
+	getJSON(t, server.URL+"/histograms/pending_age", &ages)
+	total := 0
+	for _, bucket := range ages {
+		total += bucket.Count
+	}
+	if total != 3 {
+		t.Fatalf("expected every pending event in the age histogram, got %v", ages)
+	}
+
+	query := `{"range": {"from": "2024-01-01T00:00:00Z"}, "targets": [{"target": "stats"}, {"target": "backlog"}]}`
+	response, err := http.Post(server.URL+"/query", "application/json", strings.NewReader(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	var results []map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	// One stats table and the enqueued, resolved and backlog series
+	if len(results) != 4 || results[0]["type"] != "table" || results[3]["target"] != "backlog" {
+		t.Fatalf("expected a table and 3 series, got %v", results)
+	}
+
+	response, err = http.Post(server.URL+"/query", "application/json", strings.NewReader(`{"targets": [{"target": "nope"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected an unknown target to fail, got %d", response.StatusCode)
+	}
+}
+
+func getJSON(t *testing.T, url string, into any) {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected %s to succeed, got %d", url, response.StatusCode)
+	}
+	if err := json.NewDecoder(response.Body).Decode(into); err != nil {
+		t.Fatal(err)
+	}
+}
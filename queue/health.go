@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Whether the queue is currently claiming events, see WithHealthPausing
+type HealthState string
+
+const (
+	HEALTH_HEALTHY HealthState = "healthy"
+	// Claiming is paused after repeated database errors, only occasional probes are made
+	HEALTH_DEGRADED HealthState = "degraded"
+)
+
+// Returned by Next instead of querying the database while the queue is degraded
+var ErrQueueDegraded = errors.New("queue is degraded after repeated database errors")
+
+// How long a degraded queue waits before its first probe, doubled after every failed probe
+const initialProbeInterval = time.Second
+
+// Stop claiming events after errorThreshold consecutive database errors instead of
+// hammering a failing backend, e.g. during a Turso outage. While degraded Next fails with
+// ErrQueueDegraded without touching the database, except for probes whose interval starts
+// at a second and doubles after every failure up to maxProbeInterval. The first successful
+// probe makes the queue healthy again. Errors decoding a payload don't count
+func (q *Queue[T]) WithHealthPausing(errorThreshold int, maxProbeInterval time.Duration) *Queue[T] {
+	q.health.configure(max(errorThreshold, 1), max(maxProbeInterval, initialProbeInterval))
+	return q
+}
+
+// Register fn to be called whenever the queue changes between HEALTH_HEALTHY and
+// HEALTH_DEGRADED, e.g. to alert operators that the queue is in degraded mode
+func (q *Queue[T]) WithOnHealthChange(fn func(HealthState)) *Queue[T] {
+	q.health.lock.Lock()
+	defer q.health.lock.Unlock()
+	q.health.onChange = fn
+	return q
+}
+
+// Returns whether the queue is currently claiming events
+func (q *Queue[T]) Health() HealthState {
+	q.health.lock.Lock()
+	defer q.health.lock.Unlock()
+	return q.health.state
+}
+
+// Tracks consecutive database errors and decides when to probe a degraded database
+type healthBreaker struct {
+	lock             sync.Mutex
+	enabled          bool
+	errorThreshold   int
+	maxProbeInterval time.Duration
+	onChange         func(HealthState)
+
+	state         HealthState
+	failures      int
+	probeInterval time.Duration
+	nextProbe     time.Time
+}
+
+func (b *healthBreaker) configure(errorThreshold int, maxProbeInterval time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.enabled = true
+	b.errorThreshold = errorThreshold
+	b.maxProbeInterval = maxProbeInterval
+}
+
+// Whether the database should be queried, true for at most one probe per probe interval
+// while degraded
+func (b *healthBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != HEALTH_DEGRADED {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.nextProbe) {
+		return false
+	}
+	// Hold off other callers until this probe has been recorded
+	b.nextProbe = now.Add(b.probeInterval)
+	return true
+}
+
+// Record the outcome of a database operation and call the hook if the state changed. Must
+// not be called with q.lock held
+func (b *healthBreaker) record(err error) {
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		return
+	}
+	b.lock.Lock()
+	if !b.enabled {
+		b.lock.Unlock()
+		return
+	}
+	previous := b.state
+	if err == nil {
+		b.failures = 0
+		b.state = HEALTH_HEALTHY
+	} else {
+		b.failures++
+		switch {
+		case b.state == HEALTH_DEGRADED:
+			b.probeInterval = min(2*b.probeInterval, b.maxProbeInterval)
+			b.nextProbe = time.Now().Add(b.probeInterval)
+		case b.failures >= b.errorThreshold:
+			b.state = HEALTH_DEGRADED
+			b.probeInterval = initialProbeInterval
+			b.nextProbe = time.Now().Add(b.probeInterval)
+		}
+	}
+	hook := b.onChange
+	b.lock.Unlock()
+	if hook != nil && b.state != previous {
+		hook(b.state)
+	}
+}
+
+// An event's payload could not be decoded, which says nothing about the database's health
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
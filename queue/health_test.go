@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthPausing(t *testing.T) {
+	type Test struct{ A string }
+	changes := []HealthState{}
+	q := newTestQueue[Test](t).
+		WithHealthPausing(2, time.Minute).
+		WithOnHealthChange(func(state HealthState) { changes = append(changes, state) })
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an outage
+	if _, err := q.db.Exec("ALTER TABLE queue RENAME TO queue_offline"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := q.Next(); err == nil || err == ErrQueueDegraded {
+			t.Fatalf("expected a database error, got %v", err)
+		}
+	}
+	if q.Health() != HEALTH_DEGRADED {
+		t.Fatalf("expected the queue to be degraded, got %s", q.Health())
+	}
+	if _, err := q.Next(); err != ErrQueueDegraded {
+		t.Fatalf("expected ErrQueueDegraded, got %v", err)
+	}
+
+	if _, err := q.db.Exec("ALTER TABLE queue_offline RENAME TO queue"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(initialProbeInterval)
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected the probe to claim the event, got %v: %v", event, err)
+	}
+	if q.Health() != HEALTH_HEALTHY {
+		t.Fatalf("expected the queue to be healthy, got %s", q.Health())
+	}
+	if len(changes) != 2 || changes[0] != HEALTH_DEGRADED || changes[1] != HEALTH_HEALTHY {
+		t.Fatalf("unexpected health changes %v", changes)
+	}
+}
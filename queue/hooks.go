@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How often WaitUntilEmpty checks the size of the queue
+const emptyPollInterval = 250 * time.Millisecond
+
+type emptyState int
+
+const (
+	emptyStateUnknown emptyState = iota
+	emptyStateEmpty
+	emptyStateNonEmpty
+)
+
+// Register fn to be called whenever this queue is observed going from holding events to
+// holding none, e.g. after the last event is acked. Useful for triggering "all work is done"
+// follow-ups in batch pipelines. Transitions are observed after Insert, Ack, Nack and
+// CompleteLease calls made through this Queue.
+func (q *Queue[T]) WithOnEmpty(fn func()) *Queue[T] {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	q.onEmpty = fn
+	q.initEmptyState()
+	return q
+}
+
+// Register fn to be called whenever this queue is observed going from holding no events
+// to holding some, the counterpart of WithOnEmpty
+func (q *Queue[T]) WithOnNonEmpty(fn func()) *Queue[T] {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	q.onNonEmpty = fn
+	q.initEmptyState()
+	return q
+}
+
+// Record the current state so the first transition after a hook is registered is reported.
+// Callers must hold q.hookLock
+func (q *Queue[T]) initEmptyState() {
+	if q.emptyState != emptyStateUnknown {
+		return
+	}
+	size, err := q.Size()
+	if err != nil {
+		return
+	}
+	q.emptyState = stateForSize(size)
+}
+
+// Check whether the queue has transitioned between empty and non-empty and call the
+// registered hooks if so. Must not be called with q.lock held
+func (q *Queue[T]) checkEmpty() {
+	q.hookLock.Lock()
+	if q.onEmpty == nil && q.onNonEmpty == nil {
+		q.hookLock.Unlock()
+		return
+	}
+	size, err := q.Size()
+	if err != nil {
+		q.hookLock.Unlock()
+		q.logger().Error(fmt.Errorf("problem checking whether the queue is empty: %w", err).Error())
+		return
+	}
+	previous := q.emptyState
+	q.emptyState = stateForSize(size)
+	var hook func()
+	if previous != q.emptyState {
+		switch q.emptyState {
+		case emptyStateEmpty:
+			hook = q.onEmpty
+		case emptyStateNonEmpty:
+			hook = q.onNonEmpty
+		}
+	}
+	q.hookLock.Unlock()
+	if hook != nil {
+		hook()
+	}
+}
+
+func stateForSize(size int) emptyState {
+	if size == 0 {
+		return emptyStateEmpty
+	}
+	return emptyStateNonEmpty
+}
+
+// Block until the queue holds no pending or in-flight events, or ctx is done. Events that
+// have exhausted their retries are not counted. Unlike WithOnEmpty this also observes work
+// done by other processes sharing the same database.
+func (q *Queue[T]) WaitUntilEmpty(ctx context.Context) error {
+	ticker := time.NewTicker(emptyPollInterval)
+	defer ticker.Stop()
+	for {
+		size, err := q.Size()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// A change in the state of an event, reported to the hook registered with WithOnTransition
+type Transition string
+
+const (
+	TRANSITION_INSERTED      Transition = "inserted"
+	TRANSITION_CLAIMED       Transition = "claimed"
+	TRANSITION_ACKED         Transition = "acked"
+	TRANSITION_NACKED        Transition = "nacked"
+	TRANSITION_RELEASED      Transition = "released" // including claims that expired
+	TRANSITION_DEAD_LETTERED Transition = "dead_lettered"
+	TRANSITION_UNACKED       Transition = "unacked"
+	// Returned from the dead letter table after the retry limit was raised, see WithMaxRetires
+	TRANSITION_REVIVED Transition = "revived"
+)
+
+// Register fn to be called after every transition of an event made through this Queue,
+// with the event's envelope as it was right after the transition. fn is called once the
+// transition has been committed and must not block for long, it runs on the goroutine
+// that made the transition.
+func (q *Queue[T]) WithOnTransition(fn func(Transition, Envelope)) *Queue[T] {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	q.onTransition = fn
+	return q
+}
+
+type transitionRecord struct {
+	transition Transition
+	envelope   Envelope
+}
+
+// Transitions made in a transaction, reported by notifyTransitions once it has committed
+type transitions []transitionRecord
+
+func (ts *transitions) add(transition Transition, envelope Envelope) {
+	*ts = append(*ts, transitionRecord{transition, envelope})
+}
+
+// Forget the recorded transitions, e.g. because their transaction failed to commit
+func (ts *transitions) reset() {
+	*ts = (*ts)[:0]
+}
+
+// Call the transition hook for each of ts, then the batch completion hook for batches
+// they completed. Must not be called with q.lock held
+func (q *Queue[T]) notifyTransitions(ts transitions) {
+	q.hookLock.Lock()
+	onTransition := q.onTransition
+	q.hookLock.Unlock()
+	if onTransition != nil {
+		for _, t := range ts {
+			t.envelope.QueueLabels = q.labels
+			onTransition(t.transition, t.envelope)
+		}
+	}
+	q.checkBatches(ts)
+	q.mirrorToShadow(ts)
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOnEmpty(t *testing.T) {
+	type Test struct{ A string }
+	emptied, filled := 0, 0
+	q := newTestQueue[Test](t).
+		WithOnEmpty(func() { emptied++ }).
+		WithOnNonEmpty(func() { filled++ })
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	if filled != 1 || emptied != 0 {
+		t.Fatalf("expected a single non-empty transition, got filled=%d emptied=%d", filled, emptied)
+	}
+
+	for range 2 {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatal(err)
+		}
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if filled != 1 || emptied != 1 {
+		t.Fatalf("expected a single empty transition, got filled=%d emptied=%d", filled, emptied)
+	}
+}
+
+func TestWaitUntilEmpty(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Times out while the event is still in the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := q.WaitUntilEmpty(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	go func() {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			return
+		}
+		_ = q.Ack(event.Id)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.WaitUntilEmpty(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returned when decoding an external id that no IDCodec could have produced
+var ErrInvalidExternalID = errors.New("invalid external event id")
+
+// Translates between stored event ids and the ids shown outside the application, e.g. in
+// API responses and webhooks, see WithIDCodec
+type IDCodec interface {
+	EncodeID(id int) string
+	DecodeID(external string) (int, error)
+}
+
+// Exposes ids as they are stored, the default
+type PlainIDCodec struct{}
+
+func (PlainIDCodec) EncodeID(id int) string {
+	return strconv.Itoa(id)
+}
+
+func (PlainIDCodec) DecodeID(external string) (int, error) {
+	id, err := strconv.Atoi(external)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExternalID, external)
+	}
+	return id, nil
+}
+
+const idAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const feistelRounds = 4
+
+// Hides sequential ids behind short opaque strings, so exposed ids don't leak how many
+// events a queue has seen. Ids are shuffled with a keyed permutation and base62 encoded,
+// anyone without the key can't tell consecutive ids apart or forge valid ones in order
+type ObfuscatedIDCodec struct {
+	key []byte
+}
+
+// An ObfuscatedIDCodec keyed with key. Keep the key stable, external ids handed out
+// before a change won't decode afterwards
+func NewObfuscatedIDCodec(key []byte) *ObfuscatedIDCodec {
+	return &ObfuscatedIDCodec{key: key}
+}
+
+func (c *ObfuscatedIDCodec) EncodeID(id int) string {
+	value := c.permute(uint64(id), false)
+	var b strings.Builder
+	for {
+		b.WriteByte(idAlphabet[value%62])
+		value /= 62
+		if value == 0 {
+			break
+		}
+	}
+	return b.String()
+}
+
+func (c *ObfuscatedIDCodec) DecodeID(external string) (int, error) {
+	if external == "" || len(external) > 11 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExternalID, external)
+	}
+	var value uint64
+	for i := len(external) - 1; i >= 0; i-- {
+		digit := strings.IndexByte(idAlphabet, external[i])
+		if digit < 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidExternalID, external)
+		}
+		next := value*62 + uint64(digit)
+		if next/62 != value {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidExternalID, external)
+		}
+		value = next
+	}
+	id := c.permute(value, true)
+	if id == 0 || id > 1<<62 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExternalID, external)
+	}
+	return int(id), nil
+}
+
+// A Feistel network over the two 32 bit halves of value, run backwards to invert it
+func (c *ObfuscatedIDCodec) permute(value uint64, inverse bool) uint64 {
+	left, right := uint32(value>>32), uint32(value)
+	for i := range feistelRounds {
+		round := i
+		if inverse {
+			round = feistelRounds - 1 - i
+			left, right = right^c.round(round, left), left
+			continue
+		}
+		left, right = right, left^c.round(round, right)
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+func (c *ObfuscatedIDCodec) round(round int, half uint32) uint32 {
+	mac := hmac.New(sha256.New, c.key)
+	var input [5]byte
+	input[0] = byte(round)
+	binary.BigEndian.PutUint32(input[1:], half)
+	mac.Write(input[:])
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// Encode event ids shown outside the application with codec, see ExternalID. Storage keeps
+// using integers
+func (q *Queue[T]) WithIDCodec(codec IDCodec) *Queue[T] {
+	q.idCodec = codec
+	return q
+}
+
+// The id of event with id: id as it should be shown outside the application
+func (q *Queue[T]) ExternalID(id int) string {
+	if q.idCodec == nil {
+		return PlainIDCodec{}.EncodeID(id)
+	}
+	return q.idCodec.EncodeID(id)
+}
+
+// The stored id of an event from its external id, see ExternalID
+func (q *Queue[T]) ParseExternalID(external string) (int, error) {
+	if q.idCodec == nil {
+		return PlainIDCodec{}.DecodeID(external)
+	}
+	return q.idCodec.DecodeID(external)
+}
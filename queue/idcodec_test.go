@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestObfuscatedIDCodec(t *testing.T) {
+	codec := NewObfuscatedIDCodec([]byte("secret"))
+	seen := map[string]bool{}
+	for _, id := range []int{1, 2, 3, 1000, 1 << 40} {
+		external := codec.EncodeID(id)
+		if seen[external] {
+			t.Fatalf("expected distinct external ids, %q repeats", external)
+		}
+		seen[external] = true
+		decoded, err := codec.DecodeID(external)
+		if err != nil || decoded != id {
+			t.Fatalf("expected %q to decode to %d, got %d: %v", external, id, decoded, err)
+		}
+	}
+	if codec.EncodeID(1) == NewObfuscatedIDCodec([]byte("other")).EncodeID(1) {
+		t.Fatal("expected external ids to depend on the key")
+	}
+	if _, err := codec.DecodeID("not-an-id!"); !errors.Is(err, ErrInvalidExternalID) {
+		t.Fatalf("expected ErrInvalidExternalID, got %v", err)
+	}
+}
+
+func TestWithIDCodec(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	if q.ExternalID(7) != "7" {
+		t.Fatalf("expected plain ids by default, got %q", q.ExternalID(7))
+	}
+
+	q.WithIDCodec(NewObfuscatedIDCodec([]byte("secret")))
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	external := q.ExternalID(1)
+	if external == "1" {
+		t.Fatal("expected the id to be obfuscated")
+	}
+	id, err := q.ParseExternalID(external)
+	if err != nil || id != 1 {
+		t.Fatalf("expected id 1, got %d: %v", id, err)
+	}
+
+	var out bytes.Buffer
+	if _, err := q.StreamList(context.Background(), &out, ListFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	var event StreamedEvent
+	if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ExternalID != external {
+		t.Fatalf("expected listed events to carry the external id %q, got %q", external, event.ExternalID)
+	}
+}
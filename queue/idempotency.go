@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Operation ids of writes made with idempotent retries, recorded in the same transaction as
+// the write so a retry can tell whether an attempt that seemed to fail was applied
+const CREATE_OPERATIONS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_operations (
+    id TEXT PRIMARY KEY,
+    applied_at TEXT DEFAULT (datetime('now', 'utc'))
+);
+`
+
+const RECORD_OPERATION_QUERY = `INSERT OR IGNORE INTO queue_operations (id) VALUES (?)`
+
+// Retries happen within seconds, a day of operation ids is plenty
+const PURGE_OPERATIONS_QUERY = `DELETE FROM queue_operations WHERE applied_at < datetime('now', '-1 day', 'utc')`
+
+// Scope of the results of writes that return something, e.g. the ids InsertReturningIDs
+// assigned, so a retry of a write that was applied can still return them
+const OPERATIONS_KV_SCOPE = "operations"
+
+const PURGE_OPERATION_RESULTS_QUERY = `DELETE FROM queue_kv WHERE scope = ? AND updated_at < datetime('now', '-1 day', 'utc')`
+
+// Returned within a retried write's transaction when an earlier attempt was applied
+var errOperationApplied = errors.New("operation was already applied")
+
+// Substrings of errors from remote databases that may have been returned after the server
+// applied the write, e.g. when the response was lost
+var TRANSIENT_ERROR_MARKERS = []string{
+	"timeout",
+	"timed out",
+	"connection reset",
+	"broken pipe",
+	"unexpected eof",
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// Retry Insert, InsertBatch, SpawnChildren, InsertReserved, InsertReturningIDs, Ack and Nack
+// up to attempts times after transient errors such as a 5xx response or a timeout from
+// Turso, waiting backoff before the first retry and twice as long before each further one.
+// Remote writes can fail after the server applied them, so every write records a
+// client-generated operation id in the same transaction and a retry of a write that was
+// applied succeeds without applying it again. 0 disables retries
+func (q *Queue[T]) WithIdempotentRetries(attempts int, backoff time.Duration) *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.idempotentRetries = max(attempts, 0)
+	q.idempotentBackoff = backoff
+	return q
+}
+
+// Whether err may be resolved by retrying the same write
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, marker := range TRANSIENT_ERROR_MARKERS {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Call write with an operation id, again with the same id after transient errors when
+// configured WithIdempotentRetries. write must record the id with recordOperation in the
+// transaction it writes in. Without retries the id is empty and nothing is recorded
+func (q *Queue[T]) retryTransient(write func(opID string) error) error {
+	if q.idempotentRetries == 0 {
+		return write("")
+	}
+	opID := newOperationID()
+	backoff := q.idempotentBackoff
+	for attempt := 0; ; attempt++ {
+		err := write(opID)
+		if errors.Is(err, errOperationApplied) {
+			return nil
+		}
+		if err == nil || attempt >= q.idempotentRetries || !isTransientError(err) {
+			return err
+		}
+		q.logger().Warn(fmt.Sprintf("retrying write %s after transient error: %v", opID, err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Record the operation id within the write's transaction, failing with errOperationApplied
+// if an earlier attempt of the same write was committed. Does nothing for an empty id
+func recordOperation(tx *sql.Tx, opID string) error {
+	if opID == "" {
+		return nil
+	}
+	result, err := tx.Exec(RECORD_OPERATION_QUERY, opID)
+	if err != nil {
+		return fmt.Errorf("problem recording operation %s: %w", opID, err)
+	}
+	if recorded, err := result.RowsAffected(); err != nil {
+		return err
+	} else if recorded == 0 {
+		return errOperationApplied
+	}
+	return nil
+}
+
+// Forget operation ids old enough that no retry can still be running. Callers must hold q.lock
+func (q *Queue[T]) purgeOperations() error {
+	if q.idempotentRetries == 0 {
+		return nil
+	}
+	if _, err := q.db.Exec(PURGE_OPERATIONS_QUERY); err != nil {
+		return fmt.Errorf("problem purging operation ids: %w", err)
+	}
+	if _, err := q.db.Exec(PURGE_OPERATION_RESULTS_QUERY, OPERATIONS_KV_SCOPE); err != nil {
+		return fmt.Errorf("problem purging operation results: %w", err)
+	}
+	return nil
+}
+
+// Random id for a write retried WithIdempotentRetries
+func newOperationID() string {
+	return rand.Text()
+}
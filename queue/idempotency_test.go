@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRetriesDontDoubleApply(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithIdempotentRetries(3, time.Millisecond)
+
+	// The first attempt is committed but its response is lost
+	attempts := 0
+	err := q.retryTransient(func(opID string) error {
+		attempts++
+		var ts transitions
+		q.lock.Lock()
+		err := q.insertOne(newEnvelope([]byte(`{"A":"once"}`)), opID, &ts)
+		q.lock.Unlock()
+		if err == nil && attempts == 1 {
+			return errors.New("hrana: 503 Service Unavailable")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected a single retry, got %d attempts", attempts)
+	}
+	if size, err := q.Size(); err != nil || size != 1 {
+		t.Fatalf("expected the event to be inserted once, got %d: %v", size, err)
+	}
+
+	// Ack and Nack still work with operation ids recorded
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	var recorded int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM queue_operations").Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 3 {
+		t.Fatalf("expected an operation id per write, got %d", recorded)
+	}
+}
+
+func TestIdempotentRetriesGiveUp(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithIdempotentRetries(2, time.Millisecond)
+
+	attempts := 0
+	err := q.retryTransient(func(string) error {
+		attempts++
+		return fmt.Errorf("problem querying: %w", context.DeadlineExceeded)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 3 {
+		t.Fatalf("expected to give up after 2 retries, got %d attempts: %v", attempts, err)
+	}
+
+	attempts = 0
+	err = q.retryTransient(func(string) error {
+		attempts++
+		return errors.New("UNIQUE constraint failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("expected permanent errors not to be retried, got %d attempts: %v", attempts, err)
+	}
+}
+
+func TestIdempotentRetriesCoverBatches(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithIdempotentRetries(3, time.Millisecond)
+
+	if _, err := q.InsertBatch([]Test{{A: "one"}, {A: "two"}}); err != nil {
+		t.Fatal(err)
+	}
+	var recorded int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM queue_operations").Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 1 {
+		t.Fatalf("expected the batch to record an operation id, got %d", recorded)
+	}
+}
@@ -0,0 +1,255 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type ImportFormat string
+
+const (
+	// One JSON encoded payload per line, blank lines are skipped
+	ImportNDJSON ImportFormat = "ndjson"
+	// A header row naming the fields followed by one payload per row. Each row is turned
+	// into a JSON object of header → value before being decoded into T. Values are typed by
+	// the field of T they fill, e.g. a number for an int field, and left out when empty for
+	// fields that aren't strings. Columns T has no field for are inferred, values that are
+	// valid JSON are used as is and everything else becomes a string
+	ImportCSV ImportFormat = "csv"
+)
+
+// The largest NDJSON line Import will accept
+const maxImportLineBytes = 16 * 1024 * 1024
+
+type ImportOptions struct {
+	// Defaults to ImportNDJSON
+	Format ImportFormat
+	// How many events are inserted per transaction, defaults to 1000
+	BatchSize int
+	// Upper bound on the number of events inserted per second, 0 means unlimited
+	RatePerSecond int
+	// Called after every committed batch with the total number of events imported so far
+	Progress func(imported int)
+}
+
+// Bulk load payloads from r into the queue, e.g. to seed a queue during a migration.
+// Payloads are inserted in transactions of opts.BatchSize so millions of events can be
+// streamed without holding them in memory. Imports count against the limit configured
+// WithInsertRateLimit like inserts do. Returns how many events were imported, which on
+// error is the number committed before the failure. Payloads skipped as duplicates, see
+// WithContentDedup, are not counted.
+func (q *Queue[T]) Import(ctx context.Context, r io.Reader, opts ImportOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	var next func() ([]byte, error)
+	switch opts.Format {
+	case ImportNDJSON, "":
+		next = ndjsonRecords(r)
+	case ImportCSV:
+		next = csvRecords(r, SchemaOf[T]())
+	default:
+		return 0, fmt.Errorf("unsupported import format: %s", opts.Format)
+	}
+
+	// Records read so far, imported counts those that were inserted
+	read, imported := 0, 0
+	start := time.Now()
+	batch := make([][]byte, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		pausedOpts, err := q.pausedInsertOptions()
+		if err != nil {
+			return err
+		}
+		inserted, err := q.insertBatch(batch, pausedOpts...)
+		if err != nil {
+			return err
+		}
+		imported += inserted
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(imported)
+		}
+		if opts.RatePerSecond > 0 {
+			// Sleep until we are back under the requested rate
+			expected := time.Duration(float64(imported) / float64(opts.RatePerSecond) * float64(time.Second))
+			if wait := expected - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+		record, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, fmt.Errorf("problem reading record %d: %w", read+1, err)
+		}
+		var payload T
+		if err := json.Unmarshal(record, &payload); err != nil {
+			return imported, fmt.Errorf("problem decoding record %d to type %T: %w", read+1, payload, err)
+		}
+		data, err := q.codec.Marshal(payload)
+		if err != nil {
+			return imported, fmt.Errorf("unable to marshal data of type %T: %w", payload, err)
+		}
+		if q.insertLimiter != nil {
+			if err := q.insertLimiter.take(1); err != nil {
+				return imported, err
+			}
+		}
+		read++
+		batch = append(batch, data)
+		// Rate limiting only happens between batches, keep them small enough to honour it
+		if len(batch) >= opts.BatchSize || (opts.RatePerSecond > 0 && len(batch) >= opts.RatePerSecond) {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	q.checkEmpty()
+	return imported, nil
+}
+
+// Insert already encoded payloads in a single transaction, returning how many were inserted
+// rather than skipped as duplicates
+func (q *Queue[T]) insertBatch(batch [][]byte, opts ...InsertOption) (int, error) {
+	var ts transitions
+	q.lock.Lock()
+	err := q.insertBatchTx(batch, &ts, opts...)
+	q.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	q.notifyTransitions(ts)
+	// Every inserted event is recorded as a transition, skipped ones aren't
+	return len(ts), nil
+}
+
+func (q *Queue[T]) insertBatchTx(batch [][]byte, ts *transitions, opts ...InsertOption) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	for _, data := range batch {
+		if err := q.insertEncoded(tx, newEnvelope(data, opts...), ts); err != nil {
+			return fmt.Errorf("problem inserting event to queue: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return fmt.Errorf("problem commiting batch of %d events: %w", len(batch), err)
+	}
+	return nil
+}
+
+func ndjsonRecords(r io.Reader) func() ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineBytes)
+	return func() ([]byte, error) {
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			return line, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+// Rows of r as JSON objects, with each value typed by the kind schema gives the column
+func csvRecords(r io.Reader, schema PayloadSchema) func() ([]byte, error) {
+	reader := csv.NewReader(r)
+	var header []string
+	return func() ([]byte, error) {
+		if header == nil {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return nil, io.EOF
+			} else if err != nil {
+				return nil, err
+			}
+			header = row
+		}
+		row, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(header) {
+			return nil, errors.New("row does not have the same number of fields as the header")
+		}
+		object := make(map[string]json.RawMessage, len(header))
+		for i, name := range header {
+			kind := csvColumnKind(schema, name)
+			if row[i] == "" && kind != "" && kind != SCHEMA_STRING {
+				// Keep the field's zero value rather than failing to decode ""
+				continue
+			}
+			value, err := csvValue(kind, row[i])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", name, err)
+			}
+			object[name] = value
+		}
+		return json.Marshal(object)
+	}
+}
+
+// The kind of the top-level field of schema that column fills, matched case-insensitively
+// like encoding/json does. Empty when the payload type has no such field
+func csvColumnKind(schema PayloadSchema, column string) string {
+	if kind, ok := schema[column]; ok {
+		return kind
+	}
+	for path, kind := range schema {
+		if !strings.ContainsAny(path, ".[{") && strings.EqualFold(path, column) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// A CSV value encoded as JSON of kind, inferred from the value itself when kind is empty
+// or SCHEMA_ANY
+func csvValue(kind string, value string) (json.RawMessage, error) {
+	switch kind {
+	case SCHEMA_STRING:
+		return json.Marshal(value)
+	case SCHEMA_INTEGER, SCHEMA_NUMBER, SCHEMA_BOOLEAN, SCHEMA_OBJECT, SCHEMA_ARRAY:
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("%q is not a valid %s", value, kind)
+		}
+		return json.RawMessage(value), nil
+	default:
+		if value != "" && json.Valid([]byte(value)) {
+			return json.RawMessage(value), nil
+		}
+		return json.Marshal(value)
+	}
+}
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportNDJSON(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	input := "{\"A\":\"one\"}\n\n{\"A\":\"two\"}\n{\"A\":\"three\"}\n"
+	progress := []int{}
+	imported, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{
+		BatchSize: 2,
+		Progress:  func(n int) { progress = append(progress, n) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 3 {
+		t.Fatalf("expected 3 events imported, got %d", imported)
+	}
+	if len(progress) != 2 || progress[1] != 3 {
+		t.Fatalf("unexpected progress reports: %v", progress)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if event.Content.A != "one" {
+		t.Fatal()
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	type Test struct {
+		Name string `json:"name"`
+		City string `json:"city"`
+	}
+	q := newTestQueue[Test](t)
+
+	input := "name,city\nada,london\ngrace,new york\n"
+	imported, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected 2 events imported, got %d", imported)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if event.Content.Name != "ada" || event.Content.City != "london" {
+		t.Fatalf("unexpected payload: %+v", event.Content)
+	}
+}
+
+func TestImportCSVTypesColumns(t *testing.T) {
+	type Test struct {
+		Name   string  `json:"name"`
+		Age    int     `json:"age"`
+		Score  float64 `json:"score"`
+		Active bool    `json:"active"`
+		Zip    string  `json:"zip"`
+	}
+	q := newTestQueue[Test](t)
+
+	input := "name,age,score,active,zip\nada,36,9.5,true,01234\ngrace,,,false,\n"
+	if _, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if *event.Content != (Test{Name: "ada", Age: 36, Score: 9.5, Active: true, Zip: "01234"}) {
+		t.Fatalf("unexpected payload: %+v", event.Content)
+	}
+	event, err = q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if *event.Content != (Test{Name: "grace"}) {
+		t.Fatalf("expected empty cells to leave zero values, got %+v", event.Content)
+	}
+
+	input = "name,age\nlinus,old\n"
+	if _, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV}); err == nil {
+		t.Fatal("expected a value that isn't a number to fail in a numeric column")
+	}
+}
+
+func TestImportCSVInfersUntypedColumns(t *testing.T) {
+	q := newTestQueue[json.RawMessage](t)
+
+	input := "name,age\nada,36\n"
+	if _, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if string(*event.Content) != `{"age":36,"name":"ada"}` {
+		t.Fatalf("unexpected payload: %s", *event.Content)
+	}
+}
+
+func TestImportCountsOnlyInsertedEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithContentDedup()
+
+	input := "{\"A\":\"one\"}\n{\"A\":\"one\"}\n{\"A\":\"two\"}\n"
+	imported, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected the duplicate not to be counted, got %d", imported)
+	}
+}
+
+func TestImportHonoursInsertRateLimit(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithInsertRateLimit(0.001, 2, THROTTLE_REJECT)
+
+	input := "{\"A\":\"one\"}\n{\"A\":\"two\"}\n{\"A\":\"three\"}\n"
+	imported, err := q.Import(context.Background(), strings.NewReader(input), ImportOptions{BatchSize: 1})
+	if !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected the import to be throttled, got %v", err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected the events within the burst to be imported, got %d", imported)
+	}
+	if stats := q.InsertThrottleStats(); stats.Rejected != 1 {
+		t.Fatalf("expected one rejected insert, got %+v", stats)
+	}
+}
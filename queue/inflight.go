@@ -0,0 +1,13 @@
+package queue
+
+// Only claim while fewer events than the limit are in flight
+const MAX_IN_FLIGHT_CONDITION = `(SELECT COUNT(*) FROM queue_inflight) < ?`
+
+// Claim at most n events at once across every process consuming the queue, e.g. to protect
+// a downstream that only tolerates a fixed number of concurrent jobs. The limit is enforced
+// by the claim query, so Next and Lease return nothing while n events are in flight. Events
+// whose claim expired count until maintenance reclaims them. 0 removes the limit
+func (q *Queue[T]) WithMaxInFlight(n int) *Queue[T] {
+	q.maxInFlight = max(n, 0)
+	return q
+}
@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	type Test struct{ A string }
+	// Without background maintenance, which would hold the database while it is reopened
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithMaxInFlight(2)
+	// A second consumer of the same database with its own handle
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	other, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.WithMaxInFlight(2)
+
+	for _, a := range []string{"one", "two", "three", "four"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := q.Next()
+	if err != nil || first == nil {
+		t.Fatalf("expected an event, got %v: %v", first, err)
+	}
+	events, err := other.Lease(3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected the lease to stop at the limit, got %d events", len(events))
+	}
+	if event, err := q.Next(); err != nil || event != nil {
+		t.Fatalf("expected nothing while 2 events are in flight, got %v: %v", event, err)
+	}
+
+	if err := q.Ack(first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if event, err := q.Next(); err != nil || event == nil {
+		t.Fatalf("expected an event once below the limit, got %v: %v", event, err)
+	}
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Names the interceptors that rewrote an event's payload, comma separated
+const INTERCEPTED_BY_HEADER = "intercepted-by"
+
+const REWRITE_PAYLOAD_QUERY = `UPDATE queue_inflight SET payload = ?, headers = ? WHERE id = ?`
+
+// Rewrites the encoded payload of an event as it is claimed, e.g. to patch a bad URL in a
+// backlog of webhook events. Returns the payload unchanged to leave the event alone
+type PayloadInterceptor func(envelope Envelope) ([]byte, error)
+
+type namedInterceptor struct {
+	name        string
+	interceptor PayloadInterceptor
+}
+
+// Run interceptor on the payload of every event claimed through this Queue, before it is
+// decoded, so systematic payload problems can be fixed during an incident without exporting,
+// editing and re-importing the backlog. Safe to call while consumers are running. A rewritten
+// payload is stored in place of the original, so retries and dead letters see it too, and
+// name is added to the event's INTERCEPTED_BY_HEADER header. Interceptors run in the order
+// they were registered, registering name again replaces its interceptor and a nil
+// interceptor removes it. An interceptor that fails is logged and skipped for that event
+func (q *Queue[T]) WithPayloadInterceptor(name string, interceptor PayloadInterceptor) *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	interceptors := slices.DeleteFunc(slices.Clone(q.interceptors), func(i namedInterceptor) bool { return i.name == name })
+	if interceptor != nil {
+		interceptors = append(interceptors, namedInterceptor{name, interceptor})
+	}
+	q.interceptors = interceptors
+	return q
+}
+
+// An interceptor replacing every occurrence of old with new in the encoded payload, e.g.
+// ReplacePayloadText("https://old.example.com/", "https://new.example.com/"). Note that
+// encoding/json escapes <, > and & in strings
+func ReplacePayloadText(old, new string) PayloadInterceptor {
+	return func(envelope Envelope) ([]byte, error) {
+		return bytes.ReplaceAll(envelope.Payload, []byte(old), []byte(new)), nil
+	}
+}
+
+// Run the registered interceptors on a claimed event and store its payload if they changed
+// it. Callers must hold q.lock
+func (q *Queue[T]) interceptPayload(tx *sql.Tx, envelope *Envelope) error {
+	original := envelope.Payload
+	applied := []string{}
+	for _, i := range q.interceptors {
+		payload, err := i.interceptor(*envelope)
+		if err != nil {
+			q.logger().Error(fmt.Sprintf("payload interceptor %s failed on event %d: %v", i.name, envelope.Id, err))
+			continue
+		}
+		if !bytes.Equal(payload, envelope.Payload) {
+			envelope.Payload = payload
+			applied = append(applied, i.name)
+		}
+	}
+	if bytes.Equal(envelope.Payload, original) {
+		return nil
+	}
+	headers := maps.Clone(envelope.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	names := []string{}
+	if previous := headers[INTERCEPTED_BY_HEADER]; previous != "" {
+		names = strings.Split(previous, ",")
+	}
+	for _, name := range applied {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	headers[INTERCEPTED_BY_HEADER] = strings.Join(names, ",")
+	encoded, err := encodeHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(REWRITE_PAYLOAD_QUERY, string(envelope.Payload), encoded, envelope.Id); err != nil {
+		return fmt.Errorf("problem storing intercepted payload of event %d: %w", envelope.Id, err)
+	}
+	envelope.Headers = headers
+	return nil
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPayloadInterceptorRewritesClaimedEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	for _, a := range []string{"https://old.example.com/hook", "https://other.example.com/hook"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.WithPayloadInterceptor("broken", func(Envelope) ([]byte, error) {
+		return nil, errors.New("broken interceptor")
+	})
+	q.WithPayloadInterceptor("fix-url", ReplacePayloadText("old.example.com", "new.example.com"))
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if event.Content.A != "https://new.example.com/hook" {
+		t.Fatalf("expected the payload to be rewritten, got %s", event.Content.A)
+	}
+	if event.Envelope.Headers[INTERCEPTED_BY_HEADER] != "fix-url" {
+		t.Fatalf("expected the event to name its interceptor, got %v", event.Envelope.Headers)
+	}
+	untouched, err := q.Next()
+	if err != nil || untouched == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if untouched.Content.A != "https://other.example.com/hook" || untouched.Envelope.Headers[INTERCEPTED_BY_HEADER] != "" {
+		t.Fatalf("expected other events to be left alone, got %+v", untouched)
+	}
+
+	// The rewrite is stored, so retries see it after the interceptor is removed
+	q.WithPayloadInterceptor("broken", nil)
+	q.WithPayloadInterceptor("fix-url", nil)
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := q.Peek(event.Id)
+	if err != nil || stored == nil {
+		t.Fatalf("expected the event to be stored, got %v", err)
+	}
+	if string(stored.Payload) != `{"A":"https://new.example.com/hook"}` || stored.Headers[INTERCEPTED_BY_HEADER] != "fix-url" {
+		t.Fatalf("expected the rewritten payload to be stored, got %s %v", stored.Payload, stored.Headers)
+	}
+}
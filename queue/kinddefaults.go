@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"maps"
+	"slices"
+)
+
+// Apply opts to every event of kind inserted through this Queue with Insert, InsertBatch or
+// InsertReserved, before the options passed to the insert so those take precedence. Keeps
+// option lists such as expiry, headers, tags or resource tokens in one place instead of
+// repeated by every producer, as well as the settings kinds need that differ from the
+// queue's, see WithEventMaxRetries, WithEventClaimTimeout and WithEventRetention. Events are
+// delivered in insert order, there are no priorities to default. Registering defaults for
+// a kind again replaces them, no opts removes them
+func (q *Queue[T]) WithKindDefaults(kind string, opts ...InsertOption) *Queue[T] {
+	defaults := maps.Clone(q.kindDefaults)
+	if defaults == nil {
+		defaults = map[string][]InsertOption{}
+	}
+	if len(opts) > 0 {
+		defaults[kind] = slices.Clone(opts)
+	} else {
+		delete(defaults, kind)
+	}
+	q.kindDefaults = defaults
+	return q
+}
+
+// An event's envelope as it will be inserted, with the defaults of its kind applied first
+func (q *Queue[T]) newEnvelope(payload []byte, opts ...InsertOption) Envelope {
+	envelope := newEnvelope(payload, opts...)
+	defaults, ok := q.kindDefaults[envelope.Kind]
+	if !ok {
+		return envelope
+	}
+	return newEnvelope(payload, append(slices.Clone(defaults), opts...)...)
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKindDefaultsAppliedOnInsert(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithKindDefaults("otp",
+		WithExpiresIn(5*time.Minute),
+		WithHeaders(map[string]string{"channel": "sms"}),
+		WithTags("auth"))
+
+	if err := q.Insert(Test{A: "code"}, WithKind("otp"), WithHeaders(map[string]string{"channel": "email"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "other"}, WithKind("report")); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if event.Envelope.ExpiresAt.IsZero() || len(event.Envelope.Tags) != 1 || event.Envelope.Tags[0] != "auth" {
+		t.Fatalf("expected the kind's defaults to be applied, got %+v", event.Envelope)
+	}
+	if event.Envelope.Headers["channel"] != "email" {
+		t.Fatalf("expected the insert's options to take precedence, got %v", event.Envelope.Headers)
+	}
+
+	event, err = q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if !event.Envelope.ExpiresAt.IsZero() || len(event.Envelope.Tags) != 0 {
+		t.Fatalf("expected other kinds to be left alone, got %+v", event.Envelope)
+	}
+}
+
+func TestKindDefaultsAppliedToBatchesAndRemovable(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithKindDefaults("import", WithSchemaVersion(3))
+
+	if _, err := q.InsertBatch([]Test{{A: "a"}, {A: "b"}}, WithKind("import")); err != nil {
+		t.Fatal(err)
+	}
+	q.WithKindDefaults("import")
+	if err := q.Insert(Test{A: "c"}, WithKind("import")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{3, 3, 0} {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		if event.Envelope.SchemaVersion != expected {
+			t.Fatalf("expected schema version %d for %s, got %d", expected, event.Content.A, event.Envelope.SchemaVersion)
+		}
+	}
+}
+
+func TestKindDefaultsOverrideQueueSettings(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithClaimTimeout(time.Hour).WithKindDefaults("fragile",
+		WithEventMaxRetries(0),
+		WithEventClaimTimeout(2*time.Second),
+		WithEventRetention(time.Hour))
+
+	for _, kind := range []string{"fragile", "fragile", "sturdy"} {
+		if err := q.Insert(Test{A: kind}, WithKind(kind)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var remaining int
+	for _, id := range []int{1, 2, 3} {
+		if event, err := q.Next(); err != nil || event == nil || event.Id != id {
+			t.Fatalf("expected event %d, got %v: %v", id, event, err)
+		}
+	}
+	err := q.db.QueryRow("SELECT unixepoch(claim_expires) - unixepoch() FROM queue_inflight WHERE id = 1").Scan(&remaining)
+	if err != nil || remaining > 2 {
+		t.Fatalf("expected the kind's claim timeout, got %d seconds left: %v", remaining, err)
+	}
+	err = q.db.QueryRow("SELECT unixepoch(claim_expires) - unixepoch() FROM queue_inflight WHERE id = 3").Scan(&remaining)
+	if err != nil || remaining < 3500 {
+		t.Fatalf("expected the queue's claim timeout for other kinds, got %d seconds left: %v", remaining, err)
+	}
+
+	// Dead lettered after its first failure, while other kinds get the queue's retries
+	for _, id := range []int{1, 3} {
+		if err := q.Nack(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dead, err := q.Peek(1); err != nil || dead.State != EVENT_STATE_DEAD {
+		t.Fatalf("expected the fragile event to be dead, got %+v: %v", dead, err)
+	}
+	if pending, err := q.Peek(3); err != nil || pending.State != EVENT_STATE_PENDING {
+		t.Fatalf("expected the sturdy event to be retried, got %+v: %v", pending, err)
+	}
+
+	// Kept after acking although the queue has no grace period
+	if err := q.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := q.Peek(2); err != nil || completed == nil || completed.State != EVENT_STATE_COMPLETED || completed.Retention != time.Hour {
+		t.Fatalf("expected the acked event to be retained, got %+v: %v", completed, err)
+	}
+}
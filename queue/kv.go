@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Small values stored next to the queue, see Queue.KV and Queue.EventKV
+const CREATE_KV_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_kv (
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value BLOB NOT NULL,
+    updated_at TEXT DEFAULT (datetime('now', 'utc')),
+    PRIMARY KEY (scope, key)
+);
+`
+
+const KV_GET_QUERY = `SELECT value FROM queue_kv WHERE scope = ? AND key = ?`
+
+const KV_SET_QUERY = `INSERT INTO queue_kv (scope, key, value) VALUES (?, ?, ?)
+ON CONFLICT (scope, key) DO UPDATE SET value = excluded.value, updated_at = datetime('now', 'utc')`
+
+const KV_DELETE_QUERY = `DELETE FROM queue_kv WHERE scope = ? AND key = ?`
+
+const KV_DELETE_SCOPE_QUERY = `DELETE FROM queue_kv WHERE scope = ?`
+
+// The scope of values shared by every event
+const GLOBAL_KV_SCOPE = "global"
+
+// A key-value store in the queue's database, for handlers of long jobs to checkpoint their
+// progress and resume from it after a redelivery. Values are opaque bytes
+type KV struct {
+	db    *sql.DB
+	lock  *sync.RWMutex
+	scope string
+}
+
+// Values shared by every event of the queue, kept until deleted
+func (q *Queue[T]) KV() KV {
+	return KV{db: q.db, lock: &q.lock, scope: GLOBAL_KV_SCOPE}
+}
+
+// Values belonging to the event with id, deleted in the same transaction that acks it so a
+// redelivered event finds the checkpoints of earlier attempts and a completed one leaves
+// nothing behind. They survive nacks, releases and dead lettering
+func (q *Queue[T]) EventKV(id int) KV {
+	return KV{db: q.db, lock: &q.lock, scope: eventKVScope(id)}
+}
+
+// The value stored under key, and whether there is one
+func (kv KV) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	kv.lock.RLock()
+	err := kv.db.QueryRow(KV_GET_QUERY, kv.scope, key).Scan(&value)
+	kv.lock.RUnlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("problem reading %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// Store value under key, replacing what was stored before
+func (kv KV) Set(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	kv.lock.Lock()
+	_, err := kv.db.Exec(KV_SET_QUERY, kv.scope, key, value)
+	kv.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("problem storing %s: %w", key, err)
+	}
+	return nil
+}
+
+// Remove key, a no-op if nothing is stored under it
+func (kv KV) Delete(key string) error {
+	kv.lock.Lock()
+	_, err := kv.db.Exec(KV_DELETE_QUERY, kv.scope, key)
+	kv.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("problem deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+func eventKVScope(id int) string {
+	return "event:" + strconv.Itoa(id)
+}
+
+// Delete the values of the event with id, part of acking it
+func deleteEventKV(tx *sql.Tx, id int) error {
+	if _, err := tx.Exec(KV_DELETE_SCOPE_QUERY, eventKVScope(id)); err != nil {
+		return fmt.Errorf("problem deleting the event's values: %w", err)
+	}
+	return nil
+}
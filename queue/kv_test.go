@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestKVSetGetDelete(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	kv := q.KV()
+
+	if _, ok, err := kv.Get("cursor"); err != nil || ok {
+		t.Fatalf("expected no value yet, got %v: %v", ok, err)
+	}
+	for _, value := range []string{"1", "2"} {
+		if err := kv.Set("cursor", []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, ok, err := kv.Get("cursor")
+	if err != nil || !ok || string(value) != "2" {
+		t.Fatalf("expected the latest value, got %q %v: %v", value, ok, err)
+	}
+	if err := kv.Delete("cursor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := kv.Get("cursor"); err != nil || ok {
+		t.Fatalf("expected the value to be deleted, got %v: %v", ok, err)
+	}
+}
+
+func TestEventKVSurvivesRedeliveryAndIsDeletedOnAck(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	if err := q.Insert(Test{A: "long job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if err := q.EventKV(event.Id).Set("progress", []byte("50")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.KV().Set("progress", []byte("global")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Release(event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events in another scope don't see the checkpoint
+	if _, ok, err := q.EventKV(event.Id + 1).Get("progress"); err != nil || ok {
+		t.Fatalf("expected scopes to be separate, got %v: %v", ok, err)
+	}
+	value, ok, err := q.EventKV(event.Id).Get("progress")
+	if err != nil || !ok || string(value) != "50" {
+		t.Fatalf("expected the checkpoint to survive the nack, got %q %v: %v", value, ok, err)
+	}
+
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := q.EventKV(event.Id).Get("progress"); err != nil || ok {
+		t.Fatalf("expected the checkpoint to be deleted with the ack, got %v: %v", ok, err)
+	}
+	if _, ok, err := q.KV().Get("progress"); err != nil || !ok {
+		t.Fatalf("expected global values to be kept, got %v: %v", ok, err)
+	}
+}
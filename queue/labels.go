@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// Attach static labels to the queue, e.g. service, env and team, so deployments with many
+// queues can be sliced by ownership. They are added to the queue's log lines, the statistics
+// served by GrafanaHandler and every envelope handed to hooks, middleware and handlers, see
+// Envelope.QueueLabels
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		if o.labels == nil {
+			o.labels = map[string]string{}
+		}
+		maps.Copy(o.labels, labels)
+	}
+}
+
+// The labels the queue was opened WithLabels, nil if there are none
+func (q *Queue[T]) Labels() map[string]string {
+	return maps.Clone(q.labels)
+}
+
+// The default logger with the queue's labels attached, grouped under "labels"
+func (q *Queue[T]) logger() *slog.Logger {
+	if len(q.labels) == 0 {
+		return slog.Default()
+	}
+	attrs := []any{}
+	for _, key := range slices.Sorted(maps.Keys(q.labels)) {
+		attrs = append(attrs, slog.String(key, q.labels[key]))
+	}
+	return slog.Default().With(slog.Group("labels", attrs...))
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	type Test struct{ A string }
+	labels := map[string]string{"service": "billing", "team": "payments"}
+	q := newTestQueue[Test](t, WithLabels(labels))
+	labels["service"] = "changed"
+	if got := q.Labels(); got["service"] != "billing" || got["team"] != "payments" {
+		t.Fatalf("expected the labels passed at construction, got %v", got)
+	}
+
+	var hooked map[string]string
+	q.WithOnTransition(func(_ Transition, envelope Envelope) {
+		hooked = envelope.QueueLabels
+	})
+	if err := q.Insert(Test{A: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if hooked["team"] != "payments" {
+		t.Fatalf("expected hooks to see the labels, got %v", hooked)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if event.Envelope.QueueLabels["service"] != "billing" {
+		t.Fatalf("expected handlers to see the labels, got %v", event.Envelope.QueueLabels)
+	}
+	peeked, err := q.Peek(event.Id)
+	if err != nil || peeked == nil || peeked.QueueLabels["service"] != "billing" {
+		t.Fatalf("expected Peek to include the labels, got %v: %v", peeked, err)
+	}
+
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(previous)
+	q.logger().Info("hello")
+	if !strings.Contains(logs.String(), "labels.service=billing labels.team=payments") {
+		t.Fatalf("expected the labels in log lines, got %q", logs.String())
+	}
+}
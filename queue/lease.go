@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The outcome of processing a leased event, passed back to CompleteLease
+type LeaseResult struct {
+	Id int
+	// nil if the event was processed successfully, otherwise the reason it failed
+	Err error
+}
+
+// Claim up to n events at once for ttl, intended for stateless functions that handle a
+// single batch per invocation. Maintenance, i.e. reclaiming expired claims and dead
+// lettering exhausted and expired events, runs as part of the call so no background
+// maintenance is required for leases to make progress. Returns an empty slice when
+// nothing is available.
+func (q *Queue[T]) Lease(n int, ttl time.Duration) ([]*Event[T], error) {
+	if n < 0 {
+		return nil, fmt.Errorf("unable to lease %d events, n must not be negative", n)
+	}
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	defer q.startRegion(context.Background(), TRACE_REGION_CLAIM)()
+	var ts transitions
+	q.lock.Lock()
+	maintenanceErr := q.maintainInline(&ts)
+	events, err := q.lease(n, ttl, &ts)
+	q.lock.Unlock()
+	q.afterMaintenance(maintenanceErr)
+	q.notifyTransitions(ts)
+	return events, err
+}
+
+// Run maintenance regardless of when it last ran, for calls that can't rely on background
+// maintenance. Failures are logged and returned for afterMaintenance rather than failing the
+// call. Callers must hold q.lock
+func (q *Queue[T]) maintainInline(ts *transitions) error {
+	q.lastMaintenance = time.Now()
+	err := q.maintain(ts)
+	if err != nil {
+		q.logger().Error(err.Error())
+	}
+	return err
+}
+
+func (q *Queue[T]) lease(n int, ttl time.Duration, ts *transitions) ([]*Event[T], error) {
+	if err := q.releasePreviousEpochsOnce(ts); err != nil {
+		return nil, err
+	}
+	maintained := len(*ts)
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	events := make([]*Event[T], 0, n)
+	for len(events) < n {
+		event, err := q.claimNext(tx, ttl, eventFilter{}, ts)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			break
+		}
+		events = append(events, event)
+	}
+	if err := tx.Commit(); err != nil {
+		// Only what maintenance and releasing previous epochs did was committed
+		*ts = (*ts)[:maintained]
+		return nil, fmt.Errorf("problem commiting lease of %d events: %w", len(events), err)
+	}
+	return events, nil
+}
+
+// Ack every successful result and nack every failed one from a previous Lease in a
+// single transaction, then run maintenance like Lease does
+func (q *Queue[T]) CompleteLease(results []LeaseResult) error {
+	var ts transitions
+	q.lock.Lock()
+	err := q.completeLease(results, &ts)
+	var maintenanceErr error
+	if err == nil {
+		maintenanceErr = q.maintainInline(&ts)
+	}
+	q.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	q.afterMaintenance(maintenanceErr)
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) completeLease(results []LeaseResult, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	for _, result := range results {
+		if result.Err == nil {
+			if err := q.ack(tx, result.Id, q.purgeTime(), ts); err != nil {
+				return fmt.Errorf("unable to ack event: %d: %w", result.Id, err)
+			}
+			continue
+		}
+		if _, err := q.nackTx(tx, result.Id, ts); err != nil {
+			return fmt.Errorf("unable to nack event: %d: %w", result.Id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return fmt.Errorf("problem commiting results of lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := q.Lease(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 leased events, got %d", len(events))
+	}
+	if events[0].Content.A != "one" || events[1].Content.A != "two" {
+		t.Fatal("events were not leased in order")
+	}
+
+	err = q.CompleteLease([]LeaseResult{
+		{Id: events[0].Id},
+		{Id: events[1].Id, Err: errors.New("failed")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The acked event is gone, the nacked one is still there
+	if size, _ := q.Size(); size != 2 {
+		t.Fatalf("expected size 2, got %d", size)
+	}
+	remaining, err := q.Lease(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nacked event is in its backoff period
+	if len(remaining) != 1 || remaining[0].Content.A != "three" {
+		t.Fatal("expected only the untouched event to be leased")
+	}
+}
+
+func TestLeaseRunsMaintenance(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance())
+
+	if _, err := q.Lease(-1, time.Minute); err == nil {
+		t.Fatal("expected leasing a negative number of events to fail")
+	}
+	if err := q.Insert(Test{A: "exhausted"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "expired"}, WithExpiresAt(time.Now().Add(-time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec("UPDATE queue SET retries = 5 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	q.WithMaxRetires(2)
+	events, err := q.Lease(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected nothing to be leased, got %d events", len(events))
+	}
+	if dead, err := q.DeadSize(); err != nil || dead != 2 {
+		t.Fatalf("expected the exhausted and the expired event to be dead lettered, got %d: %v", dead, err)
+	}
+}
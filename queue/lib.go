@@ -1,13 +1,19 @@
 package queue
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/tursodatabase/go-libsql"
@@ -18,13 +24,79 @@ type Queue[T any] struct {
 	retryBackoffSeconds int
 	maxRetries          int
 	location            string
-	claimTimeoutSeconds int
-	lock                sync.RWMutex
+	claimTimeout        time.Duration
+	clock               claimClock
+	codec               Codec
+	contentDedup        bool
+	tracing             bool
+	archive             bool
+	hashChain           bool
+	ackGracePeriod      time.Duration
+	deliveryWindow      *DeliveryWindow
+	maxInFlight         int
+	resourceCapacities  map[string]int
+	// See WithIdempotentRetries
+	idempotentRetries int
+	idempotentBackoff time.Duration
+	workerID          string
+	labels            map[string]string
+	epoch             string
+	// Worker id whose claims from previous epochs were released, see releasePreviousEpochsOnce
+	epochsReleasedFor  string
+	idCodec            IDCodec
+	pausedInsertPolicy PausedInsertPolicy
+	// Set once a sub-queue filtered by kind, see AdviseIndexes
+	kindFiltered  atomic.Bool
+	insertLimiter *tokenBucket
+	lock          sync.RWMutex
+
+	// See WithSynchronousMaintenance
+	synchronousMaintenance bool
+	lastMaintenance        time.Time
+
+	// Closed by Close to stop background maintenance
+	stop   chan struct{}
+	closed sync.Once
+	// Key of the queue in sharedQueues, see NewSharedLocalQueue
+	sharedKey string
+
+	hookLock   sync.Mutex
+	onEmpty    func()
+	onNonEmpty func()
+	emptyState emptyState
+
+	health      healthBreaker
+	maintenance maintenanceHealth
+	drift       clockDrift
+	snapshot    *readSnapshot
+
+	onTransition func(Transition, Envelope)
+	middleware   []Middleware[T]
+
+	// See WithShadow
+	shadow        *Queue[T]
+	shadowPercent float64
+	// See WithKindDefaults
+	kindDefaults map[string][]InsertOption
+	// See WithPayloadInterceptor
+	interceptors []namedInterceptor
+	// See WithDecodeQuarantine
+	decodeQuarantine bool
+	// See WithMaxEventAge
+	maxEventAge time.Duration
+	// See WithFreshEventShare
+	freshEvery int
+	claims     int
+
+	// Called once each batch inserted with InsertBatch is complete
+	onBatchComplete func(BatchStatus)
 }
 
 type Event[T any] struct {
 	Id      int
 	Content *T
+	// Everything else stored with the event
+	Envelope Envelope
 }
 
 const CREATE_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue (
@@ -46,13 +118,18 @@ const CREATE_UNCLAIMED_INDEX_STATEMENT = `CREATE INDEX IF NOT EXISTS idx_unclaim
 // The database is persisted on the filesystem and if a new process
 // Attempts to create a queue with the name name, the backing libsql database will be reused.
 // A default retry_backoff is configured at 5s and a maximum retries of 1000
-func NewLocalQueue[T any](name string) (*Queue[T], error) {
+// The name must be a valid QueueName. Opening a queue that is already open in this process
+// with a different payload type fails with a *PayloadTypeCollisionError
+func NewLocalQueue[T any](name string, opts ...Option) (*Queue[T], error) {
+	if err := QueueName(name).Validate(); err != nil {
+		return nil, err
+	}
 	// Create a .db dir if it doesn't already exists
 	if err := os.MkdirAll(".db", 0775); err != nil {
 		return nil, err
 	}
 	dbUrl := "file:.db/" + name + ".db"
-	return newQueueWithDefaults[T](dbUrl)
+	return newQueueWithDefaults[T](dbUrl, opts...)
 }
 
 // Creates a new libsql database called "<name>.db" in $(cwd)/.db
@@ -62,7 +139,7 @@ func NewLocalQueue[T any](name string) (*Queue[T], error) {
 // The database is persisted on the filesystem and if a new process
 // Attempts to create a queue with the name name, the backing libsql database will be reused.
 // A default retry_backoff is configured at 5s and a maximum retries of 1000
-func NewTursoQueue[T any]() (*Queue[T], error) {
+func NewTursoQueue[T any](opts ...Option) (*Queue[T], error) {
 	// Get database URL and auth token from environment variables
 	dbUrl := os.Getenv("TURSO_URL")
 	if dbUrl == "" {
@@ -80,22 +157,149 @@ func NewTursoQueue[T any]() (*Queue[T], error) {
 	if remoteEncryptionKey != "" {
 		dbUrl += sep + "remoteEncryptionKey=" + remoteEncryptionKey
 	}
-	return newQueueWithDefaults[T](dbUrl)
+	return newQueueWithDefaults[T](dbUrl, opts...)
+}
+
+// Configures how a queue is opened, passed to NewLocalQueue and NewTursoQueue. Settings
+// that can change after the queue is open are configured with the With* methods on Queue
+type Option func(*options)
+
+type options struct {
+	synchronousMaintenance bool
+	strictSchema           bool
+	preset                 *Preset
+	encryptionKey          string
+	authTokenProvider      AuthTokenProvider
+	epochClaims            bool
+	snapshotPath           string
+	snapshotInterval       time.Duration
+	labels                 map[string]string
+	payloadTypeOverride    bool
+}
+
+// Don't start the background goroutine that reclaims expired claims and dead letters
+// exhausted events. Instead this maintenance runs inside Insert and Next calls, at most
+// once per claim timeout. Intended for environments where background goroutines are
+// undesirable, e.g. WASM or serverless functions that are frozen between invocations.
+func WithSynchronousMaintenance() Option {
+	return func(o *options) {
+		o.synchronousMaintenance = true
+	}
+}
+
+// Check the schema when the queue is opened and fail with an error wrapping
+// ErrSchemaMismatch if it has drifted in a way the queue can't work with, e.g. because
+// another tool changed a column's type or added a required column. Without this option
+// such drift surfaces later as failing inserts, or events that are never delivered.
+func WithStrictSchema() Option {
+	return func(o *options) {
+		o.strictSchema = true
+	}
+}
+
+// Open the database at dbUrl, wrapping its connections as configured by o
+func openDB(dbUrl string, o options) (*sql.DB, error) {
+	switch {
+	case o.encryptionKey != "":
+		if !strings.HasPrefix(dbUrl, "file:") {
+			return nil, fmt.Errorf("encryption keys are only supported for local queues")
+		}
+		connector, err := libsqlConnector(dbUrl)
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(keyedConnector{connector, o.encryptionKey}), nil
+	case o.authTokenProvider != nil:
+		connector, err := newTokenConnector(dbUrl, o.authTokenProvider)
+		if err != nil {
+			return nil, err
+		}
+		db := sql.OpenDB(connector)
+		// Reconnect regularly so refreshed tokens are picked up
+		db.SetConnMaxLifetime(tokenConnMaxLifetime)
+		return db, nil
+	default:
+		return sql.Open("libsql", dbUrl)
+	}
+}
+
+// The libsql driver's connector for dbUrl
+func libsqlConnector(dbUrl string) (driver.Connector, error) {
+	drv, err := libsqlDriver()
+	if err != nil {
+		return nil, err
+	}
+	driverCtx, ok := drv.(driver.DriverContext)
+	if !ok {
+		return nil, fmt.Errorf("libsql driver does not support connectors")
+	}
+	return driverCtx.OpenConnector(dbUrl)
 }
 
-func newQueueWithDefaults[T any](dbUrl string) (*Queue[T], error) {
-	db, err := sql.Open("libsql", dbUrl)
+// The driver registered by go-libsql
+func libsqlDriver() (driver.Driver, error) {
+	db, err := sql.Open("libsql", ":memory:")
 	if err != nil {
 		return nil, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	return db.Driver(), nil
+}
+
+// Create the queue's tables in db if they don't exist yet and migrate them if they do
+func createSchema(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_TABLE_STATEMENT); err != nil {
+		return err
+	}
+	if _, err := db.Exec(CREATE_UNCLAIMED_INDEX_STATEMENT); err != nil {
+		return err
+	}
+	return migrate(db)
+}
 
+func newQueueWithDefaults[T any](dbUrl string, opts ...Option) (*Queue[T], error) {
+	key := payloadTypeKey(dbUrl)
+	if err := claimPayloadType[T](key); err != nil {
+		return nil, err
 	}
-	_, err = db.Exec(CREATE_TABLE_STATEMENT)
+	q, err := openQueue[T](dbUrl, opts...)
 	if err != nil {
+		releasePayloadType(key)
 		return nil, err
 	}
-	_, err = db.Exec(CREATE_UNCLAIMED_INDEX_STATEMENT)
+	return q, nil
+}
+
+func openQueue[T any](dbUrl string, opts ...Option) (*Queue[T], error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	db, err := openDB(dbUrl, o)
 	if err != nil {
 		return nil, err
+
+	}
+	if err := createSchema(db); err != nil {
+		return nil, err
+	}
+	if o.strictSchema {
+		if err := validateSchema(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkPayloadFingerprint[T](db, o.payloadTypeOverride); err != nil {
+		return nil, err
+	}
+	if err := convertClaimExpiry(db, o.epochClaims); err != nil {
+		return nil, err
+	}
+	clock := WALL_CLOCK
+	if o.epochClaims {
+		clock = EPOCH_CLOCK
 	}
 
 	queue := &Queue[T]{
@@ -103,207 +307,821 @@ func newQueueWithDefaults[T any](dbUrl string) (*Queue[T], error) {
 		retryBackoffSeconds: 5,
 		maxRetries:          1000,
 		location:            dbUrl,
-		claimTimeoutSeconds: 30,
+		claimTimeout:        30 * time.Second,
+		clock:               clock,
+		codec:               JSONCodec{},
+		epoch:               newEpoch(),
+		labels:              o.labels,
+		stop:                make(chan struct{}),
+
+		synchronousMaintenance: o.synchronousMaintenance,
+		health:                 healthBreaker{state: HEALTH_HEALTHY},
+		maintenance:            maintenanceHealth{threshold: defaultMaintenanceFailureThreshold},
+		drift:                  clockDrift{threshold: defaultClockDriftThreshold},
+	}
+	if err := queue.loadSettings(); err != nil {
+		return nil, err
+	}
+	if o.preset != nil {
+		queue.applyPreset(*o.preset)
+	}
+	if o.snapshotPath != "" {
+		if queue.snapshot, err = openReadSnapshot(o.snapshotPath, o.snapshotInterval); err != nil {
+			return nil, err
+		}
+		queue.refreshSnapshotIfDue()
 	}
 
-	go queue.startClaimTimeoutCleanup()
+	queue.checkClockDrift()
+
+	if !queue.synchronousMaintenance {
+		go queue.startClaimTimeoutCleanup()
+	}
 
 	return queue, nil
 }
 
-const CLAIM_TIMEOUT_CLEANUP_QUERY = `
-UPDATE queue
-SET claimed = 0, claim_expires = NULL
-WHERE claimed = 1
-AND (claim_expires IS NOT NULL AND claim_expires < datetime('now', 'utc'))
-RETURNING id
-`
-
 // Technically not needed based on how the claim query works
 // But this is inexpensive and makes debugging state easier
 func (q *Queue[T]) startClaimTimeoutCleanup() {
-
 	for {
+		var ts transitions
 		q.lock.Lock()
-		reclaimed_jobs, err := q.db.Query(CLAIM_TIMEOUT_CLEANUP_QUERY)
+		select {
+		case <-q.stop:
+			// Closed while waiting for the lock
+			q.lock.Unlock()
+			return
+		default:
+		}
+		err := q.maintain(&ts)
 		q.lock.Unlock()
 		if err != nil {
-			slog.Error(fmt.Errorf("problem reclaiming jobs from queue after claimTimeout has expired: %w", err).Error())
-			err = reclaimed_jobs.Close()
-			if err != nil {
-				slog.Error(fmt.Errorf("problem closing the reclaimed_jobs pointer: %w", err).Error())
-			}
-			time.Sleep(time.Duration(q.claimTimeoutSeconds) * time.Second)
-			continue
+			q.logger().Error(err.Error())
 		}
-		if reclaimed_jobs == nil {
-			slog.Info("No jobs to reclaim")
-			err = reclaimed_jobs.Close()
-			if err != nil {
-				slog.Error(fmt.Errorf("problem closing the reclaimed_jobs pointer: %w", err).Error())
-			}
-			time.Sleep(time.Duration(q.claimTimeoutSeconds) * time.Second)
-			continue
+		q.afterMaintenance(err)
+		q.notifyTransitions(ts)
+		q.lock.RLock()
+		interval := q.claimTimeout
+		q.lock.RUnlock()
+		select {
+		case <-q.stop:
+			return
+		case <-time.After(interval):
 		}
-		for reclaimed_jobs.Next() {
-			var id int
-			err = reclaimed_jobs.Scan(&id)
-			if err != nil {
-				slog.Error(fmt.Errorf("problem scanning a reclaimed row: %w", err).Error())
-			}
-			slog.Info(fmt.Sprintf("Reclaimed event after claim timeout expiration: %d", id))
+	}
+}
 
-		}
-		err = reclaimed_jobs.Close()
-		if err != nil {
-			slog.Error(fmt.Errorf("problem closing the reclaimed_jobs pointer: %w", err).Error())
-		}
-		time.Sleep(time.Duration(q.claimTimeoutSeconds) * time.Second)
+// Reclaim expired claims and dead letter exhausted events, following the settings stored by
+// other processes. Callers must hold q.lock
+func (q *Queue[T]) maintain(ts *transitions) error {
+	if err := q.loadSettings(); err != nil {
+		return err
+	}
+	if err := q.reclaimExpiredClaims(ts); err != nil {
+		return err
+	}
+	if err := q.reviveRetriable(ts); err != nil {
+		return err
+	}
+	if err := q.deadLetterExhausted(ts); err != nil {
+		return err
 	}
+	if err := q.deadLetterExpired(ts); err != nil {
+		return err
+	}
+	if err := q.deadLetterTooOld(ts); err != nil {
+		return err
+	}
+	if err := q.purgeOperations(); err != nil {
+		return err
+	}
+	return q.purgeCompleted()
+}
+
+// Run maintenance inline when the queue was opened WithSynchronousMaintenance and it
+// hasn't run for a claim timeout, reporting whether it ran. Failures are logged and returned
+// for recordMaintenance rather than failing the operation that happened to trigger
+// maintenance. Callers must hold q.lock
+func (q *Queue[T]) maintainIfDue(ts *transitions) (bool, error) {
+	if !q.synchronousMaintenance || time.Since(q.lastMaintenance) < q.claimTimeout {
+		return false, nil
+	}
+	q.lastMaintenance = time.Now()
+	err := q.maintain(ts)
+	if err != nil {
+		q.logger().Error(err.Error())
+	}
+	return true, err
+}
+
+const CLAIM_TIMEOUT_CLEANUP_CONDITION_TEMPLATE = `claim_expires IS NOT NULL AND claim_expires < %s`
+
+// Make events whose claim has expired available again. Callers must hold q.lock
+func (q *Queue[T]) reclaimExpiredClaims(ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	reclaimed_jobs, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, fmt.Sprintf(CLAIM_TIMEOUT_CLEANUP_CONDITION_TEMPLATE, q.clock.now))
+	if err != nil {
+		return fmt.Errorf("problem reclaiming jobs from queue after claimTimeout has expired: %w", err)
+	}
+	if len(reclaimed_jobs) == 0 {
+		return nil
+	}
+	var reclaimed transitions
+	if err := releaseClaims(tx, reclaimed_jobs, ATTEMPT_TIMED_OUT, &reclaimed); err != nil {
+		return fmt.Errorf("problem reclaiming jobs from queue after claimTimeout has expired: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("problem reclaiming jobs from queue after claimTimeout has expired: %w", err)
+	}
+	for _, id := range reclaimed_jobs {
+		q.logger().Info(fmt.Sprintf("Reclaimed event after claim timeout expiration: %d", id))
+	}
+	*ts = append(*ts, reclaimed...)
+	return nil
+}
+
+// Move pending events that have more retries than currently allowed to the dead letter
+// table, e.g. after WithMaxRetires lowered the limit. Callers must hold q.lock
+func (q *Queue[T]) deadLetterExhausted(ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	ids, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, "retries > "+RETRY_LIMIT_EXPRESSION, q.maxRetries)
+	if err != nil {
+		return fmt.Errorf("problem moving exhausted events to the dead letter table: %w", err)
+	}
+	var dead transitions
+	if err := markDead(tx, ids, DEAD_REASON_MAX_RETRIES, &dead); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*ts = append(*ts, dead...)
+	return nil
 }
 
 // Configure the retry backoff for the queue, i.e how long after a failure
 // Before an event can be retried
 func (q *Queue[T]) WithRetryBackoffSeconds(backoff int) *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
 	q.retryBackoffSeconds = backoff
 	return q
 }
 
-// Configure the maximum number of retires for an event. The event will not be cleaned up from the database, making this effectively a Dead-Letter Queue.
+// Configure the maximum number of retires for an event. Once exhausted the event is moved to the dead letter table
+// where it is kept until removed manually, see DeadSize. Raising the limit returns dead events with retries left
+// to the queue. The limit is stored in the database and applies to every process using the queue
 func (q *Queue[T]) WithMaxRetires(max int) *Queue[T] {
+	var ts transitions
+	q.lock.Lock()
 	q.maxRetries = max
+	q.storeSetting(MAX_RETRIES_SETTING, max)
+	if err := q.reviveRetriable(&ts); err != nil {
+		q.logger().Error(err.Error())
+	}
+	q.lock.Unlock()
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return q
+}
+
+// Configure how payloads are encoded in the database, defaults to JSONCodec. All processes
+// sharing a queue must use compatible codecs
+func (q *Queue[T]) WithCodec(codec Codec) *Queue[T] {
+	q.codec = codec
+	return q
+}
+
+// Skip inserting a payload when an identical payload (compared by a hash of its encoded form)
+// is already waiting or being processed. Pairs well with CanonicalJSONCodec so that equal
+// values always encode to the same bytes. Events that exhausted their retries don't count.
+func (q *Queue[T]) WithContentDedup() *Queue[T] {
+	q.contentDedup = true
+	return q
+}
+
+// Keep acked events in the archive table instead of deleting them, so the history is
+// available to Analytics. Archived events are kept until removed manually. Like the retry
+// limit this is stored in the database and applies to every process using the queue
+func (q *Queue[T]) WithArchive() *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.archive = true
+	q.storeSetting(ARCHIVE_SETTING, true)
 	return q
 }
 
 // Configure how long a process has to process an event before it is made available to be consumed by other processes
 func (q *Queue[T]) WithClaimTimeoutSeconds(timeout int) *Queue[T] {
-	q.claimTimeoutSeconds = timeout
-	return q
+	return q.WithClaimTimeout(time.Duration(timeout) * time.Second)
 }
 
-const INSERT_QUERY_TEMPLATE = `INSERT INTO queue (payload) VALUES ('%s')`
+const INSERT_QUERY_TEMPLATE = `INSERT INTO queue (payload, payload_hash, kind, headers, schema_version, batch_id, parent_id, expires_at, window_start, window_end, window_offset, resources, max_retries, claim_timeout, retention) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const INSERT_WITH_ID_QUERY_TEMPLATE = `INSERT INTO queue (payload, payload_hash, kind, headers, schema_version, batch_id, parent_id, expires_at, window_start, window_end, window_offset, resources, max_retries, claim_timeout, retention, id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const INSERT_UNLESS_DUPLICATE_QUERY_TEMPLATE = `
+INSERT INTO queue (payload, payload_hash, kind, headers, schema_version, batch_id, parent_id, expires_at, window_start, window_end, window_offset, resources, max_retries, claim_timeout, retention)
+SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+WHERE NOT EXISTS (SELECT 1 FROM queue WHERE payload_hash = ? AND retries <= ` + RETRY_LIMIT_EXPRESSION + `)
+AND NOT EXISTS (SELECT 1 FROM queue_inflight WHERE payload_hash = ?)
+`
 
-// Insert an event of type T. This will create an Event with an id field, and the json-serailized
-// string of payload
-func (q *Queue[T]) Insert(payload T) error {
-	data, err := json.Marshal(payload)
+// Insert an event of type T. This will create an Event with an id field, and the serialized
+// string of payload produced by the queue's codec. Options fill in the rest of the event's
+// envelope, e.g. WithKind
+func (q *Queue[T]) Insert(payload T, opts ...InsertOption) error {
+	pausedOpts, err := q.pausedInsertOptions()
 	if err != nil {
-		return fmt.Errorf("unable to marshal data of type %T to json: %w", payload, err)
+		return err
+	}
+	opts = append(opts, pausedOpts...)
+	data, err := q.codec.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal data of type %T: %w", payload, err)
+	}
+	if q.insertLimiter != nil {
+		if err := q.insertLimiter.take(1); err != nil {
+			return err
+		}
 	}
 
+	envelope := q.newEnvelope(data, opts...)
+	var ts transitions
 	q.lock.Lock()
-	defer q.lock.Unlock()
-	_, err = q.db.Exec(fmt.Sprintf(INSERT_QUERY_TEMPLATE, data))
+	maintained, maintenanceErr := q.maintainIfDue(&ts)
+	q.lock.Unlock()
+	afterMaintenance := len(ts)
+	err = q.retryTransient(func(opID string) error {
+		ts = ts[:afterMaintenance]
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.insertOne(envelope, opID, &ts)
+	})
+	if maintained {
+		q.afterMaintenance(maintenanceErr)
+	}
+	q.notifyTransitions(ts)
 	if err != nil {
 		return fmt.Errorf("problem inserting event to queue: %w", err)
 	}
+	q.checkEmpty()
 	return nil
 }
 
+// Insert a single event, in a transaction if its tags or operation id need inserting too.
+// Callers must hold q.lock
+func (q *Queue[T]) insertOne(envelope Envelope, opID string, ts *transitions) error {
+	if len(envelope.Tags) == 0 && opID == "" {
+		return q.insertEncoded(q.db, envelope, ts)
+	}
+	maintained := len(*ts)
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return err
+	}
+	if err := q.insertEncoded(tx, envelope, ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		// Only what maintenance did was committed
+		*ts = (*ts)[:maintained]
+		return err
+	}
+	return nil
+}
+
+// Satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Insert an event whose payload is already encoded, with the envelope's id if it has one.
+// Callers must hold q.lock
+func (q *Queue[T]) insertEncoded(db execer, envelope Envelope, ts *transitions) error {
+	headers, err := encodeHeaders(envelope.Headers)
+	if err != nil {
+		return err
+	}
+	hash := payloadHash(envelope.Payload)
+	query := INSERT_QUERY_TEMPLATE
+	args := []any{string(envelope.Payload), hash, nullString(envelope.Kind), headers, envelope.SchemaVersion, nullString(envelope.Batch), nullInt(envelope.Parent), nullUnixTime(envelope.ExpiresAt)}
+	resources, err := encodeResources(envelope.Resources)
+	if err != nil {
+		return fmt.Errorf("problem encoding resource tokens: %w", err)
+	}
+	args = append(args, envelope.DeliveryWindow.columns(time.Now())...)
+	args = append(args, resources, nullLimit(envelope.MaxRetries), nullSeconds(envelope.ClaimTimeout), nullSeconds(envelope.Retention))
+	switch {
+	case envelope.Id != 0:
+		// Reserved ids are never skipped as duplicates, the caller relies on them existing
+		query = INSERT_WITH_ID_QUERY_TEMPLATE
+		args = append(args, envelope.Id)
+	case q.contentDedup:
+		query = INSERT_UNLESS_DUPLICATE_QUERY_TEMPLATE
+		args = append(args, hash, q.maxRetries, hash)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		// Skipped as a duplicate
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	envelope.Id = int(id)
+	if err := insertTags(db, envelope.Id, envelope.Tags); err != nil {
+		return err
+	}
+	envelope.EnqueuedAt = time.Now().UTC().Truncate(time.Second)
+	ts.add(TRANSITION_INSERTED, envelope)
+	return nil
+}
+
+// Hex encoded sha256 of an encoded payload
+func payloadHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Scanned from the lowest id on every call. A cached watermark of the lowest claimable id
+// would skip events below it that become claimable again with their original ids: nacked
+// events once their backoff has passed and events whose claim expired, including those
+// claimed and nacked by other processes sharing the queue
 const NEXT_JOB_TEMPLATE = `
 SELECT id FROM queue
-WHERE claimed = 0
-AND (claim_expires <= datetime('now', 'utc') OR claim_expires IS NULL)
-AND retries <= :max_retires
+WHERE (claim_expires <= %[1]s OR claim_expires IS NULL)
+AND IFNULL(retries, 0) <= ` + RETRY_LIMIT_EXPRESSION + `
+AND payload IS NOT NULL
+AND ` + NOT_EXPIRED_CONDITION + `%[2]s
 ORDER BY id ASC LIMIT 1
 `
 
 const CLAIM_JOB_QUERY_TEMPLATE = `
-UPDATE queue
+UPDATE queue_inflight
 SET claimed = 1,
-claim_expires = datetime('now', printf('+%d seconds', ?), 'utc')
+claim_expires = %s,
+claimed_by = ?,
+claimed_epoch = ?,
+reassigned_to = NULL,
+attempts = ` + START_ATTEMPT_EXPRESSION + `
 WHERE id = ?
-AND (claimed = 0 OR claim_expires IS NULL OR claim_expires <= datetime('now', 'utc'))
-RETURNING id, payload
-`
+RETURNING ` + ENVELOPE_COLUMNS
 
 // Return the "next" event in the queue, that is, returns the oldest event
 // that was submitted that is not already being processed and is not in the
 // configured retry backoff period
 func (q *Queue[T]) Next() (*Event[T], error) {
+	return q.nextMatching(eventFilter{})
+}
+
+// Next, but only considering events that match filter
+func (q *Queue[T]) nextMatching(filter eventFilter) (*Event[T], error) {
+	if !q.health.allow() {
+		return nil, ErrQueueDegraded
+	}
+	defer q.startRegion(context.Background(), TRACE_REGION_CLAIM)()
+	var ts transitions
 	q.lock.Lock()
-	defer q.lock.Unlock()
+	maintained, maintenanceErr := q.maintainIfDue(&ts)
+	event, err := q.next(filter, &ts)
+	q.lock.Unlock()
+	if maintained {
+		q.afterMaintenance(maintenanceErr)
+	}
+	q.health.record(err)
+	q.notifyTransitions(ts)
+	return event, err
+}
+
+func (q *Queue[T]) next(filter eventFilter, ts *transitions) (*Event[T], error) {
+	if err := q.releasePreviousEpochsOnce(ts); err != nil {
+		return nil, err
+	}
+	recorded := len(*ts)
 	tx, err := q.db.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("problem starting transaction on db %w", err)
 	}
-	defer func() {
-		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
-			slog.Error(fmt.Sprintf("WARNING: tx.Rollback() failed: %v\n", err))
-		}
-	}()
+	defer rollback(tx)
+	event, err := q.claimNext(tx, 0, filter, ts)
+	if err != nil {
+		return nil, err
+	}
+	// Events quarantined on the way stay quarantined when nothing else is available
+	if event == nil && len(*ts) == recorded {
+		return nil, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		*ts = (*ts)[:recorded]
+		return nil, fmt.Errorf("promblem commiting transaction when attempting to claim item from queue: %w", err)
+	}
+	return event, nil
+}
+
+// Rollback tx if it has not been committed, intended to be deferred right after Begin()
+func rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		slog.Error(fmt.Sprintf("WARNING: tx.Rollback() failed: %v\n", err))
+	}
+}
+
+// Claim the oldest available event matching filter within tx for timeout, or for the
+// event's own claim timeout if timeout is 0 and it has one and the queue's otherwise.
+// Returns a nil event when nothing is available
+func (q *Queue[T]) claimNext(tx *sql.Tx, timeout time.Duration, filter eventFilter, ts *transitions) (*Event[T], error) {
+	if q.deliveryWindow != nil && !q.deliveryWindow.Contains(time.Now()) {
+		return nil, nil
+	}
+	// Before the conditions below are added, for trying again after quarantining an event
+	requested := filter
 	var candidate int
-	err = tx.QueryRow(NEXT_JOB_TEMPLATE, sql.Named("max_retires", q.maxRetries)).Scan(&candidate)
+	// Passed through the filter rather than the template, the condition's modulo operators
+	// would be taken for format verbs
+	filter.conditions = append([]string{IN_DELIVERY_WINDOW_CONDITION}, filter.conditions...)
+	filter.conditions = append([]string{NOT_PAUSED_CONDITION, NOT_REASSIGNED_CONDITION}, filter.conditions...)
+	filter.args = append([]any{q.workerID}, filter.args...)
+	if q.maxEventAge > 0 {
+		filter.conditions = append([]string{WITHIN_MAX_AGE_CONDITION}, filter.conditions...)
+		filter.args = append([]any{q.maxEventAge.Seconds()}, filter.args...)
+	}
+	if q.maxInFlight > 0 {
+		filter.conditions = append([]string{MAX_IN_FLIGHT_CONDITION}, filter.conditions...)
+		filter.args = append([]any{q.maxInFlight}, filter.args...)
+	}
+	if len(q.resourceCapacities) > 0 {
+		capacities, err := json.Marshal(q.resourceCapacities)
+		if err != nil {
+			return nil, fmt.Errorf("problem encoding resource capacities: %w", err)
+		}
+		filter.conditions = append([]string{RESOURCE_CAPACITY_CONDITION}, filter.conditions...)
+		filter.args = append([]any{string(capacities)}, filter.args...)
+	}
+	args := append([]any{q.maxRetries}, filter.args...)
+	err := sql.ErrNoRows
+	if q.freshTurn() {
+		fresh := filter.and() + "\nAND " + FRESH_CONDITION
+		err = tx.QueryRow(fmt.Sprintf(NEXT_JOB_TEMPLATE, q.clock.now, fresh), args...).Scan(&candidate)
+	}
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(fmt.Sprintf(NEXT_JOB_TEMPLATE, q.clock.now, filter.and()), args...).Scan(&candidate)
+	}
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, fmt.Errorf("problem getting next event in queue: %w", err)
 	}
-	var id int
-	var data string
-	err = tx.QueryRow(CLAIM_JOB_QUERY_TEMPLATE, q.claimTimeoutSeconds, candidate).Scan(&id, &data)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("it's ehre %w", err)
-	} else if err != nil {
+	moved, err := moveEvents(tx, PENDING_TABLE, INFLIGHT_TABLE, "id = ?", candidate)
+	if err != nil {
 		return nil, fmt.Errorf("problem claiming event from queue: %w", err)
 	}
-	var payload T
-	err = json.Unmarshal([]byte(data), &payload)
+	if len(moved) == 0 {
+		// Another consumer claimed it first
+		return nil, nil
+	}
+	claimTimeout := timeout
+	if claimTimeout == 0 {
+		claimTimeout = q.claimTimeout
+	}
+	envelope, err := scanEnvelope(tx.QueryRow(fmt.Sprintf(CLAIM_JOB_QUERY_TEMPLATE, q.clock.after), q.clock.offset(claimTimeout), nullString(q.workerID), q.epoch, candidate), EVENT_STATE_INFLIGHT)
 	if err != nil {
-		return nil, fmt.Errorf("problem unmarshalling data from queue to type %T: %w", payload, err)
+		return nil, fmt.Errorf("problem claiming event from queue: %w", err)
+	}
+	if timeout == 0 && envelope.ClaimTimeout > 0 {
+		if _, err := tx.Exec(fmt.Sprintf(EXTEND_CLAIMS_QUERY_TEMPLATE, q.clock.after, "?"), q.clock.offset(envelope.ClaimTimeout), envelope.Id); err != nil {
+			return nil, fmt.Errorf("problem applying the event's claim timeout: %w", err)
+		}
+	}
+	if len(q.interceptors) > 0 {
+		if err := q.interceptPayload(tx, &envelope); err != nil {
+			return nil, err
+		}
+	}
+	var payload T
+	err = q.codec.Unmarshal(envelope.Payload, &payload)
+	if err != nil && q.decodeQuarantine {
+		if err := quarantineEvent(tx, envelope.Id, ts); err != nil {
+			return nil, err
+		}
+		q.logger().Warn(fmt.Sprintf("quarantined event %d, its payload doesn't decode to type %T: %v", envelope.Id, payload, err))
+		return q.claimNext(tx, timeout, requested, ts)
 	}
-	err = tx.Commit()
 	if err != nil {
-		return nil, fmt.Errorf("promblem commiting transaction when attempting to claim item from queue: %w", err)
+		return nil, &decodeError{fmt.Errorf("problem unmarshalling data from queue to type %T: %w", payload, err)}
 	}
-	return &Event[T]{id, &payload}, nil
+	envelope.QueueLabels = q.labels
+	ts.add(TRANSITION_CLAIMED, envelope)
+	return &Event[T]{envelope.Id, &payload, envelope}, nil
 }
 
-const ACK_QUERY_TEMPLATE = `DELETE FROM queue WHERE id = %d`
+const ACK_QUERY_TEMPLATE = `DELETE FROM %s WHERE id = ? RETURNING ` + ENVELOPE_COLUMNS
 
 // Ackknowledge the successful processing of event with id: id. Once acked, this event
 // Is removed from the database and will not be processed again
 func (q *Queue[T]) Ack(id int) error {
-	q.lock.Lock()
-	defer q.lock.Unlock()
-	_, err := q.db.Exec(fmt.Sprintf(ACK_QUERY_TEMPLATE, id))
+	defer q.startRegion(context.Background(), TRACE_REGION_ACK)()
+	var ts transitions
+	err := q.retryTransient(func(opID string) error {
+		ts.reset()
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.ackInTx(id, opID, &ts)
+	})
+	q.health.record(err)
 	if err != nil {
 		return fmt.Errorf("unable to ack event: %d: %w", id, err)
 	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) ackInTx(id int, opID string, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return err
+	}
+	if err := q.ack(tx, id, q.purgeTime(), ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+// The event is normally in flight, but its claim may have expired in the meantime. Unless
+// purgeAt is zero the event is kept until then, or for its own retention if it has one, see
+// WithEventRetention. Parents waiting for their children are kept until the children are
+// done, see SpawnChildren
+func (q *Queue[T]) ack(tx *sql.Tx, id int, purgeAt time.Time, ts *transitions) error {
+	if err := deleteEventKV(tx, id); err != nil {
+		return err
+	}
+	retention, err := eventRetention(tx, id)
+	if err != nil {
+		return err
+	}
+	if retention > 0 {
+		purgeAt = time.Now().Add(retention)
+	}
+	awaiting, err := awaitingChildren(tx, id)
+	if err != nil {
+		return err
+	}
+	if awaiting {
+		purgeAt = time.Time{}
+	}
+	for _, table := range []string{INFLIGHT_TABLE, PENDING_TABLE} {
+		if awaiting || !purgeAt.IsZero() {
+			completed, err := complete(tx, table, id, purgeAt, ts)
+			if err != nil || completed {
+				return err
+			}
+			continue
+		}
+		if q.archive {
+			if err := archiveEvent(tx, table, id); err != nil {
+				return err
+			}
+		}
+		envelope, err := scanEnvelope(tx.QueryRow(fmt.Sprintf(ACK_QUERY_TEMPLATE, table), id), TABLE_STATES[table])
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !q.archive {
+			// Archived events keep their tags
+			if _, err := tx.Exec(DELETE_TAGS_QUERY, id); err != nil {
+				return err
+			}
+		}
+		if q.hashChain {
+			if err := chainArchived(tx, []int{id}); err != nil {
+				return err
+			}
+		}
+		ts.add(TRANSITION_ACKED, envelope)
+		return nil
+	}
 	return nil
 }
 
-const NACK_QUERY_TEMPLATE = `UPDATE queue SET retries = retries + 1, claimed = 0, claim_expires = datetime('now', printf('+%d seconds', ?), 'utc') WHERE id = ?`
+const NACK_QUERY_TEMPLATE = `UPDATE queue SET retries = IFNULL(retries, 0) + 1, claimed = 0, claim_expires = %s, claimed_by = NULL, claimed_epoch = NULL, attempts = ` + END_ATTEMPT_EXPRESSION + ` WHERE id = ? RETURNING ` + ENVELOPE_COLUMNS
 
 // Negative Ack indicates that the event with id: id was not able to be processed, and will be put in quarantice
 // for the configured backoff period before being available to be de-queued again
 func (q *Queue[T]) Nack(id int) error {
-	jitter := rand.Intn(3)
+	_, err := q.nack(id)
+	return err
+}
+
+// A few seconds of randomness added to the retry backoff so that events failing together
+// don't all become available again at the same instant
+func jitter() int {
+	return rand.Intn(3)
+}
+
+// Nacks the event and reports whether it was dead lettered because that was its last retry
+func (q *Queue[T]) nack(id int) (bool, error) {
+	defer q.startRegion(context.Background(), TRACE_REGION_NACK)()
+	var ts transitions
+	var dead bool
+	err := q.retryTransient(func(opID string) error {
+		ts.reset()
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		var err error
+		dead, err = q.nackInTx(id, opID, &ts)
+		return err
+	})
+	q.health.record(err)
+	if err != nil {
+		return false, fmt.Errorf("unable to nack event: %d: %w", id, err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return dead, nil
+}
+
+func (q *Queue[T]) nackInTx(id int, opID string, ts *transitions) (bool, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return false, err
+	}
+	dead, err := q.nackTx(tx, id, ts)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return false, err
+	}
+	return dead, nil
+}
+
+// Return the event to pending with its backoff applied, or move it to the dead letter
+// table if that was its last retry. Reports whether the event was dead lettered
+func (q *Queue[T]) nackTx(tx *sql.Tx, id int, ts *transitions) (bool, error) {
+	if _, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, "id = ?", id); err != nil {
+		return false, err
+	}
+	envelope, err := scanEnvelope(tx.QueryRow(fmt.Sprintf(NACK_QUERY_TEMPLATE, q.clock.after), q.clock.offset(time.Duration(q.retryBackoffSeconds+jitter())*time.Second), ATTEMPT_FAILED, id), EVENT_STATE_PENDING)
+	if err != nil {
+		return false, err
+	}
+	if envelope.Retries <= envelope.retryLimit(q.maxRetries) {
+		ts.add(TRANSITION_NACKED, envelope)
+		return false, nil
+	}
+	if _, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, "id = ?", id); err != nil {
+		return false, err
+	}
+	if err := markDead(tx, []int{id}, DEAD_REASON_MAX_RETRIES, ts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+const (
+	// The event failed more than the configured maximum number of retries
+	DEAD_REASON_MAX_RETRIES = "max_retries"
+)
+
+const MARK_DEAD_QUERY_TEMPLATE = `UPDATE queue_dead SET reason = ?, dead_at = datetime('now', 'utc'), claimed = 0 WHERE id IN (%s) RETURNING ` + ENVELOPE_COLUMNS
+
+// Record why events that were just moved to the dead letter table died
+func markDead(tx *sql.Tx, ids []int, reason string, ts *transitions) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders, args := inClause(ids)
+	envelopes, err := queryEnvelopes(tx, fmt.Sprintf(MARK_DEAD_QUERY_TEMPLATE, placeholders), EVENT_STATE_DEAD, append([]any{reason}, args...)...)
+	if err != nil {
+		return fmt.Errorf("problem marking events as dead: %w", err)
+	}
+	for _, envelope := range envelopes {
+		envelope.DeadReason = reason
+		ts.add(TRANSITION_DEAD_LETTERED, envelope)
+	}
+	return nil
+}
+
+const RELEASE_QUERY_TEMPLATE = `UPDATE queue SET claimed = 0, claim_expires = NULL, claimed_by = NULL, claimed_epoch = NULL, attempts = ` + END_ATTEMPT_EXPRESSION + ` WHERE id IN (%s) RETURNING ` + ENVELOPE_COLUMNS
+
+// Clear the claims of events that were just moved back to the pending table, ending their
+// current attempt with outcome
+func releaseClaims(tx *sql.Tx, ids []int, outcome string, ts *transitions) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders, args := inClause(ids)
+	envelopes, err := queryEnvelopes(tx, fmt.Sprintf(RELEASE_QUERY_TEMPLATE, placeholders), EVENT_STATE_PENDING, append([]any{outcome}, args...)...)
+	if err != nil {
+		return err
+	}
+	for _, envelope := range envelopes {
+		ts.add(TRANSITION_RELEASED, envelope)
+	}
+	return nil
+}
+
+// Release gives up the claim on event with id: id without counting it as a failed attempt,
+// making it immediately available to be de-queued again
+func (q *Queue[T]) Release(id int) error {
+	var ts transitions
 	q.lock.Lock()
-	defer q.lock.Unlock()
-	_, err := q.db.Query(NACK_QUERY_TEMPLATE, q.retryBackoffSeconds+jitter, id)
+	err := q.release(id, &ts)
+	q.lock.Unlock()
 	if err != nil {
-		return fmt.Errorf("unable to nack event: %d: %w", id, err)
+		return fmt.Errorf("unable to release event: %d: %w", id, err)
 	}
+	q.notifyTransitions(ts)
 	return nil
 }
 
-const QUEUE_SIZE_TEMPLATE = `SELECT COUNT(*) from queue where retries <= :max_retries;`
+func (q *Queue[T]) release(id int, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	released, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, "id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := releaseClaims(tx, released, ATTEMPT_RELEASED, ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+const QUEUE_SIZE_TEMPLATE = `SELECT (SELECT COUNT(*) FROM queue WHERE IFNULL(retries, 0) <= ` + RETRY_LIMIT_EXPRESSION + ` AND payload IS NOT NULL AND ` + NOT_EXPIRED_CONDITION + `) + (SELECT COUNT(*) FROM queue_inflight);`
 
-// Returns the number of events in the queue
+// Returns the number of events in the queue, pending or being processed
 func (q *Queue[T]) Size() (int, error) {
 	var size int
 	q.lock.RLock()
 	defer q.lock.RUnlock()
-	err := q.db.QueryRow(QUEUE_SIZE_TEMPLATE, sql.Named("max_retries", q.maxRetries)).Scan(&size)
+	err := q.db.QueryRow(QUEUE_SIZE_TEMPLATE, q.maxRetries).Scan(&size)
 	if err != nil {
 		return -1, fmt.Errorf("problem getting number of events in the queue: %w", err)
 	}
 	return size, nil
 }
 
+const DEAD_SIZE_TEMPLATE = `SELECT COUNT(*) FROM queue_dead;`
+
+// Returns the number of events in the dead letter table
+func (q *Queue[T]) DeadSize() (int, error) {
+	var size int
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	err := q.db.QueryRow(DEAD_SIZE_TEMPLATE).Scan(&size)
+	if err != nil {
+		return -1, fmt.Errorf("problem getting number of dead events: %w", err)
+	}
+	return size, nil
+}
+
 // Where the db is stored. This returns a string that may be a path or a turso connection url
 // Depending on what type of queue was instantiated
+//
+// Deprecated: the Turso connection url includes the auth token. Use ConnectionInfo for
+// anything that may be logged, or DSN where the full connection string is needed
 func (q *Queue[T]) Location() string {
 	return q.location
 }
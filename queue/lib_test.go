@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -19,6 +20,24 @@ func randomString(n int) string {
 	return base64.URLEncoding.EncodeToString(data)[:n]
 }
 
+// Creates a local queue with a random name that is removed when the test finishes
+func newTestQueue[T any](t *testing.T, opts ...Option) *Queue[T] {
+	t.Helper()
+	q, err := NewLocalQueue[T](randomString(10), opts...)
+	if err != nil {
+		t.Fatalf("unable to create queue: %v", err)
+	}
+	t.Cleanup(func() {
+		err := os.Remove(strings.TrimPrefix(q.Location(), "file:"))
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to remove db at location: %s", q.Location()))
+		}
+		// Only succeeds once the last test database is gone
+		_ = os.Remove(".db")
+	})
+	return q
+}
+
 func TestNewLocalQueue(t *testing.T) {
 	type Test struct{}
 	q, err := NewLocalQueue[Test](randomString(10))
@@ -256,3 +275,61 @@ func TestClaimTimeout(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestNackMovesExhaustedEventsToDeadLetters(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithMaxRetires(0).WithRetryBackoffSeconds(0)
+
+	for _, a := range []string{"dead", "alive"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := q.DeadSize(); size != 1 {
+		t.Fatalf("expected one dead event, got %d", size)
+	}
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("expected one live event, got %d", size)
+	}
+
+	next, err := q.Next()
+	if err != nil || next == nil {
+		t.Fatal(err)
+	}
+	if next.Content.A != "alive" {
+		t.Fatal()
+	}
+	// Nothing left to claim, the dead event is never delivered again
+	if none, err := q.Next(); err != nil || none != nil {
+		t.Fatal()
+	}
+}
+
+func TestSynchronousMaintenance(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithClaimTimeoutSeconds(1)
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	time.Sleep(2 * time.Second)
+	// Nothing runs in the background, the expired claim is reclaimed by this call
+	reclaimed, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == nil || reclaimed.Id != event.Id {
+		t.Fatalf("expected event %d to be reclaimed, got %v", event.Id, reclaimed)
+	}
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Consecutive maintenance failures after which MaintenanceStatus reports the queue as
+// unhealthy, unless configured with WithOnMaintenanceFailure
+const defaultMaintenanceFailureThreshold = 3
+
+// The outcome of recent maintenance runs, which reclaim expired claims, dead letter
+// exhausted events and purge completed events
+type MaintenanceStatus struct {
+	// False once maintenance has failed the threshold number of times in a row. While
+	// unhealthy, expired claims are not reclaimed and events stay stuck in flight
+	Healthy             bool
+	ConsecutiveFailures int
+	// The error of the most recent failed run, nil once a run succeeds
+	LastError   error
+	LastSuccess time.Time
+}
+
+// Register fn to be called on every maintenance run that fails once maintenance has failed
+// threshold times in a row, so persistent failures reach monitoring instead of only the
+// logs. Also sets the threshold used by MaintenanceStatus
+func (q *Queue[T]) WithOnMaintenanceFailure(threshold int, fn func(consecutiveFailures int, err error)) *Queue[T] {
+	q.maintenance.lock.Lock()
+	defer q.maintenance.lock.Unlock()
+	q.maintenance.threshold = max(threshold, 1)
+	q.maintenance.onFailure = fn
+	return q
+}
+
+// Returns the outcome of recent maintenance runs
+func (q *Queue[T]) MaintenanceStatus() MaintenanceStatus {
+	q.maintenance.lock.Lock()
+	defer q.maintenance.lock.Unlock()
+	return MaintenanceStatus{
+		Healthy:             q.maintenance.failures < q.maintenance.threshold,
+		ConsecutiveFailures: q.maintenance.failures,
+		LastError:           q.maintenance.lastErr,
+		LastSuccess:         q.maintenance.lastSuccess,
+	}
+}
+
+type maintenanceHealth struct {
+	lock      sync.Mutex
+	threshold int
+	onFailure func(consecutiveFailures int, err error)
+
+	failures    int
+	lastErr     error
+	lastSuccess time.Time
+}
+
+// Record the outcome of a maintenance run, then run the checks that piggyback on
+// maintenance. Must not be called with q.lock held
+func (q *Queue[T]) afterMaintenance(err error) {
+	q.recordMaintenance(err)
+	q.checkClockDrift()
+	q.refreshSnapshotIfDue()
+}
+
+// Record the outcome of a maintenance run and call the failure hook if the threshold has
+// been reached. Must not be called with q.lock held
+func (q *Queue[T]) recordMaintenance(err error) {
+	m := &q.maintenance
+	m.lock.Lock()
+	if err == nil {
+		m.failures = 0
+		m.lastErr = nil
+		m.lastSuccess = time.Now()
+		m.lock.Unlock()
+		return
+	}
+	m.failures++
+	m.lastErr = err
+	failures := m.failures
+	var hook func(int, error)
+	if failures >= m.threshold {
+		hook = m.onFailure
+	}
+	m.lock.Unlock()
+	if hook != nil {
+		hook(failures, err)
+	}
+}
@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestMaintenanceFailure(t *testing.T) {
+	type Test struct{ A string }
+	reported := []int{}
+	// Maintenance runs inside every Insert
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).
+		WithClaimTimeoutSeconds(0).
+		WithOnMaintenanceFailure(2, func(failures int, err error) { reported = append(reported, failures) })
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := q.MaintenanceStatus(); !status.Healthy || status.LastSuccess.IsZero() {
+		t.Fatalf("expected healthy maintenance, got %+v", status)
+	}
+
+	// Reclaiming expired claims fails without the in-flight table
+	if _, err := q.db.Exec("ALTER TABLE queue_inflight RENAME TO queue_inflight_gone"); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := q.Insert(Test{A: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := q.MaintenanceStatus()
+	if status.Healthy || status.ConsecutiveFailures != 3 || status.LastError == nil {
+		t.Fatalf("expected unhealthy maintenance, got %+v", status)
+	}
+	if len(reported) != 2 || reported[0] != 2 || reported[1] != 3 {
+		t.Fatalf("expected the hook to be called from the second failure on, got %v", reported)
+	}
+
+	if _, err := q.db.Exec("ALTER TABLE queue_inflight_gone RENAME TO queue_inflight"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := q.MaintenanceStatus(); !status.Healthy || status.ConsecutiveFailures != 0 {
+		t.Fatalf("expected maintenance to recover, got %+v", status)
+	}
+}
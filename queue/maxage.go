@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+// The event was enqueued longer ago than the queue's maximum event age, see WithMaxEventAge
+const DEAD_REASON_MAX_AGE_EXCEEDED = "max_age_exceeded"
+
+// Events enqueued at most the bound number of seconds ago
+const WITHIN_MAX_AGE_CONDITION = `(enqueued_at IS NULL OR unixepoch(enqueued_at) + ? > unixepoch('subsec'))`
+
+// Events enqueued more than the bound number of seconds ago
+const MAX_AGE_EXCEEDED_CONDITION = `unixepoch(enqueued_at) + ? <= unixepoch('subsec')`
+
+// Never deliver events enqueued longer than d ago, however many retries they have left, so
+// work that piled up during an outage doesn't suddenly run days later. Such events are moved
+// to the dead letter table with reason DEAD_REASON_MAX_AGE_EXCEEDED by maintenance. Like
+// expiry, events already in flight are left to their consumer. 0 disables the limit
+func (q *Queue[T]) WithMaxEventAge(d time.Duration) *Queue[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.maxEventAge = max(d, 0)
+	return q
+}
+
+// Move pending events older than the maximum event age to the dead letter table. Callers
+// must hold q.lock
+func (q *Queue[T]) deadLetterTooOld(ts *transitions) error {
+	if q.maxEventAge == 0 {
+		return nil
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	ids, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, MAX_AGE_EXCEEDED_CONDITION, q.maxEventAge.Seconds())
+	if err != nil {
+		return fmt.Errorf("problem moving events past their maximum age to the dead letter table: %w", err)
+	}
+	var dead transitions
+	if err := markDead(tx, ids, DEAD_REASON_MAX_AGE_EXCEEDED, &dead); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*ts = append(*ts, dead...)
+	return nil
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxEventAgeDeadLettersOldEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithMaxEventAge(time.Hour)
+	for _, a := range []string{"stale", "fresh"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enqueued before an outage, with retries to spare
+	if _, err := q.db.Exec("UPDATE queue SET enqueued_at = datetime('now', '-2 hours', 'utc') WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if event.Content.A != "fresh" {
+		t.Fatalf("expected the stale event not to be delivered, got %s", event.Content.A)
+	}
+
+	var ts transitions
+	q.lock.Lock()
+	err = q.deadLetterTooOld(&ts)
+	q.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err := q.List(ListFilter{State: EVENT_STATE_DEAD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Id != 1 || dead[0].DeadReason != DEAD_REASON_MAX_AGE_EXCEEDED {
+		t.Fatalf("expected event 1 to be dead lettered for its age, got %+v", dead)
+	}
+	if len(ts) != 1 || ts[0].transition != TRANSITION_DEAD_LETTERED {
+		t.Fatalf("expected a dead letter transition, got %+v", ts)
+	}
+}
+
+func TestMaxEventAgeDisabledByDefault(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	if err := q.Insert(Test{A: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec("UPDATE queue SET enqueued_at = datetime('now', '-30 days', 'utc')"); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected old events to be delivered without a maximum age, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How SQLite's datetime() formats timestamps, used when writing times back to the database
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+const CREATE_MIGRATIONS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_migrations (
+    source TEXT PRIMARY KEY,             -- hash of the source queue's location without parameters
+    last_id INTEGER NOT NULL             -- highest source id copied so far
+);
+`
+
+const MIGRATION_CHECKPOINT_QUERY = `SELECT last_id FROM queue_migrations WHERE source = ?`
+
+const MIGRATION_SAVE_CHECKPOINT_QUERY = `
+INSERT INTO queue_migrations (source, last_id) VALUES (?, ?)
+ON CONFLICT (source) DO UPDATE SET last_id = excluded.last_id
+`
+
+// Selects the next batch of events across all event tables, along with the dead letter
+// details, the name of the table each event came from and its tags
+func migrationSelectBatchQuery() string {
+	columns := strings.Join(eventColumns(), ", ")
+	selects := []string{}
+	for _, table := range EVENT_TABLES {
+		extra := "NULL, NULL"
+		if table == DEAD_TABLE {
+			extra = "dead_at, reason"
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s, %s, '%s', %s FROM %s WHERE id > ?", columns, extra, table, TAGS_COLUMN, table))
+	}
+	return strings.Join(selects, "\nUNION ALL\n") + "\nORDER BY id ASC LIMIT ?"
+}
+
+// Inserts an event with every column but the id, which is assigned by the destination
+func migrationInsertQuery() string {
+	columns := eventColumns()[1:]
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("INSERT INTO queue (%s) VALUES (%s)", strings.Join(columns, ", "), placeholders)
+}
+
+const MIGRATION_RESTORE_DEAD_QUERY = `UPDATE queue_dead SET dead_at = ?, reason = ? WHERE id = ?`
+
+const MIGRATION_DELETE_COPIED_QUERY_TEMPLATE = `DELETE FROM %s WHERE id <= ?`
+
+// Acked events are not migrated, so they keep their tags
+const MIGRATION_DELETE_COPIED_TAGS_QUERY = `
+DELETE FROM queue_tags WHERE event_id <= ?
+AND event_id NOT IN (SELECT id FROM queue_completed)
+AND event_id NOT IN (SELECT id FROM queue_archive)
+`
+
+type MigrateOptions struct {
+	// Delete events from the source once they have been committed to the destination
+	Move bool
+	// How many events are copied per transaction, defaults to 500
+	BatchSize int
+	// Called after every committed batch with the number of events migrated so far
+	Progress func(migrated int)
+}
+
+// Copy (or move) every event in this queue into dst, preserving enqueue times, retry
+// counts and claim/backoff state, e.g. to promote a local queue to Turso. Progress is
+// checkpointed in dst in the same transaction as each batch, so an interrupted migration
+// can be resumed by calling MigrateTo again without duplicating events. Event ids are not
+// preserved. Consumers of the source queue should be stopped while migrating.
+func (q *Queue[T]) MigrateTo(ctx context.Context, dst *Queue[T], opts MigrateOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	// Without the auth token and other parameters, which may change before a resume
+	source := payloadHash([]byte(payloadTypeKey(q.location)))
+
+	var lastID int
+	err := dst.db.QueryRow(MIGRATION_CHECKPOINT_QUERY, source).Scan(&lastID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("problem reading migration checkpoint: %w", err)
+	}
+	// A previous move may have been interrupted after copying but before deleting
+	if opts.Move && lastID > 0 {
+		if err := q.deleteMigrated(lastID); err != nil {
+			return 0, err
+		}
+	}
+
+	migrated := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+		batch, err := q.migrationBatch(lastID, opts.BatchSize)
+		if err != nil {
+			return migrated, err
+		}
+		if len(batch) == 0 {
+			dst.checkEmpty()
+			return migrated, nil
+		}
+		lastID = batch[len(batch)-1].id()
+		if err := dst.insertMigrated(source, lastID, batch); err != nil {
+			return migrated, err
+		}
+		if opts.Move {
+			if err := q.deleteMigrated(lastID); err != nil {
+				return migrated, err
+			}
+		}
+		migrated += len(batch)
+		if opts.Progress != nil {
+			opts.Progress(migrated)
+		}
+	}
+}
+
+// A row of one of the event tables as it is copied between databases
+type migratedRow struct {
+	// The values of eventColumns(), in order
+	values []any
+	deadAt any
+	reason any
+	table  string
+	tags   sql.NullString
+}
+
+func (row migratedRow) id() int {
+	return int(row.values[0].(int64))
+}
+
+func (q *Queue[T]) migrationBatch(afterID int, size int) ([]migratedRow, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	rows, err := q.db.Query(migrationSelectBatchQuery(), afterID, afterID, afterID, size)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading events to migrate: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	batch := []migratedRow{}
+	count := len(eventColumns())
+	for rows.Next() {
+		row := migratedRow{values: make([]any, count)}
+		dest := make([]any, 0, count+4)
+		for i := range row.values {
+			dest = append(dest, &row.values[i])
+		}
+		dest = append(dest, &row.deadAt, &row.reason, &row.table, &row.tags)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("problem reading events to migrate: %w", err)
+		}
+		// The driver turns anything that looks like a timestamp into a time.Time, write
+		// them back the way SQLite formats them
+		for i, value := range row.values {
+			row.values[i] = sqliteValue(value)
+		}
+		row.deadAt = sqliteValue(row.deadAt)
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// Insert a batch of migrated rows and record lastID as the checkpoint for source
+func (q *Queue[T]) insertMigrated(source string, lastID int, batch []migratedRow) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	insert := migrationInsertQuery()
+	for _, row := range batch {
+		// Every event is inserted as pending to get a fresh id from this database,
+		// then moved to the table it was in at the source
+		result, err := tx.Exec(insert, row.values[1:]...)
+		if err != nil {
+			return fmt.Errorf("problem inserting migrated event %d: %w", row.id(), err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("problem inserting migrated event %d: %w", row.id(), err)
+		}
+		tags, err := decodeTags(row.tags)
+		if err != nil {
+			return fmt.Errorf("problem decoding tags of migrated event %d: %w", row.id(), err)
+		}
+		if err := insertTags(tx, int(id), tags); err != nil {
+			return err
+		}
+		if row.table == PENDING_TABLE {
+			continue
+		}
+		if _, err := moveEvents(tx, PENDING_TABLE, row.table, "id = ?", id); err != nil {
+			return fmt.Errorf("problem moving migrated event %d to %s: %w", row.id(), row.table, err)
+		}
+		if row.table == DEAD_TABLE {
+			if _, err := tx.Exec(MIGRATION_RESTORE_DEAD_QUERY, row.deadAt, row.reason, id); err != nil {
+				return fmt.Errorf("problem restoring dead event %d: %w", row.id(), err)
+			}
+		}
+	}
+	if _, err := tx.Exec(MIGRATION_SAVE_CHECKPOINT_QUERY, source, lastID); err != nil {
+		return fmt.Errorf("problem saving migration checkpoint: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("problem commiting migrated batch: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue[T]) deleteMigrated(lastID int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, table := range EVENT_TABLES {
+		if _, err := q.db.Exec(fmt.Sprintf(MIGRATION_DELETE_COPIED_QUERY_TEMPLATE, table), lastID); err != nil {
+			return fmt.Errorf("problem deleting migrated events: %w", err)
+		}
+	}
+	if _, err := q.db.Exec(MIGRATION_DELETE_COPIED_TAGS_QUERY, lastID); err != nil {
+		return fmt.Errorf("problem deleting tags of migrated events: %w", err)
+	}
+	return nil
+}
+
+// Convert a value read from the database so it can be written back unchanged
+func sqliteValue(value any) any {
+	t, ok := value.(time.Time)
+	if !ok {
+		return value
+	}
+	return formatSqliteTime(t)
+}
+
+// Format t the way SQLite's datetime() does, keeping sub-second precision if there is any
+func formatSqliteTime(t time.Time) string {
+	if t.Nanosecond() != 0 {
+		return t.UTC().Format(sqliteTimeFormat + ".000")
+	}
+	return t.UTC().Format(sqliteTimeFormat)
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMigrateTo(t *testing.T) {
+	type Test struct{ A string }
+	src := newTestQueue[Test](t)
+	dst := newTestQueue[Test](t)
+
+	// Dead events are migrated too
+	if err := src.Insert(Test{A: "dead"}, WithTags("dead")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CancelTagged("dead"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := src.Insert(Test{A: a}, WithTags("tag-"+a)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A nacked event keeps its retry count in the destination
+	event, err := src.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if err := src.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := src.MigrateTo(context.Background(), dst, MigrateOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 4 {
+		t.Fatalf("expected 4 events migrated, got %d", migrated)
+	}
+	// Resuming a finished migration copies nothing
+	migrated, err = src.MigrateTo(context.Background(), dst, MigrateOptions{Move: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 0 {
+		t.Fatalf("expected resumed migration to copy nothing, got %d", migrated)
+	}
+	if size, _ := src.Size(); size != 0 {
+		t.Fatalf("expected moved events to be removed from the source, got size %d", size)
+	}
+	if size, _ := dst.Size(); size != 3 {
+		t.Fatalf("expected 3 events in destination, got %d", size)
+	}
+	if size, _ := dst.DeadSize(); size != 1 {
+		t.Fatalf("expected 1 dead event in destination, got %d", size)
+	}
+
+	// "one" is still in its backoff period so "two" comes first
+	next, err := dst.Next()
+	if err != nil || next == nil {
+		t.Fatal(err)
+	}
+	if next.Content.A != "two" {
+		t.Fatalf("expected backoff to be preserved, got %s", next.Content.A)
+	}
+	if len(next.Envelope.Tags) != 1 || next.Envelope.Tags[0] != "tag-two" {
+		t.Fatalf("expected tags to be preserved, got %v", next.Envelope.Tags)
+	}
+}
+
+func TestMigrateToResumesAfterTheSourceCredentialsChange(t *testing.T) {
+	type Test struct{ A string }
+	src := newTestQueue[Test](t)
+	dst := newTestQueue[Test](t)
+	for _, a := range []string{"one", "two", "three"} {
+		if err := src.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Interrupted after the first batch
+	ctx, cancel := context.WithCancel(context.Background())
+	migrated, err := src.MigrateTo(ctx, dst, MigrateOptions{BatchSize: 2, Progress: func(int) { cancel() }})
+	if !errors.Is(err, context.Canceled) || migrated != 2 {
+		t.Fatalf("expected the migration to stop after 2 events, got %d: %v", migrated, err)
+	}
+
+	// e.g. a rotated Turso auth token
+	location := src.location
+	src.location += "?authToken=rotated"
+	defer func() {
+		src.location = location
+	}()
+	migrated, err = src.MigrateTo(context.Background(), dst, MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 {
+		t.Fatalf("expected the resumed migration to copy the remaining event, got %d", migrated)
+	}
+	if size, _ := dst.Size(); size != 3 {
+		t.Fatalf("expected 3 events in destination, got %d", size)
+	}
+}
@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The longest queue name, it becomes the name of the database file and its -wal and -shm
+// companions
+const maxQueueNameLength = 128
+
+// Returned for queue names that can't be used as a database file name
+var ErrInvalidQueueName = errors.New("invalid queue name")
+
+// The name of a local queue, which becomes the name of its database file in .db. Names are
+// ASCII letters, digits, '.', '-' and '_', not starting with a '.', and at most 128 bytes
+type QueueName string
+
+// The name s, or ErrInvalidQueueName if it can't name a queue
+func ParseQueueName(s string) (QueueName, error) {
+	name := QueueName(s)
+	return name, name.Validate()
+}
+
+// Check that the name can be used as a database file name, e.g. before it is written to a
+// configuration file
+func (n QueueName) Validate() error {
+	if n == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidQueueName)
+	}
+	if len(n) > maxQueueNameLength {
+		return fmt.Errorf("%w: %q is longer than %d bytes", ErrInvalidQueueName, n, maxQueueNameLength)
+	}
+	if n[0] == '.' {
+		return fmt.Errorf("%w: %q starts with a '.'", ErrInvalidQueueName, n)
+	}
+	for _, c := range n {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: %q contains %q", ErrInvalidQueueName, n, c)
+		}
+	}
+	return nil
+}
+
+func (n QueueName) String() string {
+	return string(n)
+}
+
+// Returned when a queue is opened with a different payload type than it is already open with
+// in this process, which would mix incompatible payloads in one table
+type PayloadTypeCollisionError struct {
+	// The queue's name, or its url for Turso queues
+	Queue string
+	// The payload type the queue is open with
+	Open string
+	// The payload type it was opened with again
+	Requested string
+}
+
+func (e *PayloadTypeCollisionError) Error() string {
+	return fmt.Sprintf("queue %s is already open with payload type %s, not %s", e.Queue, e.Open, e.Requested)
+}
+
+// The payload types of the queues open in this process, by database url without parameters
+var openPayloadTypes = struct {
+	lock    sync.Mutex
+	entries map[string]*openPayloadType
+}{entries: map[string]*openPayloadType{}}
+
+type openPayloadType struct {
+	payload reflect.Type
+	refs    int
+}
+
+// The key of the database at dbUrl in openPayloadTypes, without the auth token and other
+// parameters that don't change which database it is
+func payloadTypeKey(dbUrl string) string {
+	key, _, _ := strings.Cut(dbUrl, "?")
+	return key
+}
+
+// Record that the database at key is open with payload type T, failing with a
+// *PayloadTypeCollisionError if it is already open with another one. Released by Close
+func claimPayloadType[T any](key string) error {
+	payload := reflect.TypeFor[T]()
+	openPayloadTypes.lock.Lock()
+	defer openPayloadTypes.lock.Unlock()
+	entry, ok := openPayloadTypes.entries[key]
+	if !ok {
+		openPayloadTypes.entries[key] = &openPayloadType{payload: payload, refs: 1}
+		return nil
+	}
+	if entry.payload != payload {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "file:.db/"), ".db")
+		return &PayloadTypeCollisionError{Queue: name, Open: entry.payload.String(), Requested: payload.String()}
+	}
+	entry.refs++
+	return nil
+}
+
+// Drop a queue's claim on the payload type of the database at key
+func releasePayloadType(key string) {
+	openPayloadTypes.lock.Lock()
+	defer openPayloadTypes.lock.Unlock()
+	entry, ok := openPayloadTypes.entries[key]
+	if !ok {
+		return
+	}
+	entry.refs--
+	if entry.refs <= 0 {
+		delete(openPayloadTypes.entries, key)
+	}
+}
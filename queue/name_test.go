@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQueueNameValidation(t *testing.T) {
+	for _, name := range []string{"events", "emails.v2", "tenant-42_jobs"} {
+		if _, err := ParseQueueName(name); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".hidden", "../escape", "with space", "émails", strings.Repeat("a", 129)} {
+		if _, err := ParseQueueName(name); !errors.Is(err, ErrInvalidQueueName) {
+			t.Fatalf("expected %q to be invalid, got %v", name, err)
+		}
+	}
+	if _, err := NewLocalQueue[string]("../escape"); !errors.Is(err, ErrInvalidQueueName) {
+		t.Fatalf("expected an invalid name to be rejected, got %v", err)
+	}
+}
+
+func TestPayloadTypeCollision(t *testing.T) {
+	type Test struct{ A string }
+	type Other struct{ B int }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance())
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+
+	again, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatalf("expected the same payload type to open the queue again, got %v", err)
+	}
+	_, err = NewLocalQueue[Other](name)
+	var collision *PayloadTypeCollisionError
+	if !errors.As(err, &collision) {
+		t.Fatalf("expected a payload type collision, got %v", err)
+	}
+	if collision.Queue != name || collision.Open != "queue.Test" || collision.Requested != "queue.Other" {
+		t.Fatalf("unexpected collision %+v", collision)
+	}
+
+	// Once every queue with the old payload type is closed only the stored fingerprint
+	// guards the database
+	for _, open := range []*Queue[Test]{again, q} {
+		if err := open.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := NewLocalQueue[Other](name, WithSynchronousMaintenance(), WithPayloadTypeOverride())
+	if err != nil {
+		t.Fatalf("expected the closed queue to be reopened with another payload type, got %v", err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
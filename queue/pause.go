@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned by inserts into a paused queue configured WithPausedInsertPolicy(PAUSED_INSERT_REJECT)
+var ErrPaused = errors.New("queue is paused")
+
+const CREATE_PAUSED_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_paused (
+    id INTEGER PRIMARY KEY CHECK (id = 1), -- at most one row, present while paused
+    paused_at TEXT DEFAULT (datetime('now', 'utc')),
+    reason TEXT
+);
+`
+
+const PAUSE_QUERY = `INSERT INTO queue_paused (id, reason) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET reason = excluded.reason`
+
+const RESUME_QUERY = `DELETE FROM queue_paused`
+
+const PAUSED_QUERY = `SELECT EXISTS (SELECT 1 FROM queue_paused)`
+
+// Nothing is claimed while the queue is paused
+const NOT_PAUSED_CONDITION = `NOT EXISTS (SELECT 1 FROM queue_paused)`
+
+// What happens to events inserted while the queue is paused
+type PausedInsertPolicy string
+
+const (
+	// Insert events as usual, they are delivered once the queue is resumed. The default
+	PAUSED_INSERT_ACCEPT PausedInsertPolicy = "accept"
+	// Fail inserts with ErrPaused, so producers can retry after the maintenance window
+	PAUSED_INSERT_REJECT PausedInsertPolicy = "reject"
+	// Insert events tagged with PAUSED_TAG, so they can be told apart after resuming, e.g.
+	// with List or CancelTagged
+	PAUSED_INSERT_TAG PausedInsertPolicy = "tag"
+)
+
+// The tag of events inserted while paused with PAUSED_INSERT_TAG
+const PAUSED_TAG = "inserted-while-paused"
+
+// Stop every process from claiming events from the queue until Resume, e.g. during a
+// maintenance window. Events in flight can still be acked and nacked. What happens to
+// inserts is configured with WithPausedInsertPolicy
+func (q *Queue[T]) Pause(reason string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, err := q.db.Exec(PAUSE_QUERY, nullString(reason)); err != nil {
+		return fmt.Errorf("problem pausing queue: %w", err)
+	}
+	return nil
+}
+
+// Let processes claim events again after Pause
+func (q *Queue[T]) Resume() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, err := q.db.Exec(RESUME_QUERY); err != nil {
+		return fmt.Errorf("problem resuming queue: %w", err)
+	}
+	return nil
+}
+
+// Returns whether the queue is paused by any process
+func (q *Queue[T]) Paused() (bool, error) {
+	var paused bool
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	if err := q.db.QueryRow(PAUSED_QUERY).Scan(&paused); err != nil {
+		return false, fmt.Errorf("problem checking whether queue is paused: %w", err)
+	}
+	return paused, nil
+}
+
+// Configure what happens to events inserted while the queue is paused, PAUSED_INSERT_ACCEPT
+// by default. Other policies cost an extra query per insert
+func (q *Queue[T]) WithPausedInsertPolicy(policy PausedInsertPolicy) *Queue[T] {
+	q.pausedInsertPolicy = policy
+	return q
+}
+
+// The options to add to an insert under the paused insert policy, or ErrPaused
+func (q *Queue[T]) pausedInsertOptions() ([]InsertOption, error) {
+	if q.pausedInsertPolicy == "" || q.pausedInsertPolicy == PAUSED_INSERT_ACCEPT {
+		return nil, nil
+	}
+	paused, err := q.Paused()
+	if err != nil || !paused {
+		return nil, err
+	}
+	if q.pausedInsertPolicy == PAUSED_INSERT_REJECT {
+		return nil, ErrPaused
+	}
+	return []InsertOption{WithTags(PAUSED_TAG)}, nil
+}
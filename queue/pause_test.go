@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPause(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Pause("migrating"); err != nil {
+		t.Fatal(err)
+	}
+	if paused, err := q.Paused(); err != nil || !paused {
+		t.Fatalf("expected the queue to be paused, got %v: %v", paused, err)
+	}
+	if event, err := q.Next(); err != nil || event != nil {
+		t.Fatalf("expected nothing to be claimed while paused, got %v: %v", event, err)
+	}
+	// Accepted by default
+	if err := q.Insert(Test{A: "two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if event, err := q.Next(); err != nil || event == nil {
+		t.Fatalf("expected an event after resuming, got %v: %v", event, err)
+	}
+}
+
+func TestPausedInsertPolicy(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithPausedInsertPolicy(PAUSED_INSERT_REJECT)
+	if err := q.Pause(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "one"}); !errors.Is(err, ErrPaused) {
+		t.Fatalf("expected ErrPaused, got %v", err)
+	}
+	if _, err := q.InsertBatch([]Test{{A: "one"}}); !errors.Is(err, ErrPaused) {
+		t.Fatalf("expected ErrPaused for batches too, got %v", err)
+	}
+
+	q.WithPausedInsertPolicy(PAUSED_INSERT_TAG)
+	if err := q.Insert(Test{A: "two"}, WithTags("mine")); err != nil {
+		t.Fatal(err)
+	}
+	envelopes, err := q.List(ListFilter{Tag: PAUSED_TAG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelopes) != 1 || len(envelopes[0].Tags) != 2 {
+		t.Fatalf("expected the event to be tagged on top of its own tags, got %+v", envelopes)
+	}
+
+	if err := q.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "three"}); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes, _ := q.List(ListFilter{Tag: PAUSED_TAG}); len(envelopes) != 1 {
+		t.Fatalf("expected events inserted after resuming not to be tagged, got %d", len(envelopes))
+	}
+}
@@ -0,0 +1,202 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Returned by Prefetcher.Next once the prefetcher was closed
+var ErrPrefetcherClosed = errors.New("prefetcher closed")
+
+const EXTEND_CLAIMS_QUERY_TEMPLATE = `UPDATE queue_inflight SET claim_expires = %s WHERE id IN (%s)`
+
+// Claims events ahead of time and buffers them in memory, so a high-throughput consumer
+// doesn't wait on a database round trip between events. See Queue.Prefetch
+type Prefetcher[T any] struct {
+	queue *Queue[T]
+	size  int
+
+	lock   sync.Mutex
+	buffer []*Event[T]
+	closed bool
+	// Serializes refills so the buffer doesn't grow past size
+	refillLock sync.Mutex
+
+	refill chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Start prefetching up to size events. The buffer is topped up in the background once it is
+// half empty, and the claims of buffered events are extended so they don't expire while
+// waiting. Events handed out by Next are claimed for the claim timeout as usual and must be
+// acked, nacked or released through the queue. Close the prefetcher on shutdown to release
+// the events still buffered
+func (q *Queue[T]) Prefetch(size int) *Prefetcher[T] {
+	p := &Prefetcher[T]{
+		queue:  q,
+		size:   max(size, 1),
+		refill: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Return the next buffered event, claiming more if the buffer is empty. Returns nil when
+// nothing is available
+func (p *Prefetcher[T]) Next() (*Event[T], error) {
+	for refilled := false; ; refilled = true {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrPrefetcherClosed
+		}
+		if len(p.buffer) > 0 {
+			event := p.buffer[0]
+			p.buffer = p.buffer[1:]
+			low := len(p.buffer) <= p.size/2
+			p.lock.Unlock()
+			if low {
+				select {
+				case p.refill <- struct{}{}:
+				default:
+				}
+			}
+			return event, nil
+		}
+		p.lock.Unlock()
+		if refilled {
+			return nil, nil
+		}
+		if err := p.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stop prefetching and release the claims of the events still buffered so other consumers
+// can pick them up straight away
+func (p *Prefetcher[T]) Close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil
+	}
+	p.closed = true
+	buffered := p.buffer
+	p.buffer = nil
+	p.lock.Unlock()
+	close(p.stop)
+	<-p.done
+	return p.queue.releaseEvents(buffered)
+}
+
+// Top up the buffer and keep the claims of buffered events alive until Close
+func (p *Prefetcher[T]) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(max(p.queue.claimTimeout/3, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-p.refill:
+			if err := p.fill(); err != nil {
+				p.queue.logger().Error(fmt.Sprintf("problem prefetching events: %v", err))
+			}
+		case <-ticker.C:
+			p.lock.Lock()
+			buffered := eventIDs(p.buffer)
+			p.lock.Unlock()
+			if err := p.queue.extendClaims(buffered, p.queue.claimTimeout); err != nil {
+				p.queue.logger().Error(fmt.Sprintf("problem extending claims of prefetched events: %v", err))
+			}
+		}
+	}
+}
+
+// Claim enough events to fill the buffer
+func (p *Prefetcher[T]) fill() error {
+	p.refillLock.Lock()
+	defer p.refillLock.Unlock()
+	p.lock.Lock()
+	want, closed := p.size-len(p.buffer), p.closed
+	p.lock.Unlock()
+	if closed || want <= 0 {
+		return nil
+	}
+	events, err := p.queue.Lease(want, p.queue.claimTimeout)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		// Closed while claiming, Close didn't see these
+		return p.queue.releaseEvents(events)
+	}
+	p.buffer = append(p.buffer, events...)
+	p.lock.Unlock()
+	return nil
+}
+
+func eventIDs[T any](events []*Event[T]) []int {
+	ids := make([]int, len(events))
+	for i, event := range events {
+		ids[i] = event.Id
+	}
+	return ids
+}
+
+// Push the claim expiry of the in-flight events with ids out to by from now
+func (q *Queue[T]) extendClaims(ids []int, by time.Duration) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders, args := inClause(ids)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_, err := q.db.Exec(fmt.Sprintf(EXTEND_CLAIMS_QUERY_TEMPLATE, q.clock.after, placeholders), append([]any{q.clock.offset(by)}, args...)...)
+	return err
+}
+
+// Release the claims of events in one transaction
+func (q *Queue[T]) releaseEvents(events []*Event[T]) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var ts transitions
+	q.lock.Lock()
+	err := q.releaseIDs(eventIDs(events), &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("problem releasing %d events: %w", len(events), err)
+	}
+	q.notifyTransitions(ts)
+	return nil
+}
+
+func (q *Queue[T]) releaseIDs(ids []int, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	placeholders, args := inClause(ids)
+	released, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, "id IN ("+placeholders+")", args...)
+	if err != nil {
+		return err
+	}
+	if err := releaseClaims(tx, released, ATTEMPT_RELEASED, ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
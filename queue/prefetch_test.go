@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithEpochClaims(), WithSynchronousMaintenance()).WithClaimTimeout(200 * time.Millisecond)
+
+	for _, a := range []string{"one", "two", "three", "four", "five"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := q.Prefetch(3)
+	event, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil || event.Content.A != "one" {
+		t.Fatalf("expected event one, got %+v", event)
+	}
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Buffered claims are extended past the claim timeout, so other consumers only see the
+	// events that weren't prefetched
+	time.Sleep(500 * time.Millisecond)
+	other, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == nil || (other.Content.A != "four" && other.Content.A != "five") {
+		t.Fatalf("expected an event that wasn't prefetched, got %+v", other)
+	}
+	if err := q.Ack(other.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing releases the buffered events straight away
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Next(); !errors.Is(err, ErrPrefetcherClosed) {
+		t.Fatalf("expected ErrPrefetcherClosed, got %v", err)
+	}
+	seen := 0
+	for {
+		event, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			break
+		}
+		seen++
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seen != 3 {
+		t.Fatalf("expected 3 events after close, got %d", seen)
+	}
+}
+
+func TestPrefetchEmpty(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	p := q.Prefetch(2)
+	defer func() { _ = p.Close() }()
+
+	event, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event != nil {
+		t.Fatalf("expected no event, got %+v", event)
+	}
+}
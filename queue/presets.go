@@ -0,0 +1,59 @@
+package queue
+
+import "time"
+
+// A bundle of settings suited to a type of workload, applied when the queue is opened with
+// WithPreset. Any setting can still be changed afterwards with the With* methods on Queue
+type Preset struct {
+	RetryBackoffSeconds int
+	MaxRetries          int
+	ClaimTimeoutSeconds int
+	// How long acked events are kept, see WithAckGracePeriod
+	AckGracePeriod time.Duration
+	// Whether acked events are archived, see WithArchive
+	Archive bool
+}
+
+var (
+	// Outgoing webhooks: receivers are often briefly down, so retry patiently for around a
+	// day and keep deliveries for an hour in case they need to be replayed
+	PresetWebhooks = Preset{
+		RetryBackoffSeconds: 60,
+		MaxRetries:          1440,
+		ClaimTimeoutSeconds: 30,
+		AckGracePeriod:      time.Hour,
+	}
+	// Long running batch jobs: generous claims, few retries since failures tend to be
+	// deterministic, and an archive of what ran for reporting
+	PresetHeavyBatch = Preset{
+		RetryBackoffSeconds: 300,
+		MaxRetries:          3,
+		ClaimTimeoutSeconds: 3600,
+		Archive:             true,
+	}
+	// Short user facing tasks where a result is only useful if it arrives quickly
+	PresetInteractive = Preset{
+		RetryBackoffSeconds: 1,
+		MaxRetries:          5,
+		ClaimTimeoutSeconds: 10,
+	}
+)
+
+// Open the queue with the settings of preset instead of the defaults
+func WithPreset(preset Preset) Option {
+	return func(o *options) {
+		o.preset = &preset
+	}
+}
+
+// Apply the settings of preset to q while it is opened, storing those shared with other
+// processes
+func (q *Queue[T]) applyPreset(preset Preset) {
+	q.retryBackoffSeconds = preset.RetryBackoffSeconds
+	q.maxRetries = preset.MaxRetries
+	q.claimTimeout = time.Duration(preset.ClaimTimeoutSeconds) * time.Second
+	q.ackGracePeriod = preset.AckGracePeriod
+	q.archive = preset.Archive
+	q.storeSetting(MAX_RETRIES_SETTING, q.maxRetries)
+	q.storeSetting(ARCHIVE_SETTING, q.archive)
+}
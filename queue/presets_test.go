@@ -0,0 +1,19 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreset(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithPreset(PresetWebhooks)).WithMaxRetires(5)
+
+	if q.retryBackoffSeconds != 60 || q.claimTimeout != 30*time.Second || q.ackGracePeriod != time.Hour {
+		t.Fatalf("expected the webhook preset to be applied, got backoff %d, claim timeout %s, grace period %s",
+			q.retryBackoffSeconds, q.claimTimeout, q.ackGracePeriod)
+	}
+	if maxRetries := q.retryLimit(); maxRetries != 5 {
+		t.Fatalf("expected builders to override the preset, got max retries %d", maxRetries)
+	}
+}
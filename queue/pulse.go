@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The highest id handed out so far, grows with every insert and reservation
+const INSERTED_QUERY = `SELECT seq FROM sqlite_sequence WHERE name = 'queue'`
+
+// In-flight events that were delivered before, after a nack or an Unack
+const REDELIVERED_QUERY = `SELECT COUNT(*) FROM queue_inflight WHERE IFNULL(retries, 0) > 0 OR unacked = 1`
+
+const PAYLOAD_SIZE_QUERY = `SELECT length(payload) AS value FROM queue WHERE payload IS NOT NULL
+UNION ALL SELECT length(payload) FROM queue_inflight`
+
+// The median, 95th and 99th percentile of a set of values
+type Percentiles struct {
+	P50 float64
+	P95 float64
+	P99 float64
+}
+
+// A point-in-time reading of a queue for live views such as `libsqlq top`. Rates come
+// from comparing two readings, see Throughput
+type Pulse struct {
+	At       time.Time
+	Pending  int
+	Inflight int
+	Dead     int
+	// Ids handed out so far
+	Inserted int
+	// In-flight events that were delivered before
+	Redelivered int
+	// How long the longest waiting pending event has been waiting
+	OldestAge time.Duration
+	// How long pending events have been waiting, in seconds
+	Age Percentiles
+	// Encoded payload sizes of pending and in-flight events, in bytes
+	Size Percentiles
+}
+
+// Read the queue's current depth, age and size distribution. Each percentile sorts the
+// pending events, so readings of very deep queues are not cheap
+func (a Analytics) Pulse() (Pulse, error) {
+	pulse := Pulse{At: time.Now()}
+	stats, err := countEvents(a.db, StatsFilter{})
+	if err != nil {
+		return pulse, err
+	}
+	pulse.Pending, pulse.Inflight, pulse.Dead = stats.Pending, stats.Inflight, stats.Dead
+	err = a.db.QueryRow(INSERTED_QUERY).Scan(&pulse.Inserted)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return pulse, fmt.Errorf("problem reading inserted events: %w", err)
+	}
+	if err := a.db.QueryRow(REDELIVERED_QUERY).Scan(&pulse.Redelivered); err != nil {
+		return pulse, fmt.Errorf("problem counting redelivered events: %w", err)
+	}
+	var oldest float64
+	if err := a.db.QueryRow(fmt.Sprintf("SELECT IFNULL(MAX(value), 0) FROM (%s)", PENDING_AGE_QUERY)).Scan(&oldest); err != nil {
+		return pulse, fmt.Errorf("problem finding oldest event: %w", err)
+	}
+	pulse.OldestAge = time.Duration(oldest * float64(time.Second))
+	if pulse.Age, err = a.percentiles(PENDING_AGE_QUERY); err != nil {
+		return pulse, err
+	}
+	if pulse.Size, err = a.percentiles(PAYLOAD_SIZE_QUERY); err != nil {
+		return pulse, err
+	}
+	return pulse, nil
+}
+
+// Events inserted and resolved (acked or dead lettered) per second between earlier and p.
+// Resolved events are derived from how the backlog changed, so moving events between queues
+// or purging dead letters in between skews it
+func (p Pulse) Throughput(earlier Pulse) (inserted float64, resolved float64) {
+	seconds := p.At.Sub(earlier.At).Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	newEvents := p.Inserted - earlier.Inserted
+	backlogGrowth := (p.Pending + p.Inflight) - (earlier.Pending + earlier.Inflight)
+	return float64(newEvents) / seconds, float64(max(newEvents-backlogGrowth, 0)) / seconds
+}
+
+// The percentiles of values, a query with a single column called value. Zero if it selects
+// nothing
+func (a Analytics) percentiles(values string) (Percentiles, error) {
+	var n int
+	if err := a.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s)", values)).Scan(&n); err != nil {
+		return Percentiles{}, fmt.Errorf("problem computing percentiles: %w", err)
+	}
+	if n == 0 {
+		return Percentiles{}, nil
+	}
+	query := fmt.Sprintf("SELECT value FROM (%s) ORDER BY value LIMIT 1 OFFSET ?", values)
+	var result Percentiles
+	for _, p := range []struct {
+		rank float64
+		dest *float64
+	}{{0.50, &result.P50}, {0.95, &result.P95}, {0.99, &result.P99}} {
+		// Nearest rank
+		offset := int(p.rank * float64(n-1))
+		if err := a.db.QueryRow(query, offset).Scan(p.dest); err != nil {
+			return Percentiles{}, fmt.Errorf("problem computing percentiles: %w", err)
+		}
+	}
+	return result, nil
+}
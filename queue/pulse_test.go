@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPulseReadsDepthAndDistributions(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	for i := 1; i <= 100; i++ {
+		if err := q.Insert(Test{A: strings.Repeat("x", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec("UPDATE queue SET claim_expires = NULL, enqueued_at = datetime('now', '-1 hour') WHERE id = ?", event.Id); err != nil {
+		t.Fatal(err)
+	}
+	redelivered, err := q.Next()
+	if err != nil || redelivered == nil || redelivered.Id != event.Id {
+		t.Fatalf("expected the nacked event to be redelivered, got %v", err)
+	}
+
+	pulse, err := q.Analytics().Pulse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulse.Pending != 99 || pulse.Inflight != 1 || pulse.Inserted != 100 || pulse.Redelivered != 1 {
+		t.Fatalf("unexpected counts %+v", pulse)
+	}
+	// Payloads are {"A":"x..."}, 8 bytes of JSON around 1 to 100 x's
+	if pulse.Size.P50 != 58 || pulse.Size.P99 != 107 {
+		t.Fatalf("unexpected size percentiles %+v", pulse.Size)
+	}
+	// The redelivered event is in flight, every pending one was just inserted
+	if pulse.OldestAge > time.Minute || pulse.Age.P99 > 60 {
+		t.Fatalf("unexpected ages %v %+v", pulse.OldestAge, pulse.Age)
+	}
+}
+
+func TestPulseThroughput(t *testing.T) {
+	earlier := Pulse{At: time.Unix(0, 0), Pending: 10, Inserted: 10}
+	later := Pulse{At: time.Unix(2, 0), Pending: 6, Inflight: 2, Inserted: 14}
+	inserted, resolved := later.Throughput(earlier)
+	// 4 new events while the backlog shrank by 2, so 6 were resolved
+	if inserted != 2 || resolved != 3 {
+		t.Fatalf("expected 2 inserted and 3 resolved per second, got %v and %v", inserted, resolved)
+	}
+}
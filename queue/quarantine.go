@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Dead letter reason of events whose payload the queue's codec failed to decode, see
+// WithDecodeQuarantine
+const DEAD_REASON_UNDECODABLE = "undecodable"
+
+// Move events whose payload can't be decoded into T to the dead letter table with reason
+// DEAD_REASON_UNDECODABLE as they are claimed, and carry on with the next event. Without it
+// Next returns the decode error and the event stays at the head of the queue, blocking every
+// consumer until it is removed. See QuarantineDiffs for finding out why they didn't decode
+func (q *Queue[T]) WithDecodeQuarantine() *Queue[T] {
+	q.decodeQuarantine = true
+	return q
+}
+
+// Dead letter the in-flight event with id as undecodable
+func quarantineEvent(tx *sql.Tx, id int, ts *transitions) error {
+	moved, err := moveEvents(tx, INFLIGHT_TABLE, DEAD_TABLE, "id = ?", id)
+	if err != nil {
+		return fmt.Errorf("problem quarantining event %d: %w", id, err)
+	}
+	return markDead(tx, moved, DEAD_REASON_UNDECODABLE, ts)
+}
+
+// Dead events quarantined because their payload didn't decode, oldest first. At most limit,
+// all of them if 0
+func (q *Queue[T]) Quarantined(limit int) ([]Envelope, error) {
+	dead, err := q.List(ListFilter{State: EVENT_STATE_DEAD})
+	if err != nil {
+		return nil, err
+	}
+	quarantined := []Envelope{}
+	for _, envelope := range dead {
+		if envelope.DeadReason != DEAD_REASON_UNDECODABLE {
+			continue
+		}
+		quarantined = append(quarantined, envelope)
+		if len(quarantined) == limit {
+			break
+		}
+	}
+	return quarantined, nil
+}
+
+// How a stored JSON payload differs from the fields a payload type expects, with paths as in
+// PayloadSchema
+type PayloadDiff struct {
+	Id      int
+	Payload json.RawMessage
+	// Expected fields the payload doesn't have, they decode to their zero value
+	Missing []string
+	// Fields of the payload the type doesn't have, they are dropped when decoding
+	Extra []string
+	// Fields whose JSON kind doesn't match the type, e.g. "Amount: stored string, expected
+	// integer". The usual cause of a decode failure
+	Mismatched []string
+	// Why decoding failed, set by QuarantineDiffs
+	DecodeError string
+}
+
+// Compare each quarantined event's stored payload with what T expects, to diagnose schema
+// drift between producers and consumers. Requires a JSON codec
+func (q *Queue[T]) QuarantineDiffs(limit int) ([]PayloadDiff, error) {
+	quarantined, err := q.Quarantined(limit)
+	if err != nil {
+		return nil, err
+	}
+	expected := SchemaOf[T]()
+	diffs := []PayloadDiff{}
+	for _, envelope := range quarantined {
+		diff, err := DiffPayload(envelope.Payload, expected)
+		if err != nil {
+			diff.DecodeError = err.Error()
+		} else {
+			var payload T
+			if err := q.codec.Unmarshal(envelope.Payload, &payload); err != nil {
+				diff.DecodeError = err.Error()
+			}
+		}
+		diff.Id = envelope.Id
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Compare the JSON payload data with expected, e.g. SchemaOf[T]() or a snapshot written by
+// CheckPayloadCompatibility. Fails if data isn't JSON
+func DiffPayload(data []byte, expected PayloadSchema) (PayloadDiff, error) {
+	diff := PayloadDiff{Payload: data, Missing: []string{}, Extra: []string{}, Mismatched: []string{}}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return diff, fmt.Errorf("payload is not JSON: %w", err)
+	}
+	stored := PayloadSchema{}
+	describeJSON(stored, "", value, expected)
+
+	for _, path := range slices.Sorted(maps.Keys(expected)) {
+		_, parentStored := stored[parentPath(path)]
+		if _, ok := stored[path]; !ok && parentStored && !isElementPath(path) {
+			diff.Missing = append(diff.Missing, path)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(stored)) {
+		kind := stored[path]
+		want, ok := expected[path]
+		_, parentExpected := expected[parentPath(path)]
+		switch {
+		case !ok && parentExpected:
+			diff.Extra = append(diff.Extra, path)
+		case !ok, want == kind, want == SCHEMA_ANY:
+		case kind == SCHEMA_INTEGER && want == SCHEMA_NUMBER:
+			// Every integer decodes as a float
+		default:
+			diff.Mismatched = append(diff.Mismatched, fmt.Sprintf("%s: stored %s, expected %s", path, kind, want))
+		}
+	}
+	return diff, nil
+}
+
+// Record the JSON kind of value and everything in it in shape, following expected to tell
+// maps from structs and to match field names case-insensitively like encoding/json does
+func describeJSON(shape PayloadSchema, path string, value any, expected PayloadSchema) {
+	root := path
+	if root == "" {
+		root = "."
+	}
+	switch v := value.(type) {
+	case nil:
+		// null decodes into anything
+	case string:
+		recordKind(shape, expected, root, SCHEMA_STRING)
+	case bool:
+		recordKind(shape, expected, root, SCHEMA_BOOLEAN)
+	case json.Number:
+		kind := SCHEMA_NUMBER
+		if _, err := v.Int64(); err == nil {
+			kind = SCHEMA_INTEGER
+		}
+		recordKind(shape, expected, root, kind)
+	case []any:
+		recordKind(shape, expected, root, SCHEMA_ARRAY)
+		for _, element := range v {
+			describeJSON(shape, path+"[]", element, expected)
+		}
+	case map[string]any:
+		recordKind(shape, expected, root, SCHEMA_OBJECT)
+		if _, isMap := expected[path+"{}"]; isMap {
+			for _, element := range v {
+				describeJSON(shape, path+"{}", element, expected)
+			}
+			return
+		}
+		for key, element := range v {
+			describeJSON(shape, fieldPath(expected, path, key), element, expected)
+		}
+	}
+}
+
+// Record kind at path unless an element of the same array or map already had a kind that
+// doesn't match expected, so one bad element is reported however many good ones follow
+func recordKind(shape PayloadSchema, expected PayloadSchema, path string, kind string) {
+	if existing, ok := shape[path]; ok && existing != expected[path] {
+		return
+	}
+	shape[path] = kind
+}
+
+// The path of field key of the object at path, spelled as in expected if it matches one of
+// its fields ignoring case
+func fieldPath(expected PayloadSchema, path string, key string) string {
+	candidate := key
+	if path != "" {
+		candidate = path + "." + key
+	}
+	if _, ok := expected[candidate]; ok {
+		return candidate
+	}
+	for known := range expected {
+		if strings.EqualFold(known, candidate) && parentPath(known) == parentPath(candidate) {
+			return known
+		}
+	}
+	return candidate
+}
+
+// The path of the value containing the one at path, "." for top-level fields
+func parentPath(path string) string {
+	if isElementPath(path) {
+		path = path[:len(path)-2]
+		if path == "" {
+			return "."
+		}
+		return path
+	}
+	if i := strings.LastIndex(path, "."); i > 0 {
+		return path[:i]
+	}
+	return "."
+}
+
+// Whether path is that of the elements of an array or map rather than of a field
+func isElementPath(path string) bool {
+	return strings.HasSuffix(path, "[]") || strings.HasSuffix(path, "{}")
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecodeQuarantineSkipsUndecodableEvents(t *testing.T) {
+	type Test struct {
+		A     string
+		Count int
+	}
+	q := newTestQueue[Test](t).WithDecodeQuarantine()
+	for _, a := range []string{"bad", "good"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A producer on an older schema sent a number where a string is expected
+	if _, err := q.db.Exec(`UPDATE queue SET payload = '{"a": 5, "Legacy": true, "Nested": {"X": 1}}' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected the decodable event, got %v", err)
+	}
+	if event.Content.A != "good" {
+		t.Fatalf("expected the undecodable event to be skipped, got %+v", event.Content)
+	}
+	quarantined, err := q.Quarantined(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Id != 1 || quarantined[0].DeadReason != DEAD_REASON_UNDECODABLE {
+		t.Fatalf("expected event 1 to be quarantined, got %+v", quarantined)
+	}
+
+	diffs, err := q.QuarantineDiffs(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("expected one diff, got %d", len(diffs))
+	}
+	diff := diffs[0]
+	if diff.Id != 1 || diff.DecodeError == "" {
+		t.Fatalf("expected the decode error of event 1, got %+v", diff)
+	}
+	if !slices.Equal(diff.Missing, []string{"Count"}) {
+		t.Fatalf("expected Count to be missing, got %v", diff.Missing)
+	}
+	// Nested.X isn't reported on its own, Nested is extra as a whole
+	if !slices.Equal(diff.Extra, []string{"Legacy", "Nested"}) {
+		t.Fatalf("expected Legacy and Nested to be extra, got %v", diff.Extra)
+	}
+	// Field names match case-insensitively, like encoding/json
+	if !slices.Equal(diff.Mismatched, []string{"A: stored integer, expected string"}) {
+		t.Fatalf("expected A to be mismatched, got %v", diff.Mismatched)
+	}
+}
+
+func TestDecodeQuarantineOfTheOnlyPendingEvent(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithDecodeQuarantine()
+	if err := q.Insert(Test{A: "bad"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec(`UPDATE queue SET payload = '{"a": 5}' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event != nil {
+		t.Fatalf("expected nothing to be claimed, got %v: %v", event, err)
+	}
+	quarantined, err := q.Quarantined(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Id != 1 {
+		t.Fatalf("expected event 1 to stay quarantined, got %+v", quarantined)
+	}
+	if size, err := q.Size(); err != nil || size != 0 {
+		t.Fatalf("expected an empty queue, got %d: %v", size, err)
+	}
+}
+
+func TestDecodeErrorsBlockWithoutQuarantine(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	if err := q.Insert(Test{A: "bad"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec(`UPDATE queue SET payload = '{"A": 5}'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Next(); err == nil {
+		t.Fatal("expected a decode error")
+	}
+	if quarantined, err := q.Quarantined(0); err != nil || len(quarantined) != 0 {
+		t.Fatalf("expected nothing to be quarantined, got %d: %v", len(quarantined), err)
+	}
+}
+
+func TestDiffPayloadFollowsArraysAndMaps(t *testing.T) {
+	type Item struct {
+		Price float64
+	}
+	type Order struct {
+		Items  []Item
+		Totals map[string]int
+	}
+	diff, err := DiffPayload([]byte(`{"Items": [{"Price": "4"}, {"Price": 3}], "Totals": {"eur": 1.5}}`), SchemaOf[Order]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Items[].Price: stored string, expected number", "Totals{}: stored number, expected integer"}
+	if !slices.Equal(diff.Mismatched, expected) || len(diff.Missing) != 0 || len(diff.Extra) != 0 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if _, err := DiffPayload([]byte("not json"), SchemaOf[Order]()); err == nil {
+		t.Fatal("expected an error for a payload that isn't JSON")
+	}
+}
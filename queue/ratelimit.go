@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// What Insert does when the rate limit configured with WithInsertRateLimit is exceeded
+type ThrottlePolicy int
+
+const (
+	// Wait until the insert fits within the rate limit
+	THROTTLE_BLOCK ThrottlePolicy = iota
+	// Fail the insert with ErrInsertThrottled
+	THROTTLE_REJECT
+)
+
+// Returned by Insert when the insert rate limit is exceeded and the policy is THROTTLE_REJECT
+var ErrInsertThrottled = errors.New("insert rate limit exceeded")
+
+// Limit Insert to perSecond events per second on average, allowing bursts of up to burst
+// events, to protect the single writer of the database from bursty producers. Batches from
+// InsertBatch, SpawnChildren, InsertReserved and InsertReturningIDs count one per event and
+// are let through whole once the burst is available. Inserts over the limit either wait or
+// fail with ErrInsertThrottled depending on policy. The limit is per Queue, producers in
+// other processes are not counted. See InsertThrottleStats. A perSecond that isn't positive
+// is rejected with an error in the log, leaving the previous limit in place
+func (q *Queue[T]) WithInsertRateLimit(perSecond float64, burst int, policy ThrottlePolicy) *Queue[T] {
+	if !(perSecond > 0) {
+		q.logger().Error(fmt.Sprintf("ignoring insert rate limit of %v per second, the rate must be positive", perSecond))
+		return q
+	}
+	q.insertLimiter = &tokenBucket{
+		rate:   perSecond,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+		policy: policy,
+	}
+	return q
+}
+
+// Counters of inserts affected by the rate limit configured with WithInsertRateLimit
+type InsertThrottleStats struct {
+	// Inserts that waited for the rate limit
+	Delayed int64
+	// Inserts that failed with ErrInsertThrottled
+	Rejected int64
+	// Total time inserts spent waiting
+	Waited time.Duration
+}
+
+// Returns how many inserts have been throttled so far, all zero without WithInsertRateLimit
+func (q *Queue[T]) InsertThrottleStats() InsertThrottleStats {
+	if q.insertLimiter == nil {
+		return InsertThrottleStats{}
+	}
+	return InsertThrottleStats{
+		Delayed:  q.insertLimiter.delayed.Load(),
+		Rejected: q.insertLimiter.rejected.Load(),
+		Waited:   time.Duration(q.insertLimiter.waited.Load()),
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	policy ThrottlePolicy
+
+	delayed  atomic.Int64
+	rejected atomic.Int64
+	waited   atomic.Int64
+}
+
+// Take n tokens, waiting for them or failing with ErrInsertThrottled if they aren't
+// available. Takes of more tokens than the burst only need a full bucket and leave it in
+// debt, so later inserts make up for them
+func (b *tokenBucket) take(n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	needed := min(float64(n), b.burst)
+	if b.tokens >= needed {
+		b.tokens -= float64(n)
+		b.mu.Unlock()
+		return nil
+	}
+	if b.policy == THROTTLE_REJECT {
+		b.mu.Unlock()
+		b.rejected.Add(1)
+		return ErrInsertThrottled
+	}
+	// Reserve the tokens now so concurrent callers queue up behind each other
+	wait := time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+	b.tokens -= float64(n)
+	b.mu.Unlock()
+	b.delayed.Add(1)
+	b.waited.Add(int64(wait))
+	time.Sleep(wait)
+	return nil
+}
@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInsertRateLimit(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithInsertRateLimit(10, 2, THROTTLE_BLOCK)
+
+	start := time.Now()
+	for range 4 {
+		if err := q.Insert(Test{A: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The burst covers two inserts, the other two wait 100ms each
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected inserts to be throttled, took %s", elapsed)
+	}
+	stats := q.InsertThrottleStats()
+	if stats.Delayed != 2 || stats.Rejected != 0 || stats.Waited <= 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	q = q.WithInsertRateLimit(0.001, 1, THROTTLE_REJECT)
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "hello"}); !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected ErrInsertThrottled, got %v", err)
+	}
+	if stats := q.InsertThrottleStats(); stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	size, err := q.Size()
+	if err != nil || size != 5 {
+		t.Fatalf("expected 5 events, got %d: %v", size, err)
+	}
+}
+
+func TestInsertRateLimitRejectsNonPositiveRates(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	for _, rate := range []float64{0, -1} {
+		if q.WithInsertRateLimit(rate, 1, THROTTLE_REJECT).insertLimiter != nil {
+			t.Fatalf("expected a rate of %v to be rejected", rate)
+		}
+	}
+	q.WithInsertRateLimit(0.001, 1, THROTTLE_REJECT).WithInsertRateLimit(0, 1, THROTTLE_BLOCK)
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "hello"}); !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected the previous limit to stay in place, got %v", err)
+	}
+}
+
+func TestInsertRateLimitCountsBatchedEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithInsertRateLimit(0.001, 3, THROTTLE_REJECT)
+
+	if _, err := q.InsertBatch([]Test{{A: "one"}, {A: "two"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Only one of the burst's three tokens is left
+	if _, err := q.InsertBatch([]Test{{A: "three"}, {A: "four"}}); !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected ErrInsertThrottled, got %v", err)
+	}
+	if _, err := q.InsertReturningIDs([]Test{{A: "three"}, {A: "four"}}); !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected ErrInsertThrottled, got %v", err)
+	}
+	reservation, err := q.ReserveIDs(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.InsertReserved(reservation, []Test{{A: "three"}, {A: "four"}}); !errors.Is(err, ErrInsertThrottled) {
+		t.Fatalf("expected ErrInsertThrottled, got %v", err)
+	}
+	if err := q.Insert(Test{A: "three"}); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := q.Size(); err != nil || size != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", size, err)
+	}
+}
@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Queues opened with NewSharedLocalQueue in this process, by database url
+var sharedQueues = struct {
+	lock    sync.Mutex
+	entries map[string]*sharedQueue
+}{entries: map[string]*sharedQueue{}}
+
+type sharedQueue struct {
+	// A *Queue[T] for the payload type it was first opened with
+	queue   any
+	payload reflect.Type
+	refs    int
+}
+
+// Like NewLocalQueue, but every call with the same name in this process returns the same
+// Queue until each caller has closed it, so there is one maintenance goroutine and one lock
+// per database however many packages open it. opts only apply to the call that opens the
+// database, later calls share the Queue as configured, including by its With* methods.
+// Opening a name that is already open with a different payload type fails with a
+// *PayloadTypeCollisionError
+func NewSharedLocalQueue[T any](name string, opts ...Option) (*Queue[T], error) {
+	key := "file:.db/" + name + ".db"
+	sharedQueues.lock.Lock()
+	defer sharedQueues.lock.Unlock()
+	if entry, ok := sharedQueues.entries[key]; ok {
+		q, ok := entry.queue.(*Queue[T])
+		if !ok {
+			return nil, &PayloadTypeCollisionError{Queue: name, Open: entry.payload.String(), Requested: reflect.TypeFor[T]().String()}
+		}
+		entry.refs++
+		return q, nil
+	}
+	q, err := NewLocalQueue[T](name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	q.sharedKey = key
+	sharedQueues.entries[key] = &sharedQueue{queue: q, payload: reflect.TypeFor[T](), refs: 1}
+	return q, nil
+}
+
+// Stop background maintenance and close the database. A queue opened with
+// NewSharedLocalQueue is only closed once every caller that opened it has called Close,
+// so each caller must call it exactly once. The queue must not be used after Close
+func (q *Queue[T]) Close() error {
+	if q.sharedKey != "" && !releaseShared(q.sharedKey) {
+		return nil
+	}
+	var err error
+	q.closed.Do(func() {
+		close(q.stop)
+		defer releasePayloadType(payloadTypeKey(q.location))
+		// Wait for maintenance in progress to finish before pulling the database away
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		err = q.db.Close()
+		if q.snapshot != nil {
+			err = errors.Join(err, q.snapshot.db.Close())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("problem closing queue: %w", err)
+	}
+	return nil
+}
+
+// Drop a reference to the shared queue with key, reporting whether it was the last one
+func releaseShared(key string) bool {
+	sharedQueues.lock.Lock()
+	defer sharedQueues.lock.Unlock()
+	entry, ok := sharedQueues.entries[key]
+	if !ok {
+		return false
+	}
+	entry.refs--
+	if entry.refs > 0 {
+		return false
+	}
+	delete(sharedQueues.entries, key)
+	return true
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSharedLocalQueueIsReferenceCounted(t *testing.T) {
+	type Test struct{ A string }
+	name := randomString(10)
+	first, err := NewSharedLocalQueue[Test](name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(strings.TrimPrefix(first.DSN(), "file:"))
+		_ = os.Remove(".db")
+	})
+	second, err := NewSharedLocalQueue[Test](name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("expected opening the same name to return the shared queue")
+	}
+	if _, err := NewSharedLocalQueue[string](name); err == nil {
+		t.Fatal("expected an error opening the shared queue with another payload type")
+	}
+
+	// Still open for the second caller
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Insert(Test{A: "still open"}); err != nil {
+		t.Fatalf("expected the queue to stay open until every caller closed it: %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Insert(Test{A: "closed"}); err == nil {
+		t.Fatal("expected inserting into a closed queue to fail")
+	}
+
+	// A later open starts afresh with the same database
+	reopened, err := NewSharedLocalQueue[Test](name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reopened.Close()
+	}()
+	if reopened == first {
+		t.Fatal("expected a new queue once the shared one was closed")
+	}
+	if size, err := reopened.Size(); err != nil || size != 1 {
+		t.Fatalf("expected the event inserted before closing, got %d: %v", size, err)
+	}
+}
+
+func TestCloseStopsMaintenance(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-q.stop:
+	default:
+		t.Fatal("expected Close to stop background maintenance")
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("expected closing twice to be harmless: %v", err)
+	}
+}
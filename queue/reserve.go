@@ -0,0 +1,286 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Returned by InsertReserved when an id was never reserved or was already used
+var ErrIDNotReserved = errors.New("id is not reserved or was already used")
+
+const CREATE_RESERVATIONS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_reservations (
+    id INTEGER PRIMARY KEY,              -- reserved but not yet inserted
+    reserved_at TEXT DEFAULT (datetime('now', 'utc'))
+);
+`
+
+// The highest id handed out so far, including to events that have since been acked
+const LAST_ID_QUERY = `
+SELECT MAX(
+    IFNULL((SELECT seq FROM sqlite_sequence WHERE name = 'queue'), 0),
+    IFNULL((SELECT MAX(id) FROM queue_reservations), 0)
+)
+`
+
+const UPDATE_SEQUENCE_QUERY = `UPDATE sqlite_sequence SET seq = ? WHERE name = 'queue'`
+
+const INSERT_SEQUENCE_QUERY = `INSERT INTO sqlite_sequence (name, seq) VALUES ('queue', ?)`
+
+const RESERVE_ID_QUERY = `INSERT INTO queue_reservations (id) VALUES (?)`
+
+const USE_RESERVED_ID_QUERY = `DELETE FROM queue_reservations WHERE id = ?`
+
+// A contiguous range of event ids handed out by ReserveIDs
+type Reservation struct {
+	// The first reserved id, the others follow it
+	First int
+	Count int
+}
+
+// The reserved ids in order
+func (r Reservation) IDs() []int {
+	ids := make([]int, r.Count)
+	for i := range ids {
+		ids[i] = r.First + i
+	}
+	return ids
+}
+
+// Reserve n contiguous event ids without inserting anything, for producers that need to
+// know an event's id before committing an external side effect, e.g. storing the job id on
+// a user record. Insert the payloads with InsertReserved once the side effect is done.
+// Reserved ids are never handed out again, whether or not they are used.
+func (q *Queue[T]) ReserveIDs(n int) (Reservation, error) {
+	if n <= 0 {
+		return Reservation{}, fmt.Errorf("can't reserve %d ids", n)
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	tx, err := q.db.Begin()
+	if err != nil {
+		return Reservation{}, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	reservation, err := reserveIDs(tx, n)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("problem reserving %d ids: %w", n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Reservation{}, fmt.Errorf("problem reserving %d ids: %w", n, err)
+	}
+	return reservation, nil
+}
+
+// Advance the queue table's AUTOINCREMENT sequence past n ids and record them as reserved
+func reserveIDs(tx *sql.Tx, n int) (Reservation, error) {
+	var last int
+	if err := tx.QueryRow(LAST_ID_QUERY).Scan(&last); err != nil {
+		return Reservation{}, err
+	}
+	result, err := tx.Exec(UPDATE_SEQUENCE_QUERY, last+n)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return Reservation{}, err
+	} else if updated == 0 {
+		// Nothing was ever inserted, so SQLite hasn't created the sequence yet
+		if _, err := tx.Exec(INSERT_SEQUENCE_QUERY, last+n); err != nil {
+			return Reservation{}, err
+		}
+	}
+	reservation := Reservation{First: last + 1, Count: n}
+	for _, id := range reservation.IDs() {
+		if _, err := tx.Exec(RESERVE_ID_QUERY, id); err != nil {
+			return Reservation{}, err
+		}
+	}
+	return reservation, nil
+}
+
+// Insert payloads with the ids of reservation, in order, in a single transaction. Fails
+// without inserting anything if there are more payloads than reserved ids or any of the ids
+// was already used. Unused ids of the reservation stay reserved. Options apply to every
+// payload
+func (q *Queue[T]) InsertReserved(reservation Reservation, payloads []T, opts ...InsertOption) error {
+	if len(payloads) > reservation.Count {
+		return fmt.Errorf("%d payloads don't fit in a reservation of %d ids", len(payloads), reservation.Count)
+	}
+	pausedOpts, err := q.pausedInsertOptions()
+	if err != nil {
+		return err
+	}
+	envelopes, err := q.encodeAll(payloads, append(opts, pausedOpts...))
+	if err != nil {
+		return err
+	}
+	for i := range envelopes {
+		envelopes[i].Id = reservation.First + i
+	}
+	if q.insertLimiter != nil {
+		if err := q.insertLimiter.take(len(payloads)); err != nil {
+			return err
+		}
+	}
+	var ts transitions
+	err = q.retryTransient(func(opID string) error {
+		ts.reset()
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.insertReserved(envelopes, opID, &ts)
+	})
+	if err != nil {
+		return fmt.Errorf("problem inserting reserved events: %w", err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) insertReserved(envelopes []Envelope, opID string, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return err
+	}
+	if err := q.useReserved(tx, envelopes, ts); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+// Insert envelopes with the reserved ids they carry, failing if any of them isn't reserved.
+// Callers must hold q.lock
+func (q *Queue[T]) useReserved(tx *sql.Tx, envelopes []Envelope, ts *transitions) error {
+	for _, envelope := range envelopes {
+		result, err := tx.Exec(USE_RESERVED_ID_QUERY, envelope.Id)
+		if err != nil {
+			return err
+		}
+		if used, err := result.RowsAffected(); err != nil {
+			return err
+		} else if used == 0 {
+			return fmt.Errorf("id %d: %w", envelope.Id, ErrIDNotReserved)
+		}
+		if err := q.insertEncoded(tx, envelope, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert payloads like InsertReserved, reserving their ids in the same transaction, for
+// producers that only need to know the ids of the events once they are inserted. Nothing
+// is reserved when the insert fails
+func (q *Queue[T]) InsertReturningIDs(payloads []T, opts ...InsertOption) (Reservation, error) {
+	if len(payloads) == 0 {
+		return Reservation{}, fmt.Errorf("can't insert %d events", len(payloads))
+	}
+	pausedOpts, err := q.pausedInsertOptions()
+	if err != nil {
+		return Reservation{}, err
+	}
+	envelopes, err := q.encodeAll(payloads, append(opts, pausedOpts...))
+	if err != nil {
+		return Reservation{}, err
+	}
+	if q.insertLimiter != nil {
+		if err := q.insertLimiter.take(len(payloads)); err != nil {
+			return Reservation{}, err
+		}
+	}
+	var ts transitions
+	var reservation Reservation
+	var lastOpID string
+	err = q.retryTransient(func(opID string) error {
+		ts.reset()
+		lastOpID = opID
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		var err error
+		reservation, err = q.reserveAndInsert(envelopes, opID, &ts)
+		return err
+	})
+	if err == nil && reservation.Count == 0 {
+		// An earlier attempt was applied, but its response was lost
+		reservation, err = q.operationReservation(lastOpID)
+	}
+	if err != nil {
+		return Reservation{}, fmt.Errorf("problem inserting events: %w", err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return reservation, nil
+}
+
+func (q *Queue[T]) reserveAndInsert(envelopes []Envelope, opID string, ts *transitions) (Reservation, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return Reservation{}, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := recordOperation(tx, opID); err != nil {
+		return Reservation{}, err
+	}
+	reservation, err := reserveIDs(tx, len(envelopes))
+	if err != nil {
+		return Reservation{}, fmt.Errorf("problem reserving %d ids: %w", len(envelopes), err)
+	}
+	if opID != "" {
+		result, err := json.Marshal(reservation)
+		if err != nil {
+			return Reservation{}, err
+		}
+		if _, err := tx.Exec(KV_SET_QUERY, OPERATIONS_KV_SCOPE, opID, result); err != nil {
+			return Reservation{}, fmt.Errorf("problem recording the result of operation %s: %w", opID, err)
+		}
+	}
+	for i := range envelopes {
+		envelopes[i].Id = reservation.First + i
+	}
+	if err := q.useReserved(tx, envelopes, ts); err != nil {
+		return Reservation{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// The ids reserveAndInsert assigned in the operation with id: opID
+func (q *Queue[T]) operationReservation(opID string) (Reservation, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	var result []byte
+	if err := q.db.QueryRow(KV_GET_QUERY, OPERATIONS_KV_SCOPE, opID).Scan(&result); err != nil {
+		return Reservation{}, fmt.Errorf("problem reading the result of operation %s: %w", opID, err)
+	}
+	var reservation Reservation
+	if err := json.Unmarshal(result, &reservation); err != nil {
+		return Reservation{}, fmt.Errorf("problem decoding the result of operation %s: %w", opID, err)
+	}
+	return reservation, nil
+}
+
+// Encode payloads into envelopes with opts, without ids
+func (q *Queue[T]) encodeAll(payloads []T, opts []InsertOption) ([]Envelope, error) {
+	envelopes := make([]Envelope, len(payloads))
+	for i, payload := range payloads {
+		data, err := q.codec.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal data of type %T: %w", payload, err)
+		}
+		envelopes[i] = q.newEnvelope(data, opts...)
+	}
+	return envelopes, nil
+}
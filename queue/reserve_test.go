@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveIDs(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	reservation, err := q.ReserveIDs(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reservation.Count != 2 {
+		t.Fatalf("expected 2 reserved ids, got %v", reservation)
+	}
+	if err := q.Insert(Test{A: "unreserved"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.InsertReserved(reservation, []Test{{A: "first"}, {A: "second"}, {A: "third"}}); err == nil {
+		t.Fatal("expected more payloads than reserved ids to fail")
+	}
+	if err := q.InsertReserved(reservation, []Test{{A: "first"}, {A: "second"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.InsertReserved(reservation, []Test{{A: "again"}}); err == nil {
+		t.Fatal("expected reusing a reserved id to fail")
+	}
+
+	seen := map[int]string{}
+	for range 3 {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		seen[event.Id] = event.Content.A
+	}
+	for i, want := range []string{"first", "second"} {
+		if got := seen[reservation.IDs()[i]]; got != want {
+			t.Fatalf("expected %q at id %d, got %q", want, reservation.IDs()[i], got)
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected the unreserved insert to get a fresh id, got %v", seen)
+	}
+}
+
+func TestInsertReturningIDs(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	// An insert that fails leaves nothing reserved
+	if _, err := q.db.Exec(`CREATE TRIGGER reject BEFORE INSERT ON queue BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.InsertReturningIDs([]Test{{A: "rejected"}}); err == nil {
+		t.Fatal("expected the insert to fail")
+	}
+	var reserved int
+	if err := q.db.QueryRow(`SELECT COUNT(*) FROM queue_reservations`).Scan(&reserved); err != nil || reserved != 0 {
+		t.Fatalf("expected no reserved ids, got %d: %v", reserved, err)
+	}
+	if _, err := q.db.Exec(`DROP TRIGGER reject`); err != nil {
+		t.Fatal(err)
+	}
+
+	reservation, err := q.InsertReturningIDs([]Test{{A: "first"}, {A: "second"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reservation.First != 1 || reservation.Count != 2 {
+		t.Fatalf("expected ids 1 and 2, got %+v", reservation)
+	}
+	for i, want := range []string{"first", "second"} {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		if event.Id != reservation.IDs()[i] || event.Content.A != want {
+			t.Fatalf("expected %q at id %d, got %q at %d", want, reservation.IDs()[i], event.Content.A, event.Id)
+		}
+	}
+}
+
+func TestInsertReturningIDsAfterALostResponse(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithIdempotentRetries(3, time.Millisecond)
+
+	// The first attempt is committed but its response is lost
+	var first Reservation
+	var lastOpID string
+	attempts := 0
+	err := q.retryTransient(func(opID string) error {
+		attempts++
+		lastOpID = opID
+		var ts transitions
+		q.lock.Lock()
+		reservation, err := q.reserveAndInsert([]Envelope{newEnvelope([]byte(`{"A":"once"}`))}, opID, &ts)
+		q.lock.Unlock()
+		if err == nil && attempts == 1 {
+			first = reservation
+			return errors.New("hrana: 503 Service Unavailable")
+		}
+		return err
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected a single retry, got %d attempts: %v", attempts, err)
+	}
+	reservation, err := q.operationReservation(lastOpID)
+	if err != nil || reservation != first {
+		t.Fatalf("expected the ids of the applied attempt %+v, got %+v: %v", first, reservation, err)
+	}
+	if size, err := q.Size(); err != nil || size != 1 {
+		t.Fatalf("expected the event to be inserted once, got %d: %v", size, err)
+	}
+}
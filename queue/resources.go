@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"slices"
+)
+
+// Only claim an event while, for every resource token it needs that has a capacity, fewer
+// in-flight events need the token than its capacity. Bound to a JSON object of capacities
+const RESOURCE_CAPACITY_CONDITION = `NOT EXISTS (
+    SELECT 1 FROM json_each(queue.resources) AS needed
+    JOIN json_each(?) AS capacity ON capacity.key = needed.value
+    WHERE (SELECT COUNT(*) FROM queue_inflight, json_each(queue_inflight.resources) AS held WHERE held.value = needed.value) >= capacity.value
+)`
+
+// Declare the resource tokens processing the event needs, e.g. "gpu" or "smtp-conn". While
+// as many events needing a token are in flight as the capacity configured for it with
+// WithResourceCapacity, events needing it aren't claimed. Tokens without a capacity are
+// unlimited
+func WithResourceTokens(tokens ...string) InsertOption {
+	return func(e *Envelope) {
+		for _, token := range tokens {
+			if token != "" && !slices.Contains(e.Resources, token) {
+				e.Resources = append(e.Resources, token)
+			}
+		}
+	}
+}
+
+// Claim at most capacity events needing token at once across every process consuming the
+// queue, see WithResourceTokens. Events needing several tokens are only claimed when all of
+// them are available, other events are claimed as usual. Like WithMaxInFlight the limit is
+// enforced by the claim query and expired claims count until they are reclaimed. 0 removes
+// the limit
+func (q *Queue[T]) WithResourceCapacity(token string, capacity int) *Queue[T] {
+	capacities := maps.Clone(q.resourceCapacities)
+	if capacities == nil {
+		capacities = map[string]int{}
+	}
+	if capacity > 0 {
+		capacities[token] = capacity
+	} else {
+		delete(capacities, token)
+	}
+	q.resourceCapacities = capacities
+	return q
+}
+
+// Resource tokens as they are stored in the database
+func encodeResources(tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decodeResources(resources sql.NullString) ([]string, error) {
+	if !resources.Valid || resources.String == "" {
+		return nil, nil
+	}
+	decoded := []string{}
+	if err := json.Unmarshal([]byte(resources.String), &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
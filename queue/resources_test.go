@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResourceCapacity(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithResourceCapacity("gpu", 1).WithResourceCapacity("smtp", 2)
+	inserts := []struct {
+		name   string
+		tokens []string
+	}{
+		{"gpu-1", []string{"gpu"}},
+		{"gpu-2", []string{"gpu", "gpu"}},
+		{"mail-1", []string{"smtp"}},
+		{"both", []string{"gpu", "smtp"}},
+		{"mail-2", []string{"smtp"}},
+		{"mail-3", []string{"smtp"}},
+		{"plain", nil},
+	}
+	for _, insert := range inserts {
+		if err := q.Insert(Test{A: insert.name}, WithResourceTokens(insert.tokens...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed := []string{}
+	for {
+		event, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			break
+		}
+		claimed = append(claimed, event.Content.A)
+		if event.Content.A == "gpu-1" && !slices.Equal(event.Envelope.Resources, []string{"gpu"}) {
+			t.Fatalf("expected the event's resource tokens, got %v", event.Envelope.Resources)
+		}
+	}
+	// One gpu and two smtp events at once, events without tokens aren't limited
+	expected := []string{"gpu-1", "mail-1", "mail-2", "plain"}
+	if !slices.Equal(claimed, expected) {
+		t.Fatalf("expected %v to be claimed, got %v", expected, claimed)
+	}
+
+	// Removing the capacity lifts the limit
+	q.WithResourceCapacity("gpu", 0)
+	event, err := q.Next()
+	if err != nil || event == nil || event.Content.A != "gpu-2" {
+		t.Fatalf("expected gpu-2 once gpu is unlimited, got %v: %v", event, err)
+	}
+}
@@ -0,0 +1,315 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Events live in one of three tables depending on their state and are moved between them
+// on every transition. Keeping them apart means the claim path only ever looks at pending
+// events, no matter how much history has built up in the dead letter table.
+const (
+	// Events waiting to be claimed, including those in their retry backoff period
+	PENDING_TABLE = "queue"
+	// Events currently claimed by a consumer
+	INFLIGHT_TABLE = "queue_inflight"
+	// Events that will not be delivered again, e.g. because they exhausted their retries
+	DEAD_TABLE = "queue_dead"
+)
+
+var EVENT_TABLES = []string{PENDING_TABLE, INFLIGHT_TABLE, DEAD_TABLE}
+
+// Acked events, only kept for queues configured WithArchive. Not an event table since
+// archived events are history rather than state, but it has the same columns
+const ARCHIVE_TABLE = "queue_archive"
+
+// Acked events that are kept for a grace period in case they need to be unacked, see
+// WithAckGracePeriod and CompleteAt
+const COMPLETED_TABLE = "queue_completed"
+
+// Tables holding events that have been acked
+var ACKED_TABLES = []string{COMPLETED_TABLE, ARCHIVE_TABLE}
+
+// The columns every event table was created with, see eventColumns for the full list
+var BASE_EVENT_COLUMNS = []string{"id", "payload", "enqueued_at", "claimed", "claim_expires", "retries"}
+
+const CREATE_INFLIGHT_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_inflight (
+    id INTEGER PRIMARY KEY,              -- same id the event had while pending
+    payload TEXT NOT NULL,
+    enqueued_at TEXT,
+    claimed INTEGER DEFAULT 1,
+    claim_expires TEXT,                 -- when the claim runs out
+    retries INTEGER DEFAULT 0
+);
+`
+
+const CREATE_DEAD_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_dead (
+    id INTEGER PRIMARY KEY,              -- same id the event had while pending
+    payload TEXT NOT NULL,
+    enqueued_at TEXT,
+    claimed INTEGER DEFAULT 0,
+    claim_expires TEXT,
+    retries INTEGER DEFAULT 0,
+    dead_at TEXT DEFAULT (datetime('now', 'utc')),
+    reason TEXT                          -- why the event will not be delivered again
+);
+`
+
+const CREATE_ARCHIVE_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_archive (
+    id INTEGER PRIMARY KEY,              -- same id the event had while pending
+    payload TEXT NOT NULL,
+    enqueued_at TEXT,
+    claimed INTEGER DEFAULT 0,
+    claim_expires TEXT,
+    retries INTEGER DEFAULT 0,
+    acked_at TEXT DEFAULT (datetime('now', 'utc'))
+);
+`
+
+const CREATE_COMPLETED_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_completed (
+    id INTEGER PRIMARY KEY,              -- same id the event had while pending
+    payload TEXT NOT NULL,
+    enqueued_at TEXT,
+    claimed INTEGER DEFAULT 0,
+    claim_expires TEXT,
+    retries INTEGER DEFAULT 0,
+    completed_at TEXT DEFAULT (datetime('now', 'utc')),
+    purge_at TEXT                        -- when the event is removed for good
+);
+`
+
+const CREATE_ARCHIVE_ACKED_AT_INDEX_STATEMENT = `CREATE INDEX IF NOT EXISTS idx_archive_acked_at ON queue_archive (acked_at);`
+
+// Columns added to the event tables after they were first released. CREATE TABLE IF NOT
+// EXISTS leaves existing tables alone, so these are added to databases created by older
+// versions when they are opened.
+var ADDED_COLUMNS = []struct{ name, definition string }{
+	{"payload_hash", "TEXT"},
+	{"kind", "TEXT"},
+	{"headers", "TEXT"}, // JSON object
+	{"schema_version", "INTEGER DEFAULT 0"},
+	{"unacked", "INTEGER DEFAULT 0"}, // 1 once the event was returned to the queue by Unack
+	{"batch_id", "TEXT"},             // set by InsertBatch
+	{"parent_id", "INTEGER"},         // set by SpawnChildren
+	{"expires_at", "REAL"},           // unix time, set by WithExpiresAt
+	// Set by WithEventDeliveryWindow, seconds since local midnight and the local offset from UTC
+	{"window_start", "INTEGER"},
+	{"window_end", "INTEGER"},
+	{"window_offset", "INTEGER"},
+	{"claimed_by", "TEXT"},     // worker id of the current claim, see WithWorkerID
+	{"reassigned_to", "TEXT"},  // worker id only this event may be claimed by, see ForceReassign
+	{"attempts", "TEXT"},       // JSON array of the event's claims, see Envelope.Attempts
+	{"claimed_epoch", "TEXT"},  // instance of the queue holding the claim, see Queue.Epoch
+	{"resources", "TEXT"},      // JSON array of resource tokens, see WithResourceTokens
+	{"max_retries", "INTEGER"}, // set by WithEventMaxRetries
+	{"claim_timeout", "REAL"},  // seconds, set by WithEventClaimTimeout
+	{"retention", "REAL"},      // seconds, set by WithEventRetention
+}
+
+// Declared types of the columns in BASE_EVENT_COLUMNS
+var BASE_EVENT_COLUMN_TYPES = map[string]string{
+	"id":            "INTEGER",
+	"payload":       "TEXT",
+	"enqueued_at":   "TEXT",
+	"claimed":       "INTEGER",
+	"claim_expires": "TEXT",
+	"retries":       "INTEGER",
+}
+
+// Columns only the dead letter table has and their declared types
+var DEAD_COLUMN_TYPES = map[string]string{
+	"dead_at": "TEXT",
+	"reason":  "TEXT",
+}
+
+// The columns shared by every event table, copied as-is when an event changes table
+func eventColumns() []string {
+	names := append([]string{}, BASE_EVENT_COLUMNS...)
+	for _, column := range ADDED_COLUMNS {
+		names = append(names, column.name)
+	}
+	return names
+}
+
+const CREATE_PAYLOAD_HASH_INDEX_STATEMENT = `CREATE INDEX IF NOT EXISTS idx_payload_hash ON queue (payload_hash) WHERE payload_hash IS NOT NULL;`
+
+// Bring the schema of an existing database up to date
+func migrate(db *sql.DB) error {
+	for _, statement := range []string{CREATE_INFLIGHT_TABLE_STATEMENT, CREATE_DEAD_TABLE_STATEMENT, CREATE_ARCHIVE_TABLE_STATEMENT, CREATE_COMPLETED_TABLE_STATEMENT} {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	for _, table := range slices.Concat(EVENT_TABLES, ACKED_TABLES) {
+		existing, err := tableInfo(db, table)
+		if err != nil {
+			return err
+		}
+		for _, column := range ADDED_COLUMNS {
+			if _, ok := existing[column.name]; ok {
+				continue
+			}
+			_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.name, column.definition))
+			if err != nil {
+				return fmt.Errorf("problem adding column %s to %s table: %w", column.name, table, err)
+			}
+		}
+	}
+	statements := []string{CREATE_PAYLOAD_HASH_INDEX_STATEMENT, CREATE_ARCHIVE_ACKED_AT_INDEX_STATEMENT, CREATE_MIGRATIONS_TABLE_STATEMENT, CREATE_RESERVATIONS_TABLE_STATEMENT, CREATE_TAGS_TABLE_STATEMENT, CREATE_TAGS_EVENT_ID_INDEX_STATEMENT, CREATE_BATCHES_TABLE_STATEMENT, CREATE_ARCHIVE_CHAIN_TABLE_STATEMENT, CREATE_PAUSED_TABLE_STATEMENT, CREATE_HANDOFFS_TABLE_STATEMENT, CREATE_OPERATIONS_TABLE_STATEMENT, CREATE_KV_TABLE_STATEMENT, CREATE_ARCHIVE_CHECKPOINTS_TABLE_STATEMENT}
+	for _, statement := range slices.Concat(statements, CREATE_BATCH_INDEX_STATEMENTS) {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return moveLegacyClaims(db)
+}
+
+// Before events were split across tables claimed events stayed in the queue table with
+// claimed = 1, move any that are left over to where they belong now
+func moveLegacyClaims(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if _, err := moveEvents(tx, PENDING_TABLE, INFLIGHT_TABLE, "claimed = 1"); err != nil {
+		return fmt.Errorf("problem moving claimed events to %s: %w", INFLIGHT_TABLE, err)
+	}
+	return tx.Commit()
+}
+
+// Move the events matching where from one event table to another, returning their ids
+func moveEvents(tx *sql.Tx, from string, to string, where string, args ...any) ([]int, error) {
+	cols := strings.Join(eventColumns(), ", ")
+	rows, err := tx.Query(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s RETURNING id",
+		to, cols, cols, from, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	placeholders, idArgs := inClause(ids)
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", from, placeholders), idArgs...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Copy the event with id: id from table to the archive table
+func archiveEvent(tx *sql.Tx, table string, id int) error {
+	cols := strings.Join(eventColumns(), ", ")
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE id = ?", ARCHIVE_TABLE, cols, cols, table), id)
+	if err != nil {
+		return fmt.Errorf("problem archiving event %d: %w", id, err)
+	}
+	return nil
+}
+
+// Placeholders and arguments for an IN (...) clause over ids
+func inClause(ids []int) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// Returned by queues opened WithStrictSchema when the database doesn't have the schema the
+// queue expects
+var ErrSchemaMismatch = errors.New("queue schema mismatch")
+
+type columnInfo struct {
+	declaredType string
+	notNull      bool
+	hasDefault   bool
+}
+
+// Check that every event table has the columns the queue reads and writes with the expected
+// types, that columns added by other tools don't prevent inserts and that no pending event
+// is missing its payload. All problems found are reported together
+func validateSchema(db *sql.DB) error {
+	problems := []string{}
+	for _, table := range EVENT_TABLES {
+		info, err := tableInfo(db, table)
+		if err != nil {
+			return err
+		}
+		expected := map[string]string{}
+		for name, declaredType := range BASE_EVENT_COLUMN_TYPES {
+			expected[name] = declaredType
+		}
+		for _, column := range ADDED_COLUMNS {
+			expected[column.name] = strings.Fields(column.definition)[0]
+		}
+		if table == DEAD_TABLE {
+			for name, declaredType := range DEAD_COLUMN_TYPES {
+				expected[name] = declaredType
+			}
+		}
+		for name, declaredType := range expected {
+			column, ok := info[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", table, name))
+			} else if !strings.EqualFold(column.declaredType, declaredType) {
+				problems = append(problems, fmt.Sprintf("%s.%s has type %q, expected %s", table, name, column.declaredType, declaredType))
+			}
+		}
+		for name, column := range info {
+			if _, ok := expected[name]; !ok && column.notNull && !column.hasDefault {
+				problems = append(problems, fmt.Sprintf("%s.%s is NOT NULL without a default, inserts would fail", table, name))
+			}
+		}
+	}
+	var nullPayloads int
+	if err := db.QueryRow("SELECT COUNT(*) FROM queue WHERE payload IS NULL").Scan(&nullPayloads); err != nil {
+		return fmt.Errorf("problem validating schema: %w", err)
+	}
+	if nullPayloads > 0 {
+		problems = append(problems, fmt.Sprintf("%d pending events have a NULL payload and will never be delivered", nullPayloads))
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("%w: %s", ErrSchemaMismatch, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// The columns of table by name
+func tableInfo(db *sql.DB, table string) (map[string]columnInfo, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name, type, \"notnull\", dflt_value IS NOT NULL FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return nil, fmt.Errorf("problem reading columns of table %s: %w", table, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	info := map[string]columnInfo{}
+	for rows.Next() {
+		var name string
+		var column columnInfo
+		if err := rows.Scan(&name, &column.declaredType, &column.notNull, &column.hasDefault); err != nil {
+			return nil, fmt.Errorf("problem reading columns of table %s: %w", table, err)
+		}
+		info[name] = column
+	}
+	return info, rows.Err()
+}
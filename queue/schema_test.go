@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRowsFromExternalWriters(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	// Another tool that doesn't know about the defaults
+	if _, err := q.db.Exec(`INSERT INTO queue (payload, enqueued_at, retries) VALUES ('{"A":"external"}', NULL, NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	size, err := q.Size()
+	if err != nil || size != 1 {
+		t.Fatalf("expected size 1, got %d: %v", size, err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if event.Content.A != "external" || event.Envelope.Retries != 0 {
+		t.Fatalf("unexpected event %+v", event.Envelope)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	peeked, err := q.Peek(event.Id)
+	if err != nil || peeked == nil || peeked.Retries != 1 {
+		t.Fatalf("expected 1 retry, got %v: %v", peeked, err)
+	}
+}
+
+func TestStrictSchema(t *testing.T) {
+	type Test struct{ A string }
+	name := randomString(10)
+	path := ".db/" + name + ".db"
+	if err := os.MkdirAll(".db", 0775); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(path)
+		_ = os.Remove(".db")
+	})
+
+	// Tables created by some other tool
+	db, err := sql.Open("libsql", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE queue (id INTEGER PRIMARY KEY AUTOINCREMENT, payload BLOB, enqueued_at TEXT, claimed INTEGER, claim_expires TEXT, retries INTEGER)`,
+		`CREATE TABLE queue_inflight (id INTEGER PRIMARY KEY, payload TEXT, enqueued_at TEXT, claimed INTEGER, claim_expires TEXT, retries INTEGER, owner TEXT NOT NULL)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewLocalQueue[Test](name, WithStrictSchema())
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected ErrSchemaMismatch, got %v", err)
+	}
+	for _, problem := range []string{"queue.payload has type \"BLOB\"", "queue_inflight.owner is NOT NULL"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q to be reported, got %v", problem, err)
+		}
+	}
+
+	if _, err := NewLocalQueue[Test](name); err != nil {
+		t.Fatalf("expected the queue to open without StrictSchema, got %v", err)
+	}
+}
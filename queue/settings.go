@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Settings that maintenance dead letters, purges and archives events by. They are stored in
+// SETTINGS_KV_SCOPE rather than only kept in memory, so every process working on a queue
+// follows the same rules, including operational tools that open it with the defaults.
+// Setting one through any Queue changes it for all of them, other processes pick it up when
+// their maintenance next runs
+const (
+	MAX_RETRIES_SETTING        = "max_retries"
+	ARCHIVE_SETTING            = "archive"
+	ARCHIVE_HASH_CHAIN_SETTING = "archive_hash_chain"
+)
+
+const SETTINGS_QUERY = `SELECT key, value FROM queue_kv WHERE scope = ? AND key IN (?, ?, ?)`
+
+// Apply the stored settings to q, leaving those that were never stored at their current
+// values. Callers must hold q.lock
+func (q *Queue[T]) loadSettings() error {
+	rows, err := q.db.Query(SETTINGS_QUERY, SETTINGS_KV_SCOPE, MAX_RETRIES_SETTING, ARCHIVE_SETTING, ARCHIVE_HASH_CHAIN_SETTING)
+	if err != nil {
+		return fmt.Errorf("problem reading settings: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return fmt.Errorf("problem reading settings: %w", err)
+		}
+		var setting any
+		switch key {
+		case MAX_RETRIES_SETTING:
+			setting = &q.maxRetries
+		case ARCHIVE_SETTING:
+			setting = &q.archive
+		case ARCHIVE_HASH_CHAIN_SETTING:
+			setting = &q.hashChain
+		}
+		if err := json.Unmarshal(value, setting); err != nil {
+			return fmt.Errorf("problem decoding setting %s: %w", key, err)
+		}
+	}
+	return rows.Err()
+}
+
+// The retry limit, which maintenance may update from the database at any time
+func (q *Queue[T]) retryLimit() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return q.maxRetries
+}
+
+// Whether acked events are archived, which maintenance may update from the database at any
+// time
+func (q *Queue[T]) archived() bool {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return q.archive
+}
+
+// Store a setting for every process working on the queue. With* methods can't fail, so
+// failures are logged and the setting only applies to q. Callers must hold q.lock
+func (q *Queue[T]) storeSetting(key string, value any) {
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		_, err = q.db.Exec(KV_SET_QUERY, SETTINGS_KV_SCOPE, key, encoded)
+	}
+	if err != nil {
+		q.logger().Error(fmt.Sprintf("problem storing setting %s, it only applies to this process: %v", key, err))
+	}
+}
+
+const REVIVED_QUERY_TEMPLATE = `SELECT ` + ENVELOPE_COLUMNS + ` FROM queue WHERE id IN (%s)`
+
+// Return events that were dead lettered for running out of retries to the queue once the
+// retry limit was raised above their retries. Callers must hold q.lock
+func (q *Queue[T]) reviveRetriable(ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	ids, err := moveEvents(tx, DEAD_TABLE, PENDING_TABLE, "reason = ? AND retries <= "+RETRY_LIMIT_EXPRESSION, DEAD_REASON_MAX_RETRIES, q.maxRetries)
+	if err != nil {
+		return fmt.Errorf("problem returning events with retries left from the dead letter table: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders, args := inClause(ids)
+	envelopes, err := queryEnvelopes(tx, fmt.Sprintf(REVIVED_QUERY_TEMPLATE, placeholders), EVENT_STATE_PENDING, args...)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, envelope := range envelopes {
+		ts.add(TRANSITION_REVIVED, envelope)
+	}
+	q.logger().Info(fmt.Sprintf("Returned %d dead events to the queue after the retry limit was raised to %d", len(ids), q.maxRetries))
+	return nil
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestSettingsAreSharedThroughTheDatabase(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithMaxRetires(3).WithArchive()
+
+	// Another process, e.g. the CLI, opening the queue with the defaults
+	other, err := openQueue[Test](q.DSN(), WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = other.db.Close()
+	})
+	if other.maxRetries != 3 || !other.archive {
+		t.Fatalf("expected the stored settings to be loaded, got max retries %d and archive %v", other.maxRetries, other.archive)
+	}
+
+	other.WithMaxRetires(7)
+	var ts transitions
+	q.lock.Lock()
+	err = q.maintain(&ts)
+	maxRetries := q.maxRetries
+	q.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxRetries != 7 {
+		t.Fatalf("expected maintenance to pick up the new retry limit, got %d", maxRetries)
+	}
+}
+
+func TestRaisingMaxRetriesRevivesDeadEvents(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithMaxRetires(0)
+
+	if err := q.Insert(Test{A: "failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "cancelled"}, WithTags("cancelled")); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatal(err)
+	}
+	if err := q.Nack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.CancelTagged("cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if dead, _ := q.DeadSize(); dead != 2 {
+		t.Fatalf("expected both events to be dead, got %d", dead)
+	}
+
+	revived := []int{}
+	q.WithOnTransition(func(transition Transition, envelope Envelope) {
+		if transition == TRANSITION_REVIVED {
+			revived = append(revived, envelope.Id)
+		}
+	})
+	q.WithMaxRetires(2)
+	if len(revived) != 1 || revived[0] != event.Id {
+		t.Fatalf("expected only the exhausted event to be revived, got %v", revived)
+	}
+	if dead, _ := q.DeadSize(); dead != 1 {
+		t.Fatalf("expected the cancelled event to stay dead, got %d dead events", dead)
+	}
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("expected the revived event to be back in the queue, got size %d", size)
+	}
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"strconv"
+)
+
+// Header set on shadow copies to the id of the event they were copied from, see WithShadow
+const SHADOW_SOURCE_HEADER = "shadow-of"
+
+// Copy percent (0 to 100) of the events inserted through this Queue into shadow, e.g. to run
+// a new handler implementation against production traffic. Events are sampled independently
+// and copied with their kind, headers, tags and expiry right after their insert committed.
+// Copies are best effort: failing to make one is logged and never fails the insert, and
+// nothing done with the copies affects the original events. A nil shadow or a percent of 0
+// stops mirroring
+func (q *Queue[T]) WithShadow(shadow *Queue[T], percent float64) *Queue[T] {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	q.shadow = shadow
+	q.shadowPercent = min(max(percent, 0), 100)
+	return q
+}
+
+// Insert a sampled copy of each event inserted in ts into the shadow queue
+func (q *Queue[T]) mirrorToShadow(ts transitions) {
+	q.hookLock.Lock()
+	shadow, percent := q.shadow, q.shadowPercent
+	q.hookLock.Unlock()
+	if shadow == nil || percent == 0 {
+		return
+	}
+	for _, t := range ts {
+		if t.transition != TRANSITION_INSERTED || rand.Float64()*100 >= percent {
+			continue
+		}
+		if err := q.insertShadowCopy(shadow, t.envelope); err != nil {
+			q.logger().Error(fmt.Sprintf("problem copying event %d to the shadow queue: %v", t.envelope.Id, err))
+		}
+	}
+}
+
+func (q *Queue[T]) insertShadowCopy(shadow *Queue[T], envelope Envelope) error {
+	var payload T
+	if err := q.codec.Unmarshal(envelope.Payload, &payload); err != nil {
+		return fmt.Errorf("unable to unmarshal payload: %w", err)
+	}
+	headers := maps.Clone(envelope.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[SHADOW_SOURCE_HEADER] = strconv.Itoa(envelope.Id)
+	envelope.Headers = headers
+	return shadow.Insert(payload, mirroredOptions(envelope)...)
+}
@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestShadowCopiesEveryEventAtFullRate(t *testing.T) {
+	type Test struct{ A string }
+	shadow := newTestQueue[Test](t)
+	q := newTestQueue[Test](t).WithShadow(shadow, 100)
+
+	if err := q.Insert(Test{A: "mirrored"}, WithKind("greeting"), WithTags("prod")); err != nil {
+		t.Fatal(err)
+	}
+	original, err := q.Next()
+	if err != nil || original == nil {
+		t.Fatalf("expected the original event, got %v", err)
+	}
+	copied, err := shadow.Next()
+	if err != nil || copied == nil {
+		t.Fatalf("expected a shadow copy, got %v", err)
+	}
+	if copied.Content.A != "mirrored" || copied.Envelope.Kind != "greeting" || len(copied.Envelope.Tags) != 1 {
+		t.Fatalf("expected the copy to match the original, got %+v %+v", copied.Content, copied.Envelope)
+	}
+	if copied.Envelope.Headers[SHADOW_SOURCE_HEADER] != strconv.Itoa(original.Id) {
+		t.Fatalf("expected the copy to point at event %d, got %v", original.Id, copied.Envelope.Headers)
+	}
+
+	// Consuming the copy doesn't touch the original
+	if err := shadow.Ack(copied.Id); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := q.Size(); err != nil || size != 1 {
+		t.Fatalf("expected the original to stay in flight, got %d: %v", size, err)
+	}
+}
+
+func TestShadowSamplesAPercentage(t *testing.T) {
+	type Test struct{ A string }
+	shadow := newTestQueue[Test](t)
+	q := newTestQueue[Test](t).WithShadow(shadow, 0)
+
+	if err := q.Insert(Test{A: "not mirrored"}); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := shadow.Size(); err != nil || size != 0 {
+		t.Fatalf("expected nothing to be copied at 0%%, got %d: %v", size, err)
+	}
+
+	q.WithShadow(shadow, 50)
+	for range 200 {
+		if err := q.Insert(Test{A: "sampled"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := shadow.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Far outside what sampling half of 200 events produces in practice
+	if size < 50 || size > 150 {
+		t.Fatalf("expected about half of the events to be copied, got %d", size)
+	}
+}
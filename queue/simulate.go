@@ -0,0 +1,347 @@
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// The most events Simulate generates, so a mistyped arrival rate fails instead of running
+// out of memory
+const maxSimulatedEvents = 2_000_000
+
+// How many of the most recent events SimulationFromHistory learns the workload from
+const workloadSampleSize = 10_000
+
+// Draws a duration for the simulator, e.g. the time until the next arrival
+type Distribution func(r *rand.Rand) time.Duration
+
+// Always d
+func FixedDuration(d time.Duration) Distribution {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Exponentially distributed with the given mean. As time between arrivals this models
+// independent producers, e.g. ExponentialDurations(time.Second/50) for 50 events a second
+func ExponentialDurations(mean time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration { return time.Duration(r.ExpFloat64() * float64(mean)) }
+}
+
+// Uniformly distributed between lo and hi
+func UniformDurations(lo, hi time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration { return lo + time.Duration(r.Int63n(int64(max(hi-lo, 0))+1)) }
+}
+
+// One of samples picked at random, to replay durations that were observed
+func SampledDurations(samples []time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration { return samples[r.Intn(len(samples))] }
+}
+
+// A proposed deployment and the workload it has to handle, see Simulate
+type SimulationConfig struct {
+	// Number of events processed concurrently
+	Workers int
+	// Time between two events being enqueued
+	Arrivals Distribution
+	// Time a worker spends on an attempt
+	Processing Distribution
+	// Fraction of attempts that fail and are nacked
+	FailureRate float64
+	// Failures after which an event is dead lettered, as configured WithMaxRetries
+	MaxRetries int
+	// How long a nacked event waits before it can be claimed again
+	RetryBackoff time.Duration
+	// How long events keep arriving, defaults to an hour
+	Duration time.Duration
+	// Seeds the random numbers, runs with the same seed and workload see the same arrivals
+	Seed int64
+}
+
+// What a simulated deployment would see
+type SimulationResult struct {
+	Workers int
+	// Events that arrived
+	Events    int
+	Completed int
+	Dead      int
+	// Events arrived but not yet resolved when arrivals stopped. A backlog that grows with
+	// Duration means the workers can't keep up
+	Backlog int
+	// Largest number of events waiting to be claimed, including those backing off
+	MaxDepth int
+	// Number of events waiting to be claimed on average over Drained
+	MeanDepth float64
+	// Seconds from being enqueued to the first claim
+	Wait Percentiles
+	// Seconds from being enqueued to being acked or dead lettered
+	Latency Percentiles
+	// Fraction of the workers' time spent processing
+	Utilization float64
+	// Simulated time until every event was resolved
+	Drained time.Duration
+}
+
+// Predict queue depth and latency of a deployment by simulating it: events arrive as
+// config.Arrivals draws, are claimed oldest id first by the first free worker like Next does,
+// take config.Processing and are retried after the backoff when they fail. The simulation
+// runs until every event that arrived within config.Duration is resolved. Compare results
+// for several worker counts to find how many are needed
+func Simulate(config SimulationConfig) (SimulationResult, error) {
+	if config.Workers < 1 {
+		return SimulationResult{}, fmt.Errorf("at least one worker is required")
+	}
+	if config.Arrivals == nil || config.Processing == nil {
+		return SimulationResult{}, fmt.Errorf("arrival and processing time distributions are required")
+	}
+	if config.Duration <= 0 {
+		config.Duration = time.Hour
+	}
+	r := rand.New(rand.NewSource(config.Seed))
+	s := simulation{config: config, idle: config.Workers, result: SimulationResult{Workers: config.Workers}}
+	for at := config.Arrivals(r); at < config.Duration; at += max(config.Arrivals(r), 0) {
+		if len(s.jobs) == maxSimulatedEvents {
+			return SimulationResult{}, fmt.Errorf("more than %d events arrive within %s, simulate a shorter duration", maxSimulatedEvents, config.Duration)
+		}
+		s.jobs = append(s.jobs, &simulatedJob{id: len(s.jobs), enqueued: at})
+		s.schedule(at, simulationArrival, s.jobs[len(s.jobs)-1])
+	}
+	s.schedule(config.Duration, simulationArrivalsEnd, nil)
+	s.result.Events = len(s.jobs)
+
+	waits, latencies := []float64{}, []float64{}
+	var busy time.Duration
+	for s.timeline.Len() > 0 {
+		step := heap.Pop(&s.timeline).(simulationStep)
+		s.advance(step.at)
+		switch step.kind {
+		case simulationArrival:
+			heap.Push(&s.available, step.job)
+		case simulationRetry:
+			s.backingOff--
+			heap.Push(&s.available, step.job)
+		case simulationArrivalsEnd:
+			s.result.Backlog = s.result.Events - s.result.Completed - s.result.Dead
+		case simulationFinish:
+			s.idle++
+			job := step.job
+			if r.Float64() >= config.FailureRate {
+				s.result.Completed++
+				latencies = append(latencies, (step.at - job.enqueued).Seconds())
+			} else if job.failures++; job.failures > config.MaxRetries {
+				s.result.Dead++
+				latencies = append(latencies, (step.at - job.enqueued).Seconds())
+			} else {
+				s.backingOff++
+				s.schedule(step.at+config.RetryBackoff, simulationRetry, job)
+			}
+		}
+		for s.idle > 0 && s.available.Len() > 0 {
+			job := heap.Pop(&s.available).(*simulatedJob)
+			if !job.claimed {
+				job.claimed = true
+				waits = append(waits, (step.at - job.enqueued).Seconds())
+			}
+			processing := max(config.Processing(r), 0)
+			busy += processing
+			s.idle--
+			s.schedule(step.at+processing, simulationFinish, job)
+		}
+	}
+
+	s.result.Drained = s.now
+	if s.now > 0 {
+		s.result.MeanDepth = s.depthArea / s.now.Seconds()
+		s.result.Utilization = busy.Seconds() / (float64(config.Workers) * s.now.Seconds())
+	}
+	s.result.Wait = samplePercentiles(waits)
+	s.result.Latency = samplePercentiles(latencies)
+	return s.result, nil
+}
+
+// Learn a simulation's workload from the most recent events of the queue: the time between
+// them being enqueued, how long attempts took and how often they failed. Retries come from
+// the queue's configuration. Processing times of acked attempts are only known while the
+// events are kept, configure WithArchive or WithAckGracePeriod for them to be replayed
+func (q *Queue[T]) SimulationFromHistory(workers int) (SimulationConfig, error) {
+	workload, err := q.Analytics().workload()
+	if err != nil {
+		return SimulationConfig{}, err
+	}
+	config := SimulationConfig{
+		Workers:      workers,
+		MaxRetries:   q.retryLimit(),
+		RetryBackoff: time.Duration(q.retryBackoffSeconds) * time.Second,
+		FailureRate:  workload.failureRate,
+	}
+	// Enqueue times have second resolution, events enqueued within the same second would
+	// replay as an infinite arrival rate
+	if slices.Max(append(workload.arrivals, 0)) == 0 {
+		return config, ErrNotEnoughHistory
+	}
+	config.Arrivals = SampledDurations(workload.arrivals)
+	if len(workload.processing) == 0 {
+		return config, ErrNotEnoughHistory
+	}
+	config.Processing = SampledDurations(workload.processing)
+	return config, nil
+}
+
+// Returned by SimulationFromHistory when the queue hasn't seen enough events yet
+var ErrNotEnoughHistory = errors.New("not enough history to learn the workload from, events enqueued over more than a second and a finished attempt are needed")
+
+// Samples of a queue's recent history
+type workload struct {
+	arrivals    []time.Duration
+	processing  []time.Duration
+	failureRate float64
+}
+
+func (a Analytics) workload() (workload, error) {
+	w := workload{}
+	enqueued, err := a.floats(fmt.Sprintf("SELECT unixepoch(enqueued_at, 'subsec') FROM %s WHERE enqueued_at IS NOT NULL ORDER BY id DESC LIMIT %d", allEvents(func(string) string { return "id, enqueued_at" }), workloadSampleSize))
+	if err != nil {
+		return w, fmt.Errorf("problem reading arrivals: %w", err)
+	}
+	slices.Sort(enqueued)
+	for i := 1; i < len(enqueued); i++ {
+		w.arrivals = append(w.arrivals, time.Duration((enqueued[i]-enqueued[i-1])*float64(time.Second)))
+	}
+
+	// Acked attempts have no end, they took until the event was acked
+	acked, err := a.floats(fmt.Sprintf(`SELECT MAX(finished - json_extract(attempts, '$[#-1].claimed_at'), 0) FROM (
+    SELECT id, attempts, unixepoch(completed_at, 'subsec') AS finished FROM %s
+    UNION ALL
+    SELECT id, attempts, unixepoch(acked_at, 'subsec') FROM %s
+) WHERE finished IS NOT NULL AND json_array_length(attempts) > 0 ORDER BY id DESC LIMIT %d`, COMPLETED_TABLE, ARCHIVE_TABLE, workloadSampleSize))
+	if err != nil {
+		return w, fmt.Errorf("problem reading processing times: %w", err)
+	}
+	failed, err := a.floats(fmt.Sprintf(`SELECT json_extract(attempt.value, '$.ended_at') - json_extract(attempt.value, '$.claimed_at')
+FROM %s AS events, json_each(events.attempts) AS attempt
+WHERE json_extract(attempt.value, '$.outcome') IN ('%s', '%s') ORDER BY events.id DESC LIMIT %d`,
+		allEvents(func(string) string { return "id, attempts" }), ATTEMPT_FAILED, ATTEMPT_TIMED_OUT, workloadSampleSize))
+	if err != nil {
+		return w, fmt.Errorf("problem reading failed attempts: %w", err)
+	}
+	for _, seconds := range slices.Concat(acked, failed) {
+		w.processing = append(w.processing, time.Duration(seconds*float64(time.Second)))
+	}
+	if len(w.processing) > 0 {
+		w.failureRate = float64(len(failed)) / float64(len(w.processing))
+	}
+	return w, nil
+}
+
+// The single column of query's rows
+func (a Analytics) floats(query string) ([]float64, error) {
+	rows, err := a.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	values := []float64{}
+	for rows.Next() {
+		var value float64
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Nearest rank percentiles of values, like Analytics computes them
+func samplePercentiles(values []float64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(values)
+	rank := func(p float64) float64 { return values[int(p*float64(len(values)-1))] }
+	return Percentiles{P50: rank(0.50), P95: rank(0.95), P99: rank(0.99)}
+}
+
+type simulatedJob struct {
+	id       int
+	enqueued time.Duration
+	claimed  bool
+	failures int
+}
+
+const (
+	simulationArrival = iota
+	simulationFinish
+	simulationRetry
+	simulationArrivalsEnd
+)
+
+type simulationStep struct {
+	at   time.Duration
+	seq  int
+	kind int
+	job  *simulatedJob
+}
+
+// Steps in time order, in the order they were scheduled within the same instant
+type simulationTimeline []simulationStep
+
+func (t simulationTimeline) Len() int { return len(t) }
+func (t simulationTimeline) Less(i, j int) bool {
+	if t[i].at != t[j].at {
+		return t[i].at < t[j].at
+	}
+	return t[i].seq < t[j].seq
+}
+func (t simulationTimeline) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t *simulationTimeline) Push(x any)   { *t = append(*t, x.(simulationStep)) }
+func (t *simulationTimeline) Pop() any {
+	old := *t
+	step := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return step
+}
+
+// Claimable jobs, lowest id first
+type simulatedPending []*simulatedJob
+
+func (p simulatedPending) Len() int           { return len(p) }
+func (p simulatedPending) Less(i, j int) bool { return p[i].id < p[j].id }
+func (p simulatedPending) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p *simulatedPending) Push(x any)        { *p = append(*p, x.(*simulatedJob)) }
+func (p *simulatedPending) Pop() any {
+	old := *p
+	job := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return job
+}
+
+type simulation struct {
+	config     SimulationConfig
+	jobs       []*simulatedJob
+	timeline   simulationTimeline
+	available  simulatedPending
+	backingOff int
+	idle       int
+	now        time.Duration
+	seq        int
+	// Integral of the depth over time, in event seconds
+	depthArea float64
+	result    SimulationResult
+}
+
+func (s *simulation) schedule(at time.Duration, kind int, job *simulatedJob) {
+	s.seq++
+	heap.Push(&s.timeline, simulationStep{at: at, seq: s.seq, kind: kind, job: job})
+}
+
+// Move the clock to at, accounting for the depth in between
+func (s *simulation) advance(at time.Duration) {
+	depth := s.available.Len() + s.backingOff
+	s.depthArea += float64(depth) * (at - s.now).Seconds()
+	s.result.MaxDepth = max(s.result.MaxDepth, depth)
+	s.now = at
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSimulateKeepsUpWithEnoughWorkers(t *testing.T) {
+	config := SimulationConfig{
+		Arrivals:   FixedDuration(time.Second),
+		Processing: FixedDuration(1500 * time.Millisecond),
+		Duration:   time.Minute,
+	}
+	config.Workers = 1
+	one, err := Simulate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Workers = 2
+	two, err := Simulate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.Events != 59 || one.Completed != 59 || two.Completed != 59 {
+		t.Fatalf("expected every event to complete, got %+v and %+v", one, two)
+	}
+	if one.Backlog < 10 || one.Wait.P99 < 20 {
+		t.Fatalf("expected one worker to fall behind, got %+v", one)
+	}
+	if two.Backlog > 1 || two.Wait.P99 != 0 || two.Latency.P50 != 1.5 {
+		t.Fatalf("expected two workers to keep up, got %+v", two)
+	}
+	if two.Utilization < 0.7 || two.Utilization > 0.8 {
+		t.Fatalf("expected two workers to be busy three quarters of the time, got %f", two.Utilization)
+	}
+}
+
+func TestSimulateRetriesAndDeadLetters(t *testing.T) {
+	result, err := Simulate(SimulationConfig{
+		Workers:      1,
+		Arrivals:     FixedDuration(time.Second),
+		Processing:   FixedDuration(10 * time.Millisecond),
+		FailureRate:  1,
+		MaxRetries:   2,
+		RetryBackoff: 100 * time.Millisecond,
+		Duration:     10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Dead != result.Events || result.Completed != 0 {
+		t.Fatalf("expected every event to be dead lettered, got %+v", result)
+	}
+	// Three attempts and two backoffs
+	if result.Latency.P50 != 0.23 {
+		t.Fatalf("expected events to be dead lettered after their retries, got %f", result.Latency.P50)
+	}
+}
+
+func TestSimulationFromHistory(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithAckGracePeriod(time.Hour)
+	if _, err := q.SimulationFromHistory(1); !errors.Is(err, ErrNotEnoughHistory) {
+		t.Fatalf("expected an empty queue not to have a workload, got %v", err)
+	}
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.db.Exec("UPDATE queue_completed SET enqueued_at = (SELECT MIN(enqueued_at) FROM queue_completed)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.SimulationFromHistory(1); !errors.Is(err, ErrNotEnoughHistory) {
+		t.Fatalf("expected events enqueued within a second not to have an arrival rate, got %v", err)
+	}
+	if _, err := q.db.Exec("UPDATE queue_completed SET enqueued_at = datetime(enqueued_at, '-' || (4 - id) || ' seconds')"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := q.SimulationFromHistory(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Workers != 2 || config.MaxRetries != q.retryLimit() || config.FailureRate != 0 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	config.Duration = time.Minute
+	if _, err := Simulate(config); err != nil {
+		t.Fatal(err)
+	}
+}
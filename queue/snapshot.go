@@ -0,0 +1,215 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The tables copied into the read snapshot, enough to serve Peek, List and Stats
+var SNAPSHOT_TABLES = slices.Concat(EVENT_TABLES, []string{COMPLETED_TABLE, "queue_tags"})
+
+const CREATE_SNAPSHOT_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_snapshot (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    taken_at TEXT NOT NULL               -- when the tables were last copied from the database
+);
+`
+
+const SNAPSHOT_TAKEN_AT_QUERY = `SELECT taken_at FROM queue_snapshot WHERE id = 1`
+
+const SAVE_SNAPSHOT_TAKEN_AT_QUERY = `
+INSERT INTO queue_snapshot (id, taken_at) VALUES (1, ?)
+ON CONFLICT (id) DO UPDATE SET taken_at = excluded.taken_at
+`
+
+var errNoSnapshot = errors.New("no read snapshot configured")
+
+// Keep a local copy of the queue's tables in the database file at path, refreshed every
+// interval by maintenance and on RefreshSnapshot. While the database can't be reached, Peek,
+// List and Stats are served from the copy instead of failing, with StaleAt set to when it
+// was taken, so dashboards keep working during an outage of a remote database. Each refresh
+// copies every pending, in-flight, dead and completed event, so choose interval with the
+// size of the queue in mind
+func WithReadSnapshot(path string, interval time.Duration) Option {
+	return func(o *options) {
+		o.snapshotPath = path
+		o.snapshotInterval = interval
+	}
+}
+
+type readSnapshot struct {
+	db       *sql.DB
+	interval time.Duration
+	// Held for writing while refreshing so reads never see a half copied snapshot
+	lock      sync.RWMutex
+	lastTaken time.Time
+}
+
+func openReadSnapshot(path string, interval time.Duration) (*readSnapshot, error) {
+	db, err := sql.Open("libsql", "file:"+path)
+	if err != nil {
+		return nil, fmt.Errorf("problem opening read snapshot: %w", err)
+	}
+	if err := createSchema(db); err != nil {
+		return nil, fmt.Errorf("problem creating read snapshot: %w", err)
+	}
+	if _, err := db.Exec(CREATE_SNAPSHOT_TABLE_STATEMENT); err != nil {
+		return nil, fmt.Errorf("problem creating read snapshot: %w", err)
+	}
+	return &readSnapshot{db: db, interval: interval}, nil
+}
+
+// Copy the queue's tables into the read snapshot configured with WithReadSnapshot now
+func (q *Queue[T]) RefreshSnapshot() error {
+	s := q.snapshot
+	if s == nil {
+		return errNoSnapshot
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastTaken = time.Now()
+	if err := copySnapshot(q.db, s.db); err != nil {
+		return fmt.Errorf("problem refreshing read snapshot: %w", err)
+	}
+	return nil
+}
+
+// Refresh the read snapshot if it is older than its interval, logging failures. Must not
+// be called with q.lock held
+func (q *Queue[T]) refreshSnapshotIfDue() {
+	s := q.snapshot
+	if s == nil {
+		return
+	}
+	s.lock.RLock()
+	due := time.Since(s.lastTaken) >= s.interval
+	s.lock.RUnlock()
+	if !due {
+		return
+	}
+	if err := q.RefreshSnapshot(); err != nil {
+		q.logger().Error(err.Error())
+	}
+}
+
+// Replace the contents of the snapshot tables in dst with those in src
+func copySnapshot(src *sql.DB, dst *sql.DB) error {
+	read, err := src.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(read)
+	write, err := dst.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(write)
+	for _, table := range SNAPSHOT_TABLES {
+		if err := copyTable(read, write, table); err != nil {
+			return fmt.Errorf("problem copying %s: %w", table, err)
+		}
+	}
+	if _, err := write.Exec(SAVE_SNAPSHOT_TAKEN_AT_QUERY, formatSqliteTime(time.Now())); err != nil {
+		return err
+	}
+	return write.Commit()
+}
+
+func copyTable(read *sql.Tx, write *sql.Tx, table string) error {
+	columns, err := snapshotColumns(read, write, table)
+	if err != nil {
+		return err
+	}
+	if _, err := write.Exec("DELETE FROM " + table); err != nil {
+		return err
+	}
+	list := strings.Join(columns, ", ")
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, list, strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	rows, err := read.Query(fmt.Sprintf("SELECT %s FROM %s", list, table))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		args := make([]any, len(values))
+		for i, value := range values {
+			args[i] = sqliteValue(value)
+		}
+		if _, err := write.Exec(insert, args...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const TABLE_COLUMNS_QUERY = `SELECT name FROM pragma_table_info(?)`
+
+// The columns table has in both databases, other tools may have added their own
+func snapshotColumns(read *sql.Tx, write *sql.Tx, table string) ([]string, error) {
+	names := func(tx *sql.Tx) ([]string, error) {
+		rows, err := tx.Query(TABLE_COLUMNS_QUERY, table)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		columns := []string{}
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				return nil, err
+			}
+			columns = append(columns, column)
+		}
+		return columns, rows.Err()
+	}
+	source, err := names(read)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := names(write)
+	if err != nil {
+		return nil, err
+	}
+	shared := slices.DeleteFunc(source, func(column string) bool {
+		return !slices.Contains(snapshot, column)
+	})
+	slices.Sort(shared)
+	return shared, nil
+}
+
+// Run read against the read snapshot, returning when the snapshot was taken. Fails if there
+// is no snapshot or it was never taken
+func fromSnapshot[T any, R any](q *Queue[T], read func(db *sql.DB) (R, error)) (R, time.Time, error) {
+	var zero R
+	s := q.snapshot
+	if s == nil {
+		return zero, time.Time{}, errNoSnapshot
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var takenAt sql.NullTime
+	if err := s.db.QueryRow(SNAPSHOT_TAKEN_AT_QUERY).Scan(&takenAt); err != nil {
+		return zero, time.Time{}, err
+	}
+	result, err := read(s.db)
+	if err != nil {
+		return zero, time.Time{}, err
+	}
+	return result, takenAt.Time, nil
+}
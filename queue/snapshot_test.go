@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	type Test struct{ A string }
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	q := newTestQueue[Test](t, WithSynchronousMaintenance(), WithReadSnapshot(snapshot, time.Hour))
+
+	if err := q.Insert(Test{A: "hello"}, WithTags("dashboard")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RefreshSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := q.Peek(1)
+	if err != nil || fresh == nil || !fresh.StaleAt.IsZero() {
+		t.Fatalf("expected a fresh event while the database is up, got %v: %v", fresh, err)
+	}
+
+	// Simulate an outage
+	if err := q.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := q.Peek(1)
+	if err != nil || stale == nil || stale.StaleAt.IsZero() || stale.Tags[0] != "dashboard" {
+		t.Fatalf("expected the event from the snapshot, got %+v: %v", stale, err)
+	}
+	listed, err := q.List(ListFilter{Tag: "dashboard"})
+	if err != nil || len(listed) != 1 || listed[0].StaleAt.IsZero() {
+		t.Fatalf("expected the tagged event from the snapshot, got %+v: %v", listed, err)
+	}
+	stats, err := q.Stats(StatsFilter{})
+	if err != nil || stats.Pending != 1 || stats.StaleAt.IsZero() {
+		t.Fatalf("expected stats from the snapshot, got %+v: %v", stats, err)
+	}
+	if _, err := q.Size(); err == nil {
+		t.Fatal("expected reads other than Peek, List and Stats to fail")
+	}
+}
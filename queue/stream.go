@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// How many events StreamList and Export read per query. The queue's lock is only held while
+// a page is read, so streaming a large queue doesn't block producers and consumers
+const streamPageSize = 1000
+
+// An event as written by StreamList, one JSON object per line
+type StreamedEvent struct {
+	Id            int               `json:"id"`
+	State         string            `json:"state"`
+	Kind          string            `json:"kind,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Resources     []string          `json:"resources,omitempty"`
+	Batch         string            `json:"batch,omitempty"`
+	Parent        int               `json:"parent,omitempty"`
+	SchemaVersion int               `json:"schema_version,omitempty"`
+	EnqueuedAt    time.Time         `json:"enqueued_at"`
+	Retries       int               `json:"retries"`
+	DeadReason    string            `json:"dead_reason,omitempty"`
+	Attempts      []Attempt         `json:"attempts,omitempty"`
+	// The id encoded for outside use, only set for queues configured WithIDCodec
+	ExternalID string `json:"external_id,omitempty"`
+	// The encoded payload, inlined when it is JSON and a JSON string otherwise
+	Payload json.RawMessage `json:"payload"`
+}
+
+func streamedEvent(envelope Envelope) (StreamedEvent, error) {
+	payload := json.RawMessage(envelope.Payload)
+	if !json.Valid(payload) {
+		encoded, err := json.Marshal(string(envelope.Payload))
+		if err != nil {
+			return StreamedEvent{}, err
+		}
+		payload = encoded
+	}
+	return StreamedEvent{
+		Id:            envelope.Id,
+		State:         envelope.State,
+		Kind:          envelope.Kind,
+		Headers:       envelope.Headers,
+		Tags:          envelope.Tags,
+		Resources:     envelope.Resources,
+		Batch:         envelope.Batch,
+		Parent:        envelope.Parent,
+		SchemaVersion: envelope.SchemaVersion,
+		EnqueuedAt:    envelope.EnqueuedAt,
+		Retries:       envelope.Retries,
+		DeadReason:    envelope.DeadReason,
+		Attempts:      envelope.Attempts,
+		Payload:       payload,
+	}, nil
+}
+
+// List, but writing the events to w as NDJSON (one StreamedEvent per line) as they are read
+// instead of returning them, so queues of any size can be inspected in constant memory.
+// Returns how many events were written
+func (q *Queue[T]) StreamList(ctx context.Context, w io.Writer, filter ListFilter) (int, error) {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	written, err := q.eachEvent(ctx, filter, func(envelope Envelope) error {
+		event, err := streamedEvent(envelope)
+		if err != nil {
+			return fmt.Errorf("problem encoding event %d: %w", envelope.Id, err)
+		}
+		if q.idCodec != nil {
+			event.ExternalID = q.idCodec.EncodeID(envelope.Id)
+		}
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, out.Flush()
+}
+
+// Write the payloads of the events matching filter to w, one per line, in the NDJSON format
+// Import reads. Like StreamList the events are never all held in memory. Returns how many
+// payloads were written
+func (q *Queue[T]) Export(ctx context.Context, w io.Writer, filter ListFilter) (int, error) {
+	out := bufio.NewWriter(w)
+	written, err := q.eachEvent(ctx, filter, func(envelope Envelope) error {
+		if _, err := out.Write(envelope.Payload); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, out.Flush()
+}
+
+// Call fn with every event matching filter in List's order, reading streamPageSize events
+// at a time. Returns how many events fn was called with
+func (q *Queue[T]) eachEvent(ctx context.Context, filter ListFilter, fn func(Envelope) error) (int, error) {
+	tables, err := stateTables(filter.State)
+	if err != nil {
+		return 0, err
+	}
+	where := "WHERE id > ?"
+	if filter.Tag != "" {
+		where += " AND " + TAG_CONDITION
+	}
+	count := 0
+	for _, table := range tables {
+		reasonColumn := "NULL"
+		if table == DEAD_TABLE {
+			reasonColumn = "reason"
+		}
+		query := fmt.Sprintf(LIST_QUERY_TEMPLATE, reasonColumn, table, where) + " LIMIT ?"
+		afterID := 0
+		for {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+			limit := streamPageSize
+			if filter.Limit > 0 {
+				limit = min(limit, filter.Limit-count)
+				if limit <= 0 {
+					return count, nil
+				}
+			}
+			args := []any{afterID}
+			if filter.Tag != "" {
+				args = append(args, filter.Tag)
+			}
+			q.lock.RLock()
+			page, err := listTable(q.db, query, TABLE_STATES[table], append(args, limit)...)
+			q.lock.RUnlock()
+			if err != nil {
+				return count, fmt.Errorf("problem listing %s events: %w", TABLE_STATES[table], err)
+			}
+			for _, envelope := range page {
+				if err := fn(envelope); err != nil {
+					return count, err
+				}
+				count++
+			}
+			if len(page) < limit {
+				break
+			}
+			afterID = page[len(page)-1].Id
+		}
+	}
+	return count, nil
+}
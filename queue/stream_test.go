@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStreamList(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}, WithKind("greeting")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	written, err := q.StreamList(context.Background(), &out, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 3 {
+		t.Fatalf("expected 3 events, got %d", written)
+	}
+	events := []StreamedEvent{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event StreamedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(events))
+	}
+	// Pending events come first, then in flight ones
+	if events[0].State != EVENT_STATE_PENDING || events[2].State != EVENT_STATE_INFLIGHT || events[2].Id != 1 {
+		t.Fatalf("unexpected order: %+v", events)
+	}
+	var payload Test
+	if err := json.Unmarshal(events[0].Payload, &payload); err != nil || payload.A != "two" {
+		t.Fatalf("expected the payload to be inlined, got %s", events[0].Payload)
+	}
+	if events[0].Kind != "greeting" {
+		t.Fatalf("expected kind greeting, got %q", events[0].Kind)
+	}
+
+	out.Reset()
+	if written, err := q.StreamList(context.Background(), &out, ListFilter{Limit: 2}); err != nil || written != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", written, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	if err := q.Insert(Test{A: "one"}, WithTags("keep")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	written, err := q.Export(context.Background(), &out, ListFilter{Tag: "keep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 1 || out.String() != `{"A":"one"}`+"\n" {
+		t.Fatalf("unexpected export of %d events: %q", written, out.String())
+	}
+
+	// Exports can be imported as they are
+	other := newTestQueue[Test](t)
+	imported, err := other.Import(context.Background(), &out, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 1 {
+		t.Fatalf("expected 1 imported event, got %d", imported)
+	}
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conditions on the event tables' columns, e.g. to scope claims to a sub-queue
+type eventFilter struct {
+	conditions []string
+	args       []any
+}
+
+// The filter's conditions to be appended to a WHERE clause, empty if there are none
+func (f eventFilter) and() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return "\nAND " + strings.Join(f.conditions, " AND ")
+}
+
+// The header holding the tenant an event belongs to, see SubQueueFilter
+const TENANT_HEADER = "tenant"
+
+// Which events a sub-queue sees. Empty fields match every event, set fields must all match
+type SubQueueFilter struct {
+	// Events inserted WithKind(Kind)
+	Kind string
+	// Events whose TENANT_HEADER header is Tenant
+	Tenant string
+	// Events carrying Tag, see WithTags
+	Tag string
+}
+
+func (f SubQueueFilter) eventFilter() eventFilter {
+	filter := eventFilter{}
+	if f.Kind != "" {
+		filter.conditions = append(filter.conditions, "kind = ?")
+		filter.args = append(filter.args, f.Kind)
+	}
+	if f.Tenant != "" {
+		filter.conditions = append(filter.conditions, "json_extract(headers, '$."+TENANT_HEADER+"') = ?")
+		filter.args = append(filter.args, f.Tenant)
+	}
+	if f.Tag != "" {
+		filter.conditions = append(filter.conditions, TAG_CONDITION)
+		filter.args = append(filter.args, f.Tag)
+	}
+	return filter
+}
+
+// The insert options that make an event match the filter
+func (f SubQueueFilter) insertOptions() []InsertOption {
+	opts := []InsertOption{}
+	if f.Kind != "" {
+		opts = append(opts, WithKind(f.Kind))
+	}
+	if f.Tenant != "" {
+		opts = append(opts, WithHeaders(map[string]string{TENANT_HEADER: f.Tenant}))
+	}
+	if f.Tag != "" {
+		opts = append(opts, WithTags(f.Tag))
+	}
+	return opts
+}
+
+// A view of a Queue that only sees the events matching a SubQueueFilter. Sub-queues share
+// the queue's tables and settings, so they are cheap to create, e.g. one per tenant, but
+// they are not isolated: the queue itself still sees every event.
+type SubQueue[T any] struct {
+	queue  *Queue[T]
+	filter SubQueueFilter
+}
+
+// A handle on the events of this queue matching filter, see SubQueue
+func (q *Queue[T]) LightweightSubQueue(filter SubQueueFilter) *SubQueue[T] {
+	if filter.Kind != "" {
+		q.kindFiltered.Store(true)
+	}
+	return &SubQueue[T]{queue: q, filter: filter}
+}
+
+// Insert an event that matches the sub-queue's filter, by setting its kind, tenant header
+// and tag on top of opts
+func (s *SubQueue[T]) Insert(payload T, opts ...InsertOption) error {
+	return s.queue.Insert(payload, append(opts, s.filter.insertOptions()...)...)
+}
+
+// Claim the oldest available event matching the sub-queue's filter, see Queue.Next
+func (s *SubQueue[T]) Next() (*Event[T], error) {
+	return s.queue.nextMatching(s.filter.eventFilter())
+}
+
+// Acknowledge an event claimed from the sub-queue, see Queue.Ack
+func (s *SubQueue[T]) Ack(id int) error {
+	return s.queue.Ack(id)
+}
+
+// Negatively acknowledge an event claimed from the sub-queue, see Queue.Nack
+func (s *SubQueue[T]) Nack(id int) error {
+	return s.queue.Nack(id)
+}
+
+// Give back the claim on an event claimed from the sub-queue, see Queue.Release
+func (s *SubQueue[T]) Release(id int) error {
+	return s.queue.Release(id)
+}
+
+const SUB_QUEUE_SIZE_TEMPLATE = `SELECT
+(SELECT COUNT(*) FROM queue WHERE IFNULL(retries, 0) <= ` + RETRY_LIMIT_EXPRESSION + ` AND payload IS NOT NULL AND ` + NOT_EXPIRED_CONDITION + `%[1]s) +
+(SELECT COUNT(*) FROM queue_inflight WHERE 1%[1]s)`
+
+// Returns the number of events matching the sub-queue's filter that are pending or being
+// processed
+func (s *SubQueue[T]) Size() (int, error) {
+	filter := s.filter.eventFilter()
+	var size int
+	s.queue.lock.RLock()
+	defer s.queue.lock.RUnlock()
+	args := append([]any{s.queue.maxRetries}, filter.args...)
+	args = append(args, filter.args...)
+	err := s.queue.db.QueryRow(fmt.Sprintf(SUB_QUEUE_SIZE_TEMPLATE, filter.and()), args...).Scan(&size)
+	if err != nil {
+		return -1, fmt.Errorf("problem getting number of events in the sub-queue: %w", err)
+	}
+	return size, nil
+}
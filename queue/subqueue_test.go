@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestLightweightSubQueue(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	acme := q.LightweightSubQueue(SubQueueFilter{Tenant: "acme"})
+	acmeEmails := q.LightweightSubQueue(SubQueueFilter{Tenant: "acme", Kind: "email"})
+
+	if err := q.Insert(Test{A: "other tenant"}, WithHeaders(map[string]string{TENANT_HEADER: "globex"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := acme.Insert(Test{A: "acme report"}, WithKind("report")); err != nil {
+		t.Fatal(err)
+	}
+	if err := acmeEmails.Insert(Test{A: "acme email"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, err := acme.Size(); err != nil || size != 2 {
+		t.Fatalf("expected 2 acme events, got %d: %v", size, err)
+	}
+	event, err := acmeEmails.Next()
+	if err != nil || event == nil || event.Content.A != "acme email" {
+		t.Fatalf("expected the acme email, got %v: %v", event, err)
+	}
+	if event.Envelope.Headers[TENANT_HEADER] != "acme" || event.Envelope.Kind != "email" {
+		t.Fatalf("expected the filter to be applied on insert, got %+v", event.Envelope)
+	}
+	if empty, err := acmeEmails.Next(); err != nil || empty != nil {
+		t.Fatalf("expected no more acme emails, got %v: %v", empty, err)
+	}
+	if err := acmeEmails.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	event, err = acme.Next()
+	if err != nil || event == nil || event.Content.A != "acme report" {
+		t.Fatalf("expected the acme report, got %v: %v", event, err)
+	}
+	if size, err := q.Size(); err != nil || size != 2 {
+		t.Fatalf("expected the queue to see every event, got %d: %v", size, err)
+	}
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Tags live in their own table rather than a column of the event tables so events can be
+// looked up by tag through an index, whichever table they are in. Events keep their id as
+// they move between tables, so their tags follow them.
+const CREATE_TAGS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_tags (
+    tag TEXT NOT NULL,
+    event_id INTEGER NOT NULL,           -- id of the event in whichever table it is in
+    PRIMARY KEY (tag, event_id)
+) WITHOUT ROWID;
+`
+
+const CREATE_TAGS_EVENT_ID_INDEX_STATEMENT = `CREATE INDEX IF NOT EXISTS idx_tags_event_id ON queue_tags (event_id);`
+
+// The tags of the event in the current row as a JSON array, selected as part of ENVELOPE_COLUMNS
+const TAGS_COLUMN = `(SELECT json_group_array(tag) FROM queue_tags WHERE event_id = id)`
+
+const INSERT_TAG_QUERY = `INSERT OR IGNORE INTO queue_tags (tag, event_id) VALUES (?, ?)`
+
+const DELETE_TAGS_QUERY = `DELETE FROM queue_tags WHERE event_id = ?`
+
+// Label the event with tags, e.g. "backfill-2024-06", so it can be found with List, counted
+// with Stats and cancelled with CancelTagged together with the other events carrying the tag.
+// Unlike headers, tags are indexed. Tags are added to any set by earlier options
+func WithTags(tags ...string) InsertOption {
+	return func(e *Envelope) {
+		for _, tag := range tags {
+			if tag != "" && !slices.Contains(e.Tags, tag) {
+				e.Tags = append(e.Tags, tag)
+			}
+		}
+	}
+}
+
+// Tag the event with id: id
+func insertTags(db execer, id int, tags []string) error {
+	for _, tag := range tags {
+		if _, err := db.Exec(INSERT_TAG_QUERY, tag, id); err != nil {
+			return fmt.Errorf("problem tagging event %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Decode the tags selected by TAGS_COLUMN
+func decodeTags(tags sql.NullString) ([]string, error) {
+	if !tags.Valid || tags.String == "" || tags.String == "[]" {
+		return nil, nil
+	}
+	decoded := []string{}
+	if err := json.Unmarshal([]byte(tags.String), &decoded); err != nil {
+		return nil, err
+	}
+	slices.Sort(decoded)
+	return decoded, nil
+}
+
+// Which events List returns
+type ListFilter struct {
+	// One of the EVENT_STATE_ constants, events in any state if empty
+	State string
+	// Only events carrying this tag, if set
+	Tag string
+	// At most this many events, all of them if 0
+	Limit int
+}
+
+const LIST_QUERY_TEMPLATE = `SELECT ` + ENVELOPE_COLUMNS + `, %s FROM %s %s ORDER BY id ASC`
+
+const TAG_CONDITION = `id IN (SELECT event_id FROM queue_tags WHERE tag = ?)`
+
+// The tables holding events in state, or every table if state is empty
+func stateTables(state string) ([]string, error) {
+	tables := slices.Concat(EVENT_TABLES, []string{COMPLETED_TABLE})
+	if state == "" {
+		return tables, nil
+	}
+	for _, table := range tables {
+		if TABLE_STATES[table] == state {
+			return []string{table}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown event state %q", state)
+}
+
+// Look up the events matching filter without claiming them, oldest first within each state.
+// States are listed in the order pending, in flight, dead, completed. Served from the read
+// snapshot while the database is unreachable, see WithReadSnapshot
+func (q *Queue[T]) List(filter ListFilter) ([]Envelope, error) {
+	q.lock.RLock()
+	envelopes, err := listEvents(q.db, filter)
+	q.lock.RUnlock()
+	if err == nil {
+		return envelopes, nil
+	}
+	envelopes, snapshotAt, snapshotErr := fromSnapshot(q, func(db *sql.DB) ([]Envelope, error) {
+		return listEvents(db, filter)
+	})
+	if snapshotErr != nil {
+		return nil, err
+	}
+	for i := range envelopes {
+		envelopes[i].StaleAt = snapshotAt
+	}
+	return envelopes, nil
+}
+
+func listEvents(db *sql.DB, filter ListFilter) ([]Envelope, error) {
+	tables, err := stateTables(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	where, args := "", []any{}
+	if filter.Tag != "" {
+		where, args = "WHERE "+TAG_CONDITION, []any{filter.Tag}
+	}
+	envelopes := []Envelope{}
+	for _, table := range tables {
+		reasonColumn := "NULL"
+		if table == DEAD_TABLE {
+			reasonColumn = "reason"
+		}
+		query := fmt.Sprintf(LIST_QUERY_TEMPLATE, reasonColumn, table, where)
+		if filter.Limit > 0 {
+			query += fmt.Sprintf(" LIMIT %d", filter.Limit-len(envelopes))
+		}
+		listed, err := listTable(db, query, TABLE_STATES[table], args...)
+		if err != nil {
+			return nil, fmt.Errorf("problem listing %s events: %w", TABLE_STATES[table], err)
+		}
+		envelopes = append(envelopes, listed...)
+		if filter.Limit > 0 && len(envelopes) >= filter.Limit {
+			break
+		}
+	}
+	return envelopes, nil
+}
+
+func listTable(db querier, query string, state string, args ...any) ([]Envelope, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	envelopes := []Envelope{}
+	for rows.Next() {
+		var reason sql.NullString
+		envelope, err := scanEnvelope(rows, state, &reason)
+		if err != nil {
+			return nil, err
+		}
+		envelope.DeadReason = reason.String
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, rows.Err()
+}
+
+// Which events Stats counts
+type StatsFilter struct {
+	// Only events carrying this tag, if set
+	Tag string
+}
+
+// How many events are in each state
+type Stats struct {
+	Pending   int
+	Inflight  int
+	Dead      int
+	Completed int
+	// When the read snapshot the counts were served from was taken, zero unless the
+	// database was unreachable, see WithReadSnapshot
+	StaleAt time.Time
+}
+
+// Count the events matching filter in each state. Served from the read snapshot while the
+// database is unreachable, see WithReadSnapshot
+func (q *Queue[T]) Stats(filter StatsFilter) (Stats, error) {
+	q.lock.RLock()
+	stats, err := countEvents(q.db, filter)
+	q.lock.RUnlock()
+	if err == nil {
+		return stats, nil
+	}
+	stats, snapshotAt, snapshotErr := fromSnapshot(q, func(db *sql.DB) (Stats, error) {
+		return countEvents(db, filter)
+	})
+	if snapshotErr != nil {
+		return Stats{}, err
+	}
+	stats.StaleAt = snapshotAt
+	return stats, nil
+}
+
+func countEvents(db *sql.DB, filter StatsFilter) (Stats, error) {
+	conditions, args := []string{}, []any{}
+	if filter.Tag != "" {
+		conditions, args = append(conditions, TAG_CONDITION), append(args, filter.Tag)
+	}
+	var stats Stats
+	counts := map[string]*int{
+		PENDING_TABLE:   &stats.Pending,
+		INFLIGHT_TABLE:  &stats.Inflight,
+		DEAD_TABLE:      &stats.Dead,
+		COMPLETED_TABLE: &stats.Completed,
+	}
+	for table, count := range counts {
+		tableConditions := append([]string{"1"}, conditions...)
+		if table == PENDING_TABLE {
+			// Count what Next would deliver, like Size
+			tableConditions = append(tableConditions, "payload IS NOT NULL")
+		}
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, strings.Join(tableConditions, " AND "))
+		if err := db.QueryRow(query, args...).Scan(count); err != nil {
+			return Stats{}, fmt.Errorf("problem counting %s events: %w", TABLE_STATES[table], err)
+		}
+	}
+	return stats, nil
+}
+
+// Dead letter event cancelled with CancelTagged
+const DEAD_REASON_CANCELLED = "cancelled"
+
+// Cancel every pending event carrying tag, e.g. to stop a backfill, by moving it to the dead
+// letter table with reason DEAD_REASON_CANCELLED. Events already in flight are left to
+// finish. Returns how many events were cancelled
+func (q *Queue[T]) CancelTagged(tag string) (int, error) {
+	var ts transitions
+	q.lock.Lock()
+	cancelled, err := q.cancelTagged(tag, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("problem cancelling events tagged %s: %w", tag, err)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return cancelled, nil
+}
+
+func (q *Queue[T]) cancelTagged(tag string, ts *transitions) (int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	ids, err := moveEvents(tx, PENDING_TABLE, DEAD_TABLE, TAG_CONDITION, tag)
+	if err != nil {
+		return 0, err
+	}
+	if err := markDead(tx, ids, DEAD_REASON_CANCELLED, ts); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}, WithTags("backfill-2024-06", "import")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Insert(Test{A: "live"}, WithTags("import")); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if !slices.Equal(event.Envelope.Tags, []string{"backfill-2024-06", "import"}) {
+		t.Fatalf("expected the event's tags, got %v", event.Envelope.Tags)
+	}
+
+	listed, err := q.List(ListFilter{Tag: "backfill-2024-06"})
+	if err != nil || len(listed) != 3 {
+		t.Fatalf("expected 3 tagged events, got %v: %v", listed, err)
+	}
+	if listed[0].State != EVENT_STATE_PENDING || listed[2].State != EVENT_STATE_INFLIGHT {
+		t.Fatalf("expected pending events before in flight ones, got %v", listed)
+	}
+	limited, err := q.List(ListFilter{Tag: "import", State: EVENT_STATE_PENDING, Limit: 2})
+	if err != nil || len(limited) != 2 {
+		t.Fatalf("expected 2 pending events, got %v: %v", limited, err)
+	}
+
+	cancelled, err := q.CancelTagged("backfill-2024-06")
+	if err != nil || cancelled != 2 {
+		t.Fatalf("expected 2 cancelled events, got %d: %v", cancelled, err)
+	}
+	stats, err := q.Stats(StatsFilter{Tag: "backfill-2024-06"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Inflight: 1, Dead: 2}) {
+		t.Fatalf("unexpected stats for the backfill: %+v", stats)
+	}
+	stats, err = q.Stats(StatsFilter{})
+	if err != nil || stats != (Stats{Pending: 1, Inflight: 1, Dead: 2}) {
+		t.Fatalf("unexpected stats for the queue: %+v: %v", stats, err)
+	}
+
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if listed, err := q.List(ListFilter{Tag: "backfill-2024-06", State: EVENT_STATE_DEAD}); err != nil || len(listed) != 2 || listed[0].DeadReason != DEAD_REASON_CANCELLED {
+		t.Fatalf("expected the cancelled events, got %v: %v", listed, err)
+	}
+}
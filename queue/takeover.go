@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Returned by ForceRelease and ForceReassign for events that aren't currently claimed
+var ErrNotInFlight = errors.New("event is not in flight")
+
+// Events that weren't reassigned, or were reassigned to the claiming worker
+const NOT_REASSIGNED_CONDITION = `(reassigned_to IS NULL OR reassigned_to = ?)`
+
+const CLAIM_OWNER_QUERY = `SELECT IFNULL(claimed_by, '') FROM queue_inflight WHERE id = ?`
+
+const REASSIGN_QUERY = `UPDATE queue SET reassigned_to = ? WHERE id = ?`
+
+// Identify this process's claims with id, e.g. the hostname, so operators can tell which
+// worker holds an event (Envelope.ClaimedBy) and hand events to it with ForceReassign
+func (q *Queue[T]) WithWorkerID(id string) *Queue[T] {
+	q.workerID = id
+	return q
+}
+
+// Take the claim on event with id: id away from whichever worker holds it and make the
+// event available again straight away, e.g. to yank it from a wedged worker during an
+// incident instead of waiting for the claim to expire. Unlike Release this is meant to be
+// called from outside the claiming worker, and every takeover is logged
+func (q *Queue[T]) ForceRelease(id int) error {
+	return q.takeOver(id, "")
+}
+
+// ForceRelease, but only the worker configured WithWorkerID(workerID) may claim the event
+// next. Use ForceRelease to make it available to every worker again
+func (q *Queue[T]) ForceReassign(id int, workerID string) error {
+	if workerID == "" {
+		return fmt.Errorf("unable to reassign event %d: worker id is required", id)
+	}
+	return q.takeOver(id, workerID)
+}
+
+func (q *Queue[T]) takeOver(id int, workerID string) error {
+	var ts transitions
+	q.lock.Lock()
+	previous, err := q.forceRelease(id, workerID, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to take over event %d: %w", id, err)
+	}
+	q.logger().Warn("forcibly took over event", "id", id, "claimed_by", previous, "reassigned_to", workerID, "by", q.workerID)
+	q.notifyTransitions(ts)
+	return nil
+}
+
+// Move the in-flight event back to pending, reserved for workerID if set. Returns the
+// worker that held the claim. Callers must hold q.lock
+func (q *Queue[T]) forceRelease(id int, workerID string, ts *transitions) (string, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	var previous string
+	err = tx.QueryRow(CLAIM_OWNER_QUERY, id).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return "", ErrNotInFlight
+	} else if err != nil {
+		return "", err
+	}
+	released, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, "id = ?", id)
+	if err != nil {
+		return "", err
+	}
+	if workerID != "" {
+		if _, err := tx.Exec(REASSIGN_QUERY, workerID, id); err != nil {
+			return "", err
+		}
+	}
+	if err := releaseClaims(tx, released, ATTEMPT_RELEASED, ts); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return "", err
+	}
+	return previous, nil
+}
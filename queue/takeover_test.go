@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestForceRelease(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithWorkerID("wedged")
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if event.Envelope.ClaimedBy != "wedged" {
+		t.Fatalf("expected the claim to be attributed to the worker, got %q", event.Envelope.ClaimedBy)
+	}
+
+	if err := q.ForceRelease(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ForceRelease(event.Id); !errors.Is(err, ErrNotInFlight) {
+		t.Fatalf("expected ErrNotInFlight, got %v", err)
+	}
+	again, err := q.Next()
+	if err != nil || again == nil || again.Id != event.Id {
+		t.Fatalf("expected the event to be available again, got %v: %v", again, err)
+	}
+	if again.Envelope.Retries != 0 {
+		t.Fatalf("expected the takeover not to count as a retry, got %d", again.Envelope.Retries)
+	}
+}
+
+func TestForceReassign(t *testing.T) {
+	type Test struct{ A string }
+	// Without background maintenance, which would hold the database while it is reopened
+	q := newTestQueue[Test](t, WithSynchronousMaintenance()).WithWorkerID("wedged")
+	name := strings.TrimSuffix(strings.TrimPrefix(q.DSN(), "file:.db/"), ".db")
+	healthy, err := NewLocalQueue[Test](name, WithSynchronousMaintenance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy.WithWorkerID("healthy")
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.ForceReassign(event.Id, "healthy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the worker it was reassigned to may claim it
+	if other, err := q.Next(); err != nil || other != nil {
+		t.Fatalf("expected nothing for other workers, got %v: %v", other, err)
+	}
+	reassigned, err := healthy.Next()
+	if err != nil || reassigned == nil || reassigned.Id != event.Id {
+		t.Fatalf("expected the reassigned event, got %v: %v", reassigned, err)
+	}
+	if reassigned.Envelope.ClaimedBy != "healthy" {
+		t.Fatalf("expected the claim to be attributed to the new worker, got %q", reassigned.Envelope.ClaimedBy)
+	}
+}
@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Remembers which remote id a local event is being handed off as, so a hand-off that was
+// interrupted after reserving or inserting remotely is finished with the same id instead of
+// producing a duplicate
+const CREATE_HANDOFFS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_handoffs (
+    local_id INTEGER PRIMARY KEY,
+    remote_id INTEGER NOT NULL
+);
+`
+
+const HANDOFF_QUERY = `SELECT remote_id FROM queue_handoffs WHERE local_id = ?`
+
+const RECORD_HANDOFF_QUERY = `INSERT INTO queue_handoffs (local_id, remote_id) VALUES (?, ?)`
+
+const COMPLETE_HANDOFF_QUERY = `DELETE FROM queue_handoffs WHERE local_id = ?`
+
+// How many local events a mirror pass hands off at most
+const mirrorBatchSize = 100
+
+// Accepts events into a local queue and mirrors them to a remote one in the background,
+// for producers that must never wait on the network. Each event is inserted remotely
+// exactly once and removed from the local queue once it was. See NewTieredQueue
+type TieredQueue[T any] struct {
+	local    *Queue[T]
+	remote   *Queue[T]
+	interval time.Duration
+
+	// Serializes mirror passes
+	lock   sync.Mutex
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+// Mirror events inserted into local to remote, typically a queue opened with NewTursoQueue,
+// every interval and shortly after each insert. The local queue is only a staging area:
+// nothing else should consume from it. Events not yet mirrored stay in the local database
+// and are picked up again by the next TieredQueue opened over it
+func NewTieredQueue[T any](local *Queue[T], remote *Queue[T], interval time.Duration) *TieredQueue[T] {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	t := &TieredQueue[T]{
+		local:    local,
+		remote:   remote,
+		interval: interval,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Insert payload into the local queue, it reaches the remote queue asynchronously
+func (t *TieredQueue[T]) Insert(payload T, opts ...InsertOption) error {
+	if err := t.local.Insert(payload, opts...); err != nil {
+		return err
+	}
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Mirror every local event to the remote queue before returning, e.g. before shutdown
+func (t *TieredQueue[T]) Flush() error {
+	for {
+		mirrored, err := t.mirror()
+		if err != nil {
+			return err
+		}
+		if mirrored == 0 {
+			return nil
+		}
+	}
+}
+
+// How many events are waiting to be mirrored
+func (t *TieredQueue[T]) Backlog() (int, error) {
+	return t.local.Size()
+}
+
+// Stop mirroring in the background. Events not yet mirrored stay in the local queue
+func (t *TieredQueue[T]) Close() {
+	t.closed.Do(func() {
+		close(t.stop)
+		<-t.done
+	})
+}
+
+func (t *TieredQueue[T]) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.kick:
+		case <-ticker.C:
+		}
+		if err := t.Flush(); err != nil {
+			t.local.logger().Error(fmt.Sprintf("problem mirroring events to the remote queue: %v", err))
+		}
+	}
+}
+
+// Hand off up to mirrorBatchSize local events, returning how many were handed off. Events
+// that couldn't be handed off are released locally and retried on the next pass
+func (t *TieredQueue[T]) mirror() (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	events, err := t.local.Lease(mirrorBatchSize, t.local.claimTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("problem claiming local events: %w", err)
+	}
+	for i, event := range events {
+		if err := t.handOff(event); err != nil {
+			if releaseErr := t.local.releaseEvents(events[i:]); releaseErr != nil {
+				t.local.logger().Error(fmt.Sprintf("problem releasing events that weren't mirrored: %v", releaseErr))
+			}
+			return i, fmt.Errorf("problem mirroring event %d: %w", event.Id, err)
+		}
+	}
+	return len(events), nil
+}
+
+// Insert event remotely with the id recorded for it, reserving one first if there is none,
+// then ack it locally
+func (t *TieredQueue[T]) handOff(event *Event[T]) error {
+	remoteID, err := t.remoteID(event.Id)
+	if err != nil {
+		return err
+	}
+	err = t.remote.InsertReserved(Reservation{First: remoteID, Count: 1}, []T{*event.Content}, mirroredOptions(event.Envelope)...)
+	// The reserved id was used by an earlier attempt that didn't get to ack locally
+	if err != nil && !errors.Is(err, ErrIDNotReserved) {
+		return err
+	}
+	return t.completeHandOff(event.Id)
+}
+
+// The remote id event localID is handed off as
+func (t *TieredQueue[T]) remoteID(localID int) (int, error) {
+	var remoteID int
+	t.local.lock.RLock()
+	err := t.local.db.QueryRow(HANDOFF_QUERY, localID).Scan(&remoteID)
+	t.local.lock.RUnlock()
+	if err == nil {
+		return remoteID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("problem looking up hand-off: %w", err)
+	}
+	reservation, err := t.remote.ReserveIDs(1)
+	if err != nil {
+		return 0, err
+	}
+	t.local.lock.Lock()
+	_, err = t.local.db.Exec(RECORD_HANDOFF_QUERY, localID, reservation.First)
+	t.local.lock.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("problem recording hand-off: %w", err)
+	}
+	return reservation.First, nil
+}
+
+// Ack the local event and forget its hand-off in one transaction
+func (t *TieredQueue[T]) completeHandOff(localID int) error {
+	var ts transitions
+	t.local.lock.Lock()
+	err := t.local.completeHandOffTx(localID, &ts)
+	t.local.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("problem completing hand-off: %w", err)
+	}
+	t.local.notifyTransitions(ts)
+	t.local.checkEmpty()
+	return nil
+}
+
+func (q *Queue[T]) completeHandOffTx(id int, ts *transitions) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	if err := q.ack(tx, id, q.purgeTime(), ts); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(COMPLETE_HANDOFF_QUERY, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	return nil
+}
+
+// Insert options that reproduce what was stored with envelope
+func mirroredOptions(envelope Envelope) []InsertOption {
+	opts := []InsertOption{WithSchemaVersion(envelope.SchemaVersion), WithTags(envelope.Tags...), WithResourceTokens(envelope.Resources...)}
+	if envelope.Kind != "" {
+		opts = append(opts, WithKind(envelope.Kind))
+	}
+	if len(envelope.Headers) > 0 {
+		opts = append(opts, WithHeaders(envelope.Headers))
+	}
+	if !envelope.ExpiresAt.IsZero() {
+		opts = append(opts, WithExpiresAt(envelope.ExpiresAt))
+	}
+	if envelope.DeliveryWindow != nil {
+		opts = append(opts, WithEventDeliveryWindow(*envelope.DeliveryWindow))
+	}
+	if envelope.MaxRetries != nil {
+		opts = append(opts, WithEventMaxRetries(*envelope.MaxRetries))
+	}
+	if envelope.ClaimTimeout > 0 {
+		opts = append(opts, WithEventClaimTimeout(envelope.ClaimTimeout))
+	}
+	if envelope.Retention > 0 {
+		opts = append(opts, WithEventRetention(envelope.Retention))
+	}
+	return opts
+}
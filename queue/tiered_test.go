@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTieredQueueMirrors(t *testing.T) {
+	type Test struct{ A string }
+	local := newTestQueue[Test](t)
+	remote := newTestQueue[Test](t)
+	tiered := NewTieredQueue(local, remote, time.Hour)
+	defer tiered.Close()
+
+	if err := tiered.Insert(Test{A: "first"}, WithKind("greeting"), WithTags("a"), WithEventMaxRetries(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tiered.Insert(Test{A: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tiered.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if backlog, err := tiered.Backlog(); err != nil || backlog != 0 {
+		t.Fatalf("expected an empty backlog, got %d: %v", backlog, err)
+	}
+	first, err := remote.Next()
+	if err != nil || first == nil {
+		t.Fatalf("expected a mirrored event, got %v: %v", first, err)
+	}
+	if first.Content.A != "first" || first.Envelope.Kind != "greeting" || len(first.Envelope.Tags) != 1 || first.Envelope.retryLimit(0) != 3 {
+		t.Fatalf("expected the first event with its kind, tags and retry limit, got %+v", first.Envelope)
+	}
+	second, err := remote.Next()
+	if err != nil || second == nil || second.Content.A != "second" {
+		t.Fatalf("expected the second event, got %v: %v", second, err)
+	}
+}
+
+func TestTieredQueueResumesHandOff(t *testing.T) {
+	type Test struct{ A string }
+	local := newTestQueue[Test](t)
+	remote := newTestQueue[Test](t)
+	if err := local.Insert(Test{A: "once"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A previous hand-off inserted remotely but stopped before acking locally
+	reservation, err := remote.ReserveIDs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.InsertReserved(reservation, []Test{{A: "once"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.db.Exec(RECORD_HANDOFF_QUERY, 1, reservation.First); err != nil {
+		t.Fatal(err)
+	}
+
+	tiered := NewTieredQueue(local, remote, time.Hour)
+	defer tiered.Close()
+	if err := tiered.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := remote.Size(); err != nil || size != 1 {
+		t.Fatalf("expected the event to be mirrored once, got %d: %v", size, err)
+	}
+	if size, err := local.Size(); err != nil || size != 0 {
+		t.Fatalf("expected the local event to be acked, got %d: %v", size, err)
+	}
+}
+
+func TestTieredQueueMirrorsDeliveryWindows(t *testing.T) {
+	type Test struct{ A string }
+	local := newTestQueue[Test](t)
+	remote := newTestQueue[Test](t)
+	tiered := NewTieredQueue(local, remote, time.Hour)
+	defer tiered.Close()
+
+	// Open now, so the event can be handed off, in a zone two hours ahead of UTC
+	zone := time.FixedZone("", 2*60*60)
+	now := time.Now().In(zone)
+	sinceMidnight := time.Duration(now.Hour()) * time.Hour
+	window := DeliveryWindow{Start: sinceMidnight - time.Hour, End: sinceMidnight + 2*time.Hour, Location: zone}
+	if err := tiered.Insert(Test{A: "windowed"}, WithEventDeliveryWindow(window)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tiered.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var mirrored [3]int
+	err := remote.db.QueryRow("SELECT window_start, window_end, window_offset FROM queue").Scan(&mirrored[0], &mirrored[1], &mirrored[2])
+	if expected := window.columns(now); err != nil || mirrored != [3]int{expected[0].(int), expected[1].(int), expected[2].(int)} {
+		t.Fatalf("expected the window %v to be mirrored, got %v: %v", expected, mirrored, err)
+	}
+}
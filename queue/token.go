@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Returns the auth token to connect to Turso with, see WithAuthTokenProvider
+type AuthTokenProvider func(ctx context.Context) (string, error)
+
+// How long connections to Turso are reused when tokens come from an AuthTokenProvider
+const tokenConnMaxLifetime = 5 * time.Minute
+
+// Get the Turso auth token from provider, e.g. a secrets manager, instead of
+// TURSO_AUTH_TOKEN. The provider is consulted whenever a new connection is made and
+// connections are recycled every few minutes, so long-running workers pick up rotated
+// tokens without recreating the Queue. Only used by NewTursoQueue
+func WithAuthTokenProvider(provider AuthTokenProvider) Option {
+	return func(o *options) {
+		o.authTokenProvider = provider
+	}
+}
+
+// Makes connections with the latest token from a provider. Connections made with an older
+// token keep working until they are recycled
+type tokenConnector struct {
+	driver   driver.Driver
+	provider AuthTokenProvider
+	// Opens a connector authenticated with token
+	open func(token string) (driver.Connector, error)
+
+	lock      sync.Mutex
+	token     string
+	connector driver.Connector
+}
+
+func newTokenConnector(dbUrl string, provider AuthTokenProvider) (*tokenConnector, error) {
+	u, err := url.Parse(dbUrl)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing database url: %w", err)
+	}
+	open := func(token string) (driver.Connector, error) {
+		authenticated := *u
+		query := authenticated.Query()
+		query.Set("authToken", token)
+		authenticated.RawQuery = query.Encode()
+		return libsqlConnector(authenticated.String())
+	}
+	drv, err := libsqlDriver()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenConnector{driver: drv, provider: provider, open: open}, nil
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.provider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("problem getting auth token: %w", err)
+	}
+	c.lock.Lock()
+	if c.connector == nil || token != c.token {
+		connector, err := c.open(token)
+		if err != nil {
+			c.lock.Unlock()
+			return nil, err
+		}
+		c.token, c.connector = token, connector
+	}
+	connector := c.connector
+	c.lock.Unlock()
+	return connector.Connect(ctx)
+}
+
+func (c *tokenConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTokenConnector(t *testing.T) {
+	path := fmt.Sprintf("%s/%s.db", t.TempDir(), randomString(10))
+	token := "first"
+	connectedWith := []string{}
+	drv, err := libsqlDriver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector := &tokenConnector{
+		driver: drv,
+		provider: func(ctx context.Context) (string, error) {
+			return token, nil
+		},
+		// A local database stands in for Turso, recording the tokens it was opened with
+		open: func(token string) (driver.Connector, error) {
+			connectedWith = append(connectedWith, token)
+			return libsqlConnector("file:" + path)
+		},
+	}
+	db := sql.OpenDB(connector)
+	defer func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+	}()
+
+	if _, err := db.Exec("CREATE TABLE t (a TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	// Rotate the token and force a new connection
+	token = "second"
+	db.SetMaxIdleConns(0)
+	if _, err := db.Exec("INSERT INTO t VALUES ('x')"); err != nil {
+		t.Fatal(err)
+	}
+	if len(connectedWith) != 2 || connectedWith[0] != "first" || connectedWith[1] != "second" {
+		t.Fatalf("expected connections with both tokens, got %v", connectedWith)
+	}
+}
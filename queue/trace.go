@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// Names of the runtime/trace tasks and regions recorded when tracing is enabled
+const (
+	TRACE_TASK_EVENT     = "libsqlq.event"
+	TRACE_REGION_CLAIM   = "libsqlq.claim"
+	TRACE_REGION_HANDLER = "libsqlq.handler"
+	TRACE_REGION_ACK     = "libsqlq.ack"
+	TRACE_REGION_NACK    = "libsqlq.nack"
+)
+
+// Annotate claims, acks, nacks and handler execution with runtime/trace regions so that
+// `go tool trace` shows queue activity alongside the rest of the program. Each event
+// processed through DrainTo or ProcessFor gets its own trace task. Regions
+// are only recorded while a trace is being collected, so the cost when not tracing is negligible.
+func (q *Queue[T]) WithTracing() *Queue[T] {
+	q.tracing = true
+	return q
+}
+
+// Start a trace region if tracing is enabled, the returned func ends it
+func (q *Queue[T]) startRegion(ctx context.Context, name string) func() {
+	if !q.tracing {
+		return func() {}
+	}
+	return trace.StartRegion(ctx, name).End
+}
+
+// Start a trace task for processing a single event if tracing is enabled, the returned
+// func ends it
+func (q *Queue[T]) startEventTask(ctx context.Context) (context.Context, func()) {
+	if !q.tracing {
+		return ctx, func() {}
+	}
+	ctx, task := trace.NewTask(ctx, TRACE_TASK_EVENT)
+	return ctx, task.End
+}
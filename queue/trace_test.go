@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"runtime/trace"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithTracing()
+
+	if err := q.Insert(Test{A: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("tracing unavailable: %v", err)
+	}
+	_, err := q.DrainTo(func(ctx context.Context, event *Event[Test]) error {
+		return nil
+	})
+	trace.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{TRACE_TASK_EVENT, TRACE_REGION_CLAIM, TRACE_REGION_HANDLER, TRACE_REGION_ACK} {
+		if !bytes.Contains(buf.Bytes(), []byte(name)) {
+			t.Fatalf("expected trace to contain %s", name)
+		}
+	}
+}
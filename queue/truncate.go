@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The state archived events are exported with by TruncateArchive
+const EVENT_STATE_ARCHIVED = "archived"
+
+// Where the hash chain was cut by TruncateArchive, so Verify can pick up from the last
+// truncated link instead of the start of the chain
+const CREATE_ARCHIVE_CHECKPOINTS_TABLE_STATEMENT = `CREATE TABLE IF NOT EXISTS queue_archive_checkpoints (
+    seq INTEGER PRIMARY KEY,             -- last hash chain link that was truncated
+    head TEXT NOT NULL,                  -- hash of that link
+    events INTEGER NOT NULL,             -- number of archived events truncated
+    truncated_at TEXT DEFAULT (datetime('now', 'utc'))
+);
+`
+
+const LATEST_ARCHIVE_CHECKPOINT_QUERY = `SELECT seq, head FROM queue_archive_checkpoints ORDER BY seq DESC LIMIT 1`
+
+const INSERT_ARCHIVE_CHECKPOINT_QUERY = `INSERT INTO queue_archive_checkpoints (seq, head, events) VALUES (?, ?, ?)`
+
+// Archived events acked before the bound time, every archived event if it is NULL
+const ARCHIVED_BEFORE_CONDITION = `(acked_at IS NULL OR acked_at < IFNULL(?, '9999-12-31'))`
+
+// The last hash chain link that can be truncated for a cutoff: links are only removed from
+// the start of the chain, up to the first link whose event is kept
+const CHAIN_CUT_QUERY = `SELECT IFNULL(
+    (SELECT MIN(seq) - 1 FROM queue_archive_chain WHERE event_id NOT IN (SELECT id FROM queue_archive WHERE ` + ARCHIVED_BEFORE_CONDITION + `)),
+    (SELECT IFNULL(MAX(seq), 0) FROM queue_archive_chain)
+)`
+
+// Archived events that TruncateArchive removes: acked before the cutoff and not linked
+// after the chain cut
+const TRUNCATE_ARCHIVE_CONDITION = ARCHIVED_BEFORE_CONDITION + ` AND id NOT IN (SELECT event_id FROM queue_archive_chain WHERE seq > ?)`
+
+// The result of TruncateArchive
+type ArchiveTruncation struct {
+	// Number of archived events written and deleted
+	Events int
+	// Hash of the last truncated hash chain link, empty if no links were truncated. Verify
+	// continues the chain from it
+	Head string
+}
+
+// Write the archived events acked before the cutoff to w as NDJSON, in StreamList's format
+// with state EVENT_STATE_ARCHIVED, then delete them along with their tags, keeping the
+// database lean once the history has been shipped to a warehouse. Everything happens in one
+// transaction: w is flushed, and synced if it has a Sync method like *os.File, before the
+// events are deleted, and nothing is deleted if writing fails. A zero before truncates the
+// whole archive.
+//
+// For queues configured WithArchiveHashChain only a prefix of the chain is truncated, so
+// events archived after one that is kept stay in the database even if they are older than
+// the cutoff. The last truncated link is recorded as a checkpoint that Verify continues
+// from. The queue's lock is held throughout, so truncate large archives off-peak
+func (q *Queue[T]) TruncateArchive(ctx context.Context, w io.Writer, before time.Time) (ArchiveTruncation, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var cutoff any
+	if !before.IsZero() {
+		cutoff = formatSqliteTime(before)
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+	var cut int
+	if err := tx.QueryRow(CHAIN_CUT_QUERY, cutoff).Scan(&cut); err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem reading hash chain: %w", err)
+	}
+
+	truncation := ArchiveTruncation{}
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	query := fmt.Sprintf(LIST_QUERY_TEMPLATE, "NULL", ARCHIVE_TABLE, "WHERE id > ? AND "+TRUNCATE_ARCHIVE_CONDITION) + " LIMIT ?"
+	afterID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return ArchiveTruncation{}, err
+		}
+		page, err := listTable(tx, query, EVENT_STATE_ARCHIVED, afterID, cutoff, cut, streamPageSize)
+		if err != nil {
+			return ArchiveTruncation{}, fmt.Errorf("problem listing archived events: %w", err)
+		}
+		for _, envelope := range page {
+			event, err := streamedEvent(envelope)
+			if err != nil {
+				return ArchiveTruncation{}, fmt.Errorf("problem encoding event %d: %w", envelope.Id, err)
+			}
+			if q.idCodec != nil {
+				event.ExternalID = q.idCodec.EncodeID(envelope.Id)
+			}
+			if err := encoder.Encode(event); err != nil {
+				return ArchiveTruncation{}, fmt.Errorf("problem writing archived events: %w", err)
+			}
+			truncation.Events++
+		}
+		if len(page) < streamPageSize {
+			break
+		}
+		afterID = page[len(page)-1].Id
+	}
+	if err := out.Flush(); err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem writing archived events: %w", err)
+	}
+	if syncer, ok := w.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return ArchiveTruncation{}, fmt.Errorf("problem syncing archived events: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM queue_tags WHERE event_id IN (SELECT id FROM queue_archive WHERE "+TRUNCATE_ARCHIVE_CONDITION+")", cutoff, cut); err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem truncating tags of archived events: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM queue_archive WHERE "+TRUNCATE_ARCHIVE_CONDITION, cutoff, cut); err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem truncating archived events: %w", err)
+	}
+	checkpoint, _, err := latestArchiveCheckpoint(tx)
+	if err != nil {
+		return ArchiveTruncation{}, err
+	}
+	if cut > checkpoint {
+		if err := tx.QueryRow("SELECT hash FROM queue_archive_chain WHERE seq = ?", cut).Scan(&truncation.Head); err != nil {
+			return ArchiveTruncation{}, fmt.Errorf("problem reading hash chain link %d: %w", cut, err)
+		}
+		if _, err := tx.Exec(INSERT_ARCHIVE_CHECKPOINT_QUERY, cut, truncation.Head, truncation.Events); err != nil {
+			return ArchiveTruncation{}, fmt.Errorf("problem recording archive checkpoint: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM queue_archive_chain WHERE seq <= ?", cut); err != nil {
+			return ArchiveTruncation{}, fmt.Errorf("problem truncating hash chain: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ArchiveTruncation{}, fmt.Errorf("problem committing archive truncation: %w", err)
+	}
+	return truncation, nil
+}
+
+// The last hash chain link truncated by TruncateArchive and its hash, 0 and empty if the
+// chain was never truncated
+func latestArchiveCheckpoint(tx *sql.Tx) (int, string, error) {
+	var (
+		seq  int
+		head string
+	)
+	err := tx.QueryRow(LATEST_ARCHIVE_CHECKPOINT_QUERY).Scan(&seq, &head)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", fmt.Errorf("problem reading archive checkpoint: %w", err)
+	}
+	return seq, head, nil
+}
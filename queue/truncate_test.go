@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTruncateArchiveKeepsHashChainVerifiable(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithArchiveHashChain()
+	for _, a := range []string{"one", "two", "three"} {
+		if err := q.Insert(Test{A: a}); err != nil {
+			t.Fatal(err)
+		}
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		if err := q.Ack(event.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Event 3 is old enough too, but was archived after event 2 which is kept. Rebuild the
+	// chain over the backdated events
+	tx, err := q.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("UPDATE queue_archive SET acked_at = datetime('now', '-2 hours', 'utc') WHERE id IN (1, 3)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{"DELETE FROM queue_archive_chain", "DELETE FROM sqlite_sequence WHERE name = 'queue_archive_chain'"} {
+		if _, err := tx.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chainArchived(tx, []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := q.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	truncation, err := q.TruncateArchive(context.Background(), &out, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncation.Events != 1 || truncation.Head == "" {
+		t.Fatalf("expected only the first event to be truncated, got %+v", truncation)
+	}
+	var event StreamedEvent
+	if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != 1 || event.State != EVENT_STATE_ARCHIVED || string(event.Payload) != `{"A":"one"}` {
+		t.Fatalf("expected event 1 to be exported, got %+v", event)
+	}
+	after, err := q.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Events != 2 || after.Head != before.Head {
+		t.Fatalf("expected the rest of the chain to verify, got %+v", after)
+	}
+
+	out.Reset()
+	truncation, err = q.TruncateArchive(context.Background(), &out, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncation.Events != 2 || truncation.Head != before.Head {
+		t.Fatalf("expected the rest of the archive to be truncated, got %+v", truncation)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 2 {
+		t.Fatalf("expected 2 exported events, got %d", lines)
+	}
+	after, err = q.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Events != 0 || after.Head != before.Head {
+		t.Fatalf("expected an empty chain continuing from the checkpoint, got %+v", after)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTruncateArchiveKeepsEventsWhenWritingFails(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithArchive()
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil || event == nil {
+		t.Fatalf("expected an event, got %v: %v", event, err)
+	}
+	if err := q.Ack(event.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.TruncateArchive(context.Background(), failingWriter{}, time.Time{}); err == nil {
+		t.Fatal("expected the write failure to be returned")
+	}
+	var archived int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM queue_archive").Scan(&archived); err != nil {
+		t.Fatal(err)
+	}
+	if archived != 1 {
+		t.Fatalf("expected the archived event to be kept, got %d", archived)
+	}
+}
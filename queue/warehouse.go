@@ -0,0 +1,316 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rows finished in the bound second after the bound id, or in a later second, up to two
+// seconds ago so rows stamped with an earlier second by transactions still in progress
+// aren't skipped. %[1]s is the column holding when the row was finished
+const WAREHOUSE_ROWS_QUERY_TEMPLATE = `SELECT ` + ENVELOPE_COLUMNS + `, %[2]s, unixepoch(%[1]s) FROM %[3]s
+WHERE (unixepoch(%[1]s) > ? OR (unixepoch(%[1]s) = ? AND id > ?)) AND unixepoch(%[1]s) < unixepoch('now') - 1
+ORDER BY unixepoch(%[1]s) ASC, id ASC LIMIT ?`
+
+// Scope of the bookmarks of warehouse exporters, keyed by exporter name and table
+const WAREHOUSE_KV_SCOPE = "warehouse"
+
+// How many rows a warehouse export reads per query
+const warehousePageSize = 1000
+
+// The header of the CSV files written by a WarehouseExporter
+var WAREHOUSE_CSV_HEADER = []string{"id", "state", "kind", "tags", "headers", "enqueued_at", "finished_at", "retries", "attempts", "dead_reason", "payload"}
+
+// A completed or dead event as exported to a warehouse
+type WarehouseRow struct {
+	Id    int
+	State string
+	Kind  string
+	Tags  []string
+	// Encoded as a JSON object in CSV files
+	Headers    map[string]string
+	EnqueuedAt time.Time
+	// When the event was acked, archived or dead lettered
+	FinishedAt time.Time
+	Retries    int
+	Attempts   int
+	DeadReason string
+	// The encoded payload
+	Payload []byte
+}
+
+type WarehouseOptions struct {
+	// Prefix of the written files and key of the bookmark, so several exporters can ship
+	// the same queue to different places. Defaults to "warehouse"
+	Name string
+	// Directory every run writes a new CSV file to. Ignored if Sink is set
+	Dir string
+	// Called with the rows of every run instead of writing CSV files, e.g. to write Parquet
+	// or stream to a warehouse directly. The bookmark only moves on once it returns nil, so
+	// rows are delivered at least once
+	Sink func(rows []WarehouseRow) error
+	// How often the exporter runs, defaults to a minute
+	Interval time.Duration
+}
+
+// Periodically ships completed and dead events of a queue to files or a Sink for analytics
+// pipelines, keeping a bookmark next to the queue so each run only exports rows finished
+// since the last one. Acked events, exported in state EVENT_STATE_COMPLETED, are read from
+// the archive for queues configured WithArchive and from the completed table otherwise, so
+// the queue must keep acked events for at least one Interval with WithArchive or
+// WithAckGracePeriod for them to be exported. Rows are exported about two seconds after they
+// were finished. See NewWarehouseExporter
+type WarehouseExporter[T any] struct {
+	q    *Queue[T]
+	opts WarehouseOptions
+
+	// Serializes runs
+	lock   sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+// The position of an exporter in one table: the last exported row's finish time in unix
+// seconds and id
+type warehouseBookmark struct {
+	At int64 `json:"at"`
+	Id int   `json:"id"`
+}
+
+// Export events of q every opts.Interval in the background until Close. Parquet isn't
+// written natively to keep the module free of heavy dependencies, pass a Sink that does
+func NewWarehouseExporter[T any](q *Queue[T], opts WarehouseOptions) (*WarehouseExporter[T], error) {
+	if opts.Sink == nil && opts.Dir == "" {
+		return nil, fmt.Errorf("one of Dir or Sink is required")
+	}
+	if opts.Name == "" {
+		opts.Name = "warehouse"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	e := &WarehouseExporter[T]{
+		q:    q,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export the rows finished since the last run now, returning how many were exported
+func (e *WarehouseExporter[T]) Export() (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ackedTable, ackedColumn := COMPLETED_TABLE, "completed_at"
+	if e.q.archived() {
+		ackedTable, ackedColumn = ARCHIVE_TABLE, "acked_at"
+	}
+	exported := 0
+	for _, source := range []struct{ table, column string }{{ackedTable, ackedColumn}, {DEAD_TABLE, "dead_at"}} {
+		count, err := e.exportTable(source.table, source.column)
+		exported += count
+		if err != nil {
+			return exported, err
+		}
+	}
+	return exported, nil
+}
+
+// Stop exporting in the background, after a run in progress has finished
+func (e *WarehouseExporter[T]) Close() {
+	e.closed.Do(func() {
+		close(e.stop)
+		<-e.done
+	})
+}
+
+func (e *WarehouseExporter[T]) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+		if _, err := e.Export(); err != nil {
+			e.q.logger().Error(fmt.Sprintf("problem exporting events to the warehouse: %v", err))
+		}
+	}
+}
+
+// Export the new rows of table a page at a time, moving the bookmark after every page
+func (e *WarehouseExporter[T]) exportTable(table string, column string) (int, error) {
+	key := e.opts.Name + ":" + table
+	bookmark, err := e.bookmark(key)
+	if err != nil {
+		return 0, err
+	}
+	reasonColumn, state := "NULL", EVENT_STATE_COMPLETED
+	if table == DEAD_TABLE {
+		reasonColumn, state = "reason", EVENT_STATE_DEAD
+	}
+	query := fmt.Sprintf(WAREHOUSE_ROWS_QUERY_TEMPLATE, column, reasonColumn, table)
+	exported := 0
+	for {
+		rows, last, err := e.page(query, state, bookmark)
+		if err != nil {
+			return exported, fmt.Errorf("problem reading %s events: %w", state, err)
+		}
+		if len(rows) == 0 {
+			return exported, nil
+		}
+		if err := e.write(rows); err != nil {
+			return exported, fmt.Errorf("problem exporting %s events: %w", state, err)
+		}
+		value, err := json.Marshal(last)
+		if err != nil {
+			return exported, err
+		}
+		if err := e.bookmarks().Set(key, value); err != nil {
+			return exported, fmt.Errorf("problem saving warehouse bookmark: %w", err)
+		}
+		exported += len(rows)
+		bookmark = last
+		if len(rows) < warehousePageSize {
+			return exported, nil
+		}
+	}
+}
+
+// Up to warehousePageSize rows in state after bookmark, and the bookmark of the last one
+func (e *WarehouseExporter[T]) page(query string, state string, bookmark warehouseBookmark) ([]WarehouseRow, warehouseBookmark, error) {
+	e.q.lock.RLock()
+	defer e.q.lock.RUnlock()
+	rows, err := e.q.db.Query(query, bookmark.At, bookmark.At, bookmark.Id, warehousePageSize)
+	if err != nil {
+		return nil, bookmark, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	page := []WarehouseRow{}
+	for rows.Next() {
+		var (
+			reason     sql.NullString
+			finishedAt int64
+		)
+		envelope, err := scanEnvelope(rows, state, &reason, &finishedAt)
+		if err != nil {
+			return nil, bookmark, err
+		}
+		row := WarehouseRow{
+			Id:         envelope.Id,
+			State:      envelope.State,
+			Kind:       envelope.Kind,
+			Tags:       envelope.Tags,
+			Headers:    envelope.Headers,
+			EnqueuedAt: envelope.EnqueuedAt,
+			FinishedAt: time.Unix(finishedAt, 0).UTC(),
+			Retries:    envelope.Retries,
+			Attempts:   len(envelope.Attempts),
+			DeadReason: reason.String,
+			Payload:    envelope.Payload,
+		}
+		page = append(page, row)
+		bookmark = warehouseBookmark{At: finishedAt, Id: envelope.Id}
+	}
+	return page, bookmark, rows.Err()
+}
+
+// Hand rows to the sink, or write them to a new CSV file in the export directory. Files are
+// written under a temporary name and renamed once complete, so pipelines watching the
+// directory never read a partial file
+func (e *WarehouseExporter[T]) write(rows []WarehouseRow) error {
+	if e.opts.Sink != nil {
+		return e.opts.Sink(rows)
+	}
+	name := fmt.Sprintf("%s-%s-%d-%d.csv", e.opts.Name, rows[0].State, rows[0].FinishedAt.Unix(), rows[0].Id)
+	path := filepath.Join(e.opts.Dir, name)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if err := writeWarehouseCSV(file, rows); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func writeWarehouseCSV(file *os.File, rows []WarehouseRow) error {
+	w := csv.NewWriter(file)
+	if err := w.Write(WAREHOUSE_CSV_HEADER); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		headers := ""
+		if len(row.Headers) > 0 {
+			encoded, err := json.Marshal(row.Headers)
+			if err != nil {
+				return err
+			}
+			headers = string(encoded)
+		}
+		record := []string{
+			strconv.Itoa(row.Id),
+			row.State,
+			row.Kind,
+			strings.Join(row.Tags, ";"),
+			headers,
+			row.EnqueuedAt.UTC().Format(time.RFC3339),
+			row.FinishedAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(row.Retries),
+			strconv.Itoa(row.Attempts),
+			row.DeadReason,
+			string(row.Payload),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Bookmarks of every exporter of the queue, kept out of reach of Queue.KV so they can't
+// collide with application keys
+func (e *WarehouseExporter[T]) bookmarks() KV {
+	return KV{db: e.q.db, lock: &e.q.lock, scope: WAREHOUSE_KV_SCOPE}
+}
+
+// Where the exporter left off in the table under key
+func (e *WarehouseExporter[T]) bookmark(key string) (warehouseBookmark, error) {
+	var bookmark warehouseBookmark
+	value, ok, err := e.bookmarks().Get(key)
+	if err != nil {
+		return bookmark, fmt.Errorf("problem reading warehouse bookmark: %w", err)
+	}
+	if !ok {
+		return bookmark, nil
+	}
+	if err := json.Unmarshal(value, &bookmark); err != nil {
+		return bookmark, fmt.Errorf("problem decoding warehouse bookmark: %w", err)
+	}
+	return bookmark, nil
+}
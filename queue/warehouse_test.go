@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWarehouseExporterOnlyExportsNewRows(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithAckGracePeriod(time.Hour)
+	for _, a := range []string{"done", "cancelled"} {
+		if err := q.Insert(Test{A: a}, WithTags(a)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Ack(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.CancelTagged("cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	backdate := func() {
+		for _, statement := range []string{
+			"UPDATE queue_completed SET completed_at = datetime('now', '-1 minute', 'utc')",
+			"UPDATE queue_dead SET dead_at = datetime('now', '-1 minute', 'utc')",
+		} {
+			if _, err := q.db.Exec(statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	backdate()
+
+	// An application key that looks like a bookmark doesn't get in the way
+	if err := q.KV().Set("warehouse:queue_dead", []byte("not a bookmark")); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := []WarehouseRow{}
+	exporter, err := NewWarehouseExporter(q, WarehouseOptions{Interval: time.Hour, Sink: func(rows []WarehouseRow) error {
+		exported = append(exported, rows...)
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	count, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(exported) != 2 {
+		t.Fatalf("expected 2 rows to be exported, got %d", count)
+	}
+	if exported[0].Id != 1 || exported[0].State != EVENT_STATE_COMPLETED {
+		t.Fatalf("expected the completed event first, got %+v", exported[0])
+	}
+	if exported[1].Id != 2 || exported[1].State != EVENT_STATE_DEAD || exported[1].DeadReason != DEAD_REASON_CANCELLED {
+		t.Fatalf("expected the cancelled event to be exported as dead, got %+v", exported[1])
+	}
+
+	if count, err := exporter.Export(); err != nil || count != 0 {
+		t.Fatalf("expected nothing new to export, got %d: %v", count, err)
+	}
+	if value, _, err := q.KV().Get("warehouse:queue_dead"); err != nil || string(value) != "not a bookmark" {
+		t.Fatalf("expected the application key to be left alone, got %q: %v", value, err)
+	}
+	if err := q.Insert(Test{A: "later"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(3); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := exporter.Export(); err != nil || count != 0 {
+		t.Fatalf("expected rows finished in the last seconds to wait, got %d: %v", count, err)
+	}
+	backdate()
+	if count, err := exporter.Export(); err != nil || count != 1 || exported[2].Id != 3 {
+		t.Fatalf("expected only the new event to be exported, got %d: %v", count, err)
+	}
+}
+
+func TestWarehouseExporterWritesCSVFiles(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithArchive()
+	if err := q.Insert(Test{A: "done"}, WithKind("report")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.db.Exec("UPDATE queue_archive SET acked_at = datetime('now', '-1 minute', 'utc')"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	exporter, err := NewWarehouseExporter(q, WarehouseOptions{Dir: dir, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if _, err := exporter.Export(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "warehouse-completed-*.csv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one CSV file, got %v: %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0][0] != "id" {
+		t.Fatalf("expected a header and one row, got %v", records)
+	}
+	if row := records[1]; row[0] != "1" || row[2] != "report" || row[10] != `{"A":"done"}` {
+		t.Fatalf("unexpected row %v", row)
+	}
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const COUNT_INFLIGHT_TEMPLATE = `SELECT COUNT(*) FROM queue_inflight WHERE %s`
+
+const COUNT_EXHAUSTED_QUERY = `SELECT COUNT(*) FROM queue WHERE retries > ` + RETRY_LIMIT_EXPRESSION
+
+// Claims that maintenance never reclaims
+const UNEXPIRING_CLAIMS_CONDITION = `claim_expires IS NULL`
+
+// Claims expiring later than the claim timeout allows, bound to the clock's after expression.
+// Events with their own claim timeout, see WithEventClaimTimeout, may be claimed for longer
+const OVERLONG_CLAIMS_CONDITION_TEMPLATE = `claim_timeout IS NULL AND claim_expires > %s`
+
+const CLAMP_CLAIMS_QUERY_TEMPLATE = `UPDATE queue_inflight SET claim_expires = %s WHERE claim_timeout IS NULL AND claim_expires > %s`
+
+// What VerifyWarmStart found, and fixed if asked to
+type WarmStartReport struct {
+	// Why the schema doesn't match what the queue expects, empty if it does. Never repaired
+	SchemaProblem string
+	// In-flight events claimed under this process's worker id by a previous epoch, only
+	// checked for queues configured WithWorkerID. Released anyway once the queue starts
+	// consuming, see Queue.Epoch
+	OrphanedClaims int
+	// In-flight events without a claim expiry, which would never be reclaimed
+	UnexpiringClaims int
+	// In-flight events whose claim expires later than the claim timeout allows, e.g. after
+	// the timeout was lowered
+	OverlongClaims int
+	// In-flight events whose claim expired without being reclaimed yet
+	ExpiredClaims int
+	// Pending events with more retries than the queue allows
+	ExhaustedEvents int
+	// Whether the problems other than SchemaProblem were fixed
+	Repaired bool
+}
+
+// Describe every problem found, empty if there are none
+func (r WarmStartReport) Problems() []string {
+	problems := []string{}
+	if r.SchemaProblem != "" {
+		problems = append(problems, r.SchemaProblem)
+	}
+	counts := []struct {
+		n           int
+		description string
+	}{
+		{r.OrphanedClaims, "claims left behind by a previous epoch of this worker id"},
+		{r.UnexpiringClaims, "claims that never expire"},
+		{r.OverlongClaims, "claims expiring later than the claim timeout allows"},
+		{r.ExpiredClaims, "expired claims not reclaimed yet"},
+		{r.ExhaustedEvents, "pending events with more retries than allowed"},
+	}
+	for _, count := range counts {
+		if count.n > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", count.n, count.description))
+		}
+	}
+	return problems
+}
+
+// Check the invariants a restart after a crash can leave broken, and with repair fix
+// everything except schema problems: orphaned and unexpiring claims are released, overlong
+// claims are shortened to the claim timeout, expired claims are reclaimed and exhausted
+// events dead lettered. Call it right after opening the queue and configuring it, e.g.
+// WithWorkerID and WithClaimTimeout, before consuming
+func (q *Queue[T]) VerifyWarmStart(repair bool) (WarmStartReport, error) {
+	var report WarmStartReport
+	var ts transitions
+	q.lock.Lock()
+	err := q.verifyWarmStart(&report, repair, &ts)
+	q.lock.Unlock()
+	if err != nil {
+		return report, fmt.Errorf("problem verifying warm start: %w", err)
+	}
+	for _, problem := range report.Problems() {
+		q.logger().Warn(fmt.Sprintf("warm start found %s", problem), "repaired", report.Repaired)
+	}
+	q.notifyTransitions(ts)
+	q.checkEmpty()
+	return report, nil
+}
+
+func (q *Queue[T]) verifyWarmStart(report *WarmStartReport, repair bool, ts *transitions) error {
+	if err := validateSchema(q.db); errors.Is(err, ErrSchemaMismatch) {
+		report.SchemaProblem = err.Error()
+	} else if err != nil {
+		return err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("problem starting transaction on db %w", err)
+	}
+	defer rollback(tx)
+
+	overlong := fmt.Sprintf(OVERLONG_CLAIMS_CONDITION_TEMPLATE, q.clock.after)
+	expired := fmt.Sprintf(CLAIM_TIMEOUT_CLEANUP_CONDITION_TEMPLATE, q.clock.now)
+	if q.workerID != "" {
+		if report.OrphanedClaims, err = countInflight(tx, PREVIOUS_EPOCH_CONDITION, q.workerID, q.epoch); err != nil {
+			return err
+		}
+	}
+	if report.UnexpiringClaims, err = countInflight(tx, UNEXPIRING_CLAIMS_CONDITION); err != nil {
+		return err
+	}
+	if report.OverlongClaims, err = countInflight(tx, overlong, q.clock.offset(q.claimTimeout)); err != nil {
+		return err
+	}
+	if report.ExpiredClaims, err = countInflight(tx, expired); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(COUNT_EXHAUSTED_QUERY, q.maxRetries).Scan(&report.ExhaustedEvents); err != nil {
+		return err
+	}
+	if !repair {
+		return nil
+	}
+
+	orphaned := UNEXPIRING_CLAIMS_CONDITION
+	args := []any{}
+	if q.workerID != "" {
+		orphaned = fmt.Sprintf("((%s) OR %s)", PREVIOUS_EPOCH_CONDITION, UNEXPIRING_CLAIMS_CONDITION)
+		args = append(args, q.workerID, q.epoch)
+	}
+	released, err := moveEvents(tx, INFLIGHT_TABLE, PENDING_TABLE, orphaned, args...)
+	if err != nil {
+		return err
+	}
+	if err := releaseClaims(tx, released, ATTEMPT_RELEASED, ts); err != nil {
+		return err
+	}
+	offset := q.clock.offset(q.claimTimeout)
+	if _, err := tx.Exec(fmt.Sprintf(CLAMP_CLAIMS_QUERY_TEMPLATE, q.clock.after, q.clock.after), offset, offset); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ts.reset()
+		return err
+	}
+	if err := q.reclaimExpiredClaims(ts); err != nil {
+		return err
+	}
+	if err := q.deadLetterExhausted(ts); err != nil {
+		return err
+	}
+	report.Repaired = true
+	return nil
+}
+
+func countInflight(tx *sql.Tx, condition string, args ...any) (int, error) {
+	var n int
+	if err := tx.QueryRow(fmt.Sprintf(COUNT_INFLIGHT_TEMPLATE, condition), args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("problem counting in-flight events: %w", err)
+	}
+	return n, nil
+}
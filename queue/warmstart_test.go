@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestVerifyWarmStart(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t, WithSynchronousMaintenance())
+	q.WithWorkerID("worker-1")
+	for range 5 {
+		if err := q.Insert(Test{A: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claimed := []int{}
+	for range 5 {
+		event, err := q.Next()
+		if err != nil || event == nil {
+			t.Fatalf("expected an event, got %v: %v", event, err)
+		}
+		claimed = append(claimed, event.Id)
+	}
+	// claimed[0] becomes an orphaned claim of this worker id, the others are broken below
+	statements := []string{
+		`UPDATE queue_inflight SET claimed_epoch = 'crashed' WHERE id = ?`,
+		`UPDATE queue_inflight SET claimed_by = 'worker-2' WHERE id IN (?, ?, ?, ?)`,
+		`UPDATE queue_inflight SET claim_expires = NULL WHERE id = ?`,
+		`UPDATE queue_inflight SET claim_expires = datetime('now', '+1 day', 'utc') WHERE id = ?`,
+		`UPDATE queue_inflight SET claim_expires = datetime('now', '-1 hour', 'utc') WHERE id = ?`,
+	}
+	args := [][]any{{claimed[0]}, {claimed[1], claimed[2], claimed[3], claimed[4]}, {claimed[1]}, {claimed[2]}, {claimed[3]}}
+	for i, statement := range statements {
+		if _, err := q.db.Exec(statement, args[i]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Nack(claimed[4]); err != nil {
+		t.Fatal(err)
+	}
+	q.WithMaxRetires(0)
+
+	report, err := q.VerifyWarmStart(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := WarmStartReport{OrphanedClaims: 1, UnexpiringClaims: 1, OverlongClaims: 1, ExpiredClaims: 1, ExhaustedEvents: 1}
+	if report != expected {
+		t.Fatalf("expected %+v, got %+v", expected, report)
+	}
+	if len(report.Problems()) != 5 {
+		t.Fatalf("expected 5 problems, got %v", report.Problems())
+	}
+
+	report, err = q.VerifyWarmStart(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Repaired {
+		t.Fatal("expected the problems to be repaired")
+	}
+	report, err = q.VerifyWarmStart(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := report.Problems(); len(problems) != 0 {
+		t.Fatalf("expected no problems after repairing, got %v", problems)
+	}
+	if size, err := q.DeadSize(); err != nil || size != 1 {
+		t.Fatalf("expected the exhausted event to be dead lettered, got %d: %v", size, err)
+	}
+	// The overlong claim is kept, just shortened
+	if size, err := q.Size(); err != nil || size != 4 {
+		t.Fatalf("expected 4 live events, got %d: %v", size, err)
+	}
+}
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// Events without a delivery window, or whose window is open right now. The position of the
+// current local time relative to the window's start is compared with the window's length,
+// both modulo a day, so windows spanning midnight work too
+const IN_DELIVERY_WINDOW_CONDITION = `(window_start IS NULL OR
+((unixepoch() + window_offset) % 86400 - window_start + 86400) % 86400 < (window_end - window_start + 86400) % 86400)`
+
+// The hours of the day during which events may be delivered, e.g. 08:00-20:00 in the
+// recipient's time zone for notifications with quiet hours
+type DeliveryWindow struct {
+	// Offsets from local midnight. End may be before Start for windows spanning midnight,
+	// equal offsets mean the window is always open
+	Start time.Duration
+	End   time.Duration
+	// Time zone the offsets are in, UTC when nil
+	Location *time.Location
+}
+
+// Whether the window is open at t
+func (w DeliveryWindow) Contains(t time.Time) bool {
+	start, length := w.bounds()
+	if length == 0 {
+		return true
+	}
+	local := t.In(w.location())
+	seconds := local.Hour()*3600 + local.Minute()*60 + local.Second()
+	return ((seconds-start)%secondsPerDay+secondsPerDay)%secondsPerDay < length
+}
+
+func (w DeliveryWindow) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
+
+// Start in seconds since midnight and length in seconds, both within a day
+func (w DeliveryWindow) bounds() (int, int) {
+	start := ((int(w.Start.Seconds()) % secondsPerDay) + secondsPerDay) % secondsPerDay
+	end := ((int(w.End.Seconds()) % secondsPerDay) + secondsPerDay) % secondsPerDay
+	return start, (end - start + secondsPerDay) % secondsPerDay
+}
+
+// The window_start, window_end and window_offset column values, all NULL for a nil or
+// always open window. The offset from UTC is the one in effect at now, so a daylight saving
+// change between insert and delivery shifts the window by the difference
+func (w *DeliveryWindow) columns(now time.Time) []any {
+	if w == nil {
+		return []any{nil, nil, nil}
+	}
+	start, length := w.bounds()
+	if length == 0 {
+		return []any{nil, nil, nil}
+	}
+	_, offset := now.In(w.location()).Zone()
+	return []any{start, (start + length) % secondsPerDay, offset}
+}
+
+// The window stored in the window_start, window_end and window_offset columns, nil if there
+// is none
+func storedDeliveryWindow(start, end, offset sql.NullInt64) *DeliveryWindow {
+	if !start.Valid || !end.Valid {
+		return nil
+	}
+	return &DeliveryWindow{
+		Start:    time.Duration(start.Int64) * time.Second,
+		End:      time.Duration(end.Int64) * time.Second,
+		Location: time.FixedZone("", int(offset.Int64)),
+	}
+}
+
+// A window as encoded to JSON, e.g. in a QueueDiff, with the time zone reduced to the
+// offset from UTC in effect when it was encoded
+type encodedDeliveryWindow struct {
+	Start  int `json:"start"`
+	End    int `json:"end"`
+	Offset int `json:"offset"`
+}
+
+// Encode the window as an encodedDeliveryWindow
+func (w DeliveryWindow) MarshalJSON() ([]byte, error) {
+	_, offset := time.Now().In(w.location()).Zone()
+	return json.Marshal(encodedDeliveryWindow{Start: int(w.Start.Seconds()), End: int(w.End.Seconds()), Offset: offset})
+}
+
+// Decode an encodedDeliveryWindow, in a fixed time zone with its offset
+func (w *DeliveryWindow) UnmarshalJSON(data []byte) error {
+	var encoded encodedDeliveryWindow
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	*w = DeliveryWindow{
+		Start:    time.Duration(encoded.Start) * time.Second,
+		End:      time.Duration(encoded.End) * time.Second,
+		Location: time.FixedZone("", encoded.Offset),
+	}
+	return nil
+}
+
+// Only deliver the event while window is open. Events outside their window stay pending and
+// keep their place in the queue
+func WithEventDeliveryWindow(window DeliveryWindow) InsertOption {
+	return func(e *Envelope) {
+		e.DeliveryWindow = &window
+	}
+}
+
+// Only deliver events while window is open, e.g. to respect quiet hours. Outside the window
+// Next and Lease return nothing. Applies on top of windows set on individual events with
+// WithEventDeliveryWindow
+func (q *Queue[T]) WithDeliveryWindow(window DeliveryWindow) *Queue[T] {
+	q.deliveryWindow = &window
+	return q
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// A window around the current time of day in loc, shifted by from and to
+func windowAroundNow(loc *time.Location, from, to time.Duration) DeliveryWindow {
+	now := time.Now().In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	offset := now.Sub(midnight)
+	return DeliveryWindow{Start: offset + from, End: offset + to, Location: loc}
+}
+
+func TestDeliveryWindowContains(t *testing.T) {
+	night := DeliveryWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	for _, tc := range []struct {
+		hour int
+		open bool
+	}{{23, true}, {3, true}, {6, false}, {12, false}, {22, true}} {
+		at := time.Date(2024, 1, 1, tc.hour, 0, 0, 0, time.UTC)
+		if night.Contains(at) != tc.open {
+			t.Fatalf("expected window open at %02d:00 to be %v", tc.hour, tc.open)
+		}
+	}
+	if !(DeliveryWindow{}).Contains(time.Now()) {
+		t.Fatal("expected an empty window to always be open")
+	}
+}
+
+func TestEventDeliveryWindow(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	if err := q.Insert(Test{A: "quiet"}, WithEventDeliveryWindow(windowAroundNow(tokyo, 2*time.Hour, 3*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(Test{A: "open"}, WithEventDeliveryWindow(windowAroundNow(tokyo, -time.Hour, time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil || event.Content.A != "open" {
+		t.Fatalf("expected the event whose window is open, got %+v", event)
+	}
+	event, err = q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event != nil {
+		t.Fatalf("expected nothing to be delivered outside the window, got %+v", event)
+	}
+	// Still waiting for its window
+	if size, _ := q.Size(); size != 2 {
+		t.Fatalf("expected size 2, got %d", size)
+	}
+}
+
+func TestQueueDeliveryWindow(t *testing.T) {
+	type Test struct{ A string }
+	q := newTestQueue[Test](t).WithDeliveryWindow(windowAroundNow(time.UTC, 2*time.Hour, 3*time.Hour))
+
+	if err := q.Insert(Test{A: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event != nil {
+		t.Fatalf("expected nothing to be delivered outside the window, got %+v", event)
+	}
+
+	q.WithDeliveryWindow(windowAroundNow(time.UTC, -time.Hour, time.Hour))
+	event, err = q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil {
+		t.Fatal("expected the event once the window is open")
+	}
+}